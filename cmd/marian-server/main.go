@@ -0,0 +1,142 @@
+// Command marian-server serves Marian tokenization over HTTP.
+//
+// See package marian/server for the endpoints. Call counters and latencies
+// are published through expvar on /debug/vars. On SIGINT or SIGTERM the
+// server reports not-ready, stops accepting connections, waits for in-flight
+// requests (up to -shutdown-timeout) and then releases the tokenizer.
+//
+// On SIGHUP, or when -watch is set and the model files change, the
+// tokenizer is reloaded without interrupting requests.
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/internal/backends"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/server"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/textproc"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until SIGINT or SIGTERM, or until the listener fails. The
+// tokenizer is closed on every return.
+func run() error {
+	addr := flag.String("addr", ":8080", "listen address")
+	backend := flag.String("backend", "",
+		"tokenizer backend: "+strings.Join(marian.Backends(), ", ")+" (default: the first of them that reads the model)")
+	model := flag.String("model", "./models/opus-mt-ru-en", "model directory")
+	pre := flag.String("pre", "", "text processors to run before encoding ("+strings.Join(textproc.Names(), ", ")+")")
+	post := flag.String("post", "", "text processors to run after decoding ("+strings.Join(textproc.Names(), ", ")+")")
+	utf8 := flag.String("utf8", "", "invalid UTF-8 in encode input and decode output: replace, strip or error (default: left to the backend)")
+	maxBody := flag.Int64("max-body", 1<<20, "maximum request body size in bytes")
+	maxBatch := flag.Int("max-batch", 256, "maximum number of items in a batch request")
+	drain := flag.Duration("drain", 0, "time to report not-ready before shutting down")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "maximum time to wait for in-flight requests")
+	watch := flag.Duration("watch", 0, "interval for polling the model directory and reloading changed files (0: reload on SIGHUP only)")
+	flag.Parse()
+
+	opts := []marian.Option{marian.WithBackend(*backend)}
+	if *utf8 != "" {
+		policy, err := marian.ParseUTF8Policy(*utf8)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, marian.WithUTF8Policy(policy))
+	}
+	mws := []marian.Middleware{marian.Metrics("marian")}
+	process, err := textproc.Middleware(*pre, *post)
+	if err != nil {
+		return err
+	}
+	mws = append(mws, process)
+
+	tok, err := marian.NewReloadable(*model, marian.ReloadOptions{
+		Options:  opts,
+		Interval: *watch,
+		OnReload: func(err error) {
+			if err != nil {
+				log.Printf("marian-server: reload %s: %v", *model, err)
+				return
+			}
+			log.Printf("marian-server: reloaded %s", *model)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("open %s tokenizer: %w", backends.Name(*backend), err)
+	}
+	defer tok.Close()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := tok.Reload(); err != nil {
+				log.Printf("marian-server: reload %s: %v", *model, err)
+				continue
+			}
+			log.Printf("marian-server: reloaded %s", *model)
+		}
+	}()
+
+	h := server.New(marian.Wrap(tok, mws...), server.Options{
+		MaxBodyBytes: *maxBody,
+		MaxBatchSize: *maxBatch,
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/", h)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("marian-server: backend=%s model=%s listening on %s", backends.Name(*backend), *model, *addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("listen: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("marian-server: shutting down")
+	h.SetReady(false)
+	time.Sleep(*drain)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("marian-server: shutdown: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/internal/backends"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/batching"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/export"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/models"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/stdio"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/textproc"
+)
+
+const (
+	defaultModelDir  = "./models/opus-mt-ru-en"
+	defaultModelsDir = "./models"
+)
+
+var (
+	preUsage  = "text processors to run before encoding (" + strings.Join(textproc.Names(), ", ") + ")"
+	postUsage = "text processors to run after decoding (" + strings.Join(textproc.Names(), ", ") + ")"
+	utf8Usage = "invalid UTF-8 in encode input and decode output: replace, strip or error (default: left to the backend)"
+)
+
+// commonFlags are shared by every command that opens a tokenizer. Commands
+// that read inputs pass the default JSONL field; config passes "" and gets
+// no input flags.
+type commonFlags struct {
+	fs      *flag.FlagSet
+	backend string
+	model   string
+	models  string
+	pair    string
+	pre     string
+	post    string
+	utf8    string
+	in      inputOptions
+	out     string
+	echo    bool
+}
+
+func newCommonFlags(name, usage, inputField string) *commonFlags {
+	c := newTokenizerFlags(name, usage)
+	c.fs.StringVar(&c.out, "out", "jsonl", "output format: jsonl or json")
+	if inputField != "" {
+		c.fs.StringVar(&c.in.format, "in", "text", "input format: text, jsonl or tsv")
+		c.fs.StringVar(&c.in.field, "field", inputField, "JSONL object field to read")
+		c.fs.IntVar(&c.in.column, "column", 1, "TSV column to read (1-based)")
+		c.fs.BoolVar(&c.echo, "echo", false, "include the input in every output record")
+		c.fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: marian-tok %s [flags] [files...]\n\n%s\n\nFlags:\n", name, usage)
+			c.fs.PrintDefaults()
+		}
+	}
+	return c
+}
+
+// newTokenizerFlags returns the flags that select and configure the
+// tokenizer, for commands that neither read inputs nor write records.
+func newTokenizerFlags(name, usage string) *commonFlags {
+	c := &commonFlags{fs: flag.NewFlagSet(name, flag.ExitOnError)}
+	c.fs.StringVar(&c.backend, "backend", "",
+		"tokenizer backend: "+strings.Join(marian.Backends(), ", ")+" (default: the first of them that reads the model)")
+	c.fs.StringVar(&c.model, "model", defaultModelDir, "model directory")
+	c.fs.StringVar(&c.models, "models", defaultModelsDir, "directory of model directories searched by -pair")
+	c.fs.StringVar(&c.pair, "pair", "", "language pair <src>-<tgt> to look up in -models instead of -model")
+	c.fs.StringVar(&c.pre, "pre", "", preUsage)
+	c.fs.StringVar(&c.post, "post", "", postUsage)
+	c.fs.StringVar(&c.utf8, "utf8", "", utf8Usage)
+	c.fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: marian-tok %s [flags]\n\n%s\n\nFlags:\n", name, usage)
+		c.fs.PrintDefaults()
+	}
+	return c
+}
+
+func (c *commonFlags) open() (marian.Tokenizer, error) {
+	opts := []marian.Option{marian.WithBackend(c.backend)}
+	if c.utf8 != "" {
+		policy, err := marian.ParseUTF8Policy(c.utf8)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, marian.WithUTF8Policy(policy))
+	}
+	process, err := textproc.Middleware(c.pre, c.post)
+	if err != nil {
+		return nil, err
+	}
+	mws := []marian.Middleware{process}
+
+	model, stage, err := c.modelDir()
+	if err != nil {
+		return nil, err
+	}
+	mws = append(mws, stage.Middleware())
+
+	tok, err := marian.Open(model, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("open %s tokenizer: %w", backends.Name(c.backend), err)
+	}
+	return marian.Wrap(tok, mws...), nil
+}
+
+// modelDir returns the model directory: -model, or the model of the -pair
+// stage, which is returned with it. Without -pair the stage is empty and
+// its middleware changes nothing.
+func (c *commonFlags) modelDir() (string, models.Stage, error) {
+	if c.pair == "" {
+		return c.model, models.Stage{}, nil
+	}
+	stage, err := c.stage()
+	if err != nil {
+		return "", models.Stage{}, err
+	}
+	return stage.Model.Dir, stage, nil
+}
+
+// stage finds the model for -pair in -models. Pivot routes need a tokenizer
+// per stage, so only direct pairs are accepted.
+func (c *commonFlags) stage() (models.Stage, error) {
+	src, tgt, ok := strings.Cut(c.pair, "-")
+	if !ok || src == "" || tgt == "" {
+		return models.Stage{}, fmt.Errorf("-pair %q: want <src>-<tgt>", c.pair)
+	}
+	catalog, err := models.Scan(c.models)
+	if err != nil {
+		return models.Stage{}, err
+	}
+	route, err := catalog.Route(src, tgt)
+	if err != nil {
+		return models.Stage{}, err
+	}
+	if len(route) != 1 {
+		return models.Stage{}, fmt.Errorf("-pair %s: no direct model in %s, route %s (see marian-tok route)", c.pair, c.models, route)
+	}
+	return route[0], nil
+}
+
+// run opens the tokenizer and output, calls fn and closes both.
+func (c *commonFlags) run(fn func(tok marian.Tokenizer, out *output) error) error {
+	tok, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer tok.Close()
+
+	out, err := newOutput(os.Stdout, c.out)
+	if err != nil {
+		return err
+	}
+	if err := fn(tok, out); err != nil {
+		out.close()
+		return err
+	}
+	return out.close()
+}
+
+type encodeRecord struct {
+	Text   *string  `json:"text,omitempty"`
+	IDs    []int64  `json:"ids"`
+	Pieces []string `json:"pieces,omitempty"`
+}
+
+type decodeRecord struct {
+	IDs  []int64 `json:"ids,omitempty"`
+	Text string  `json:"text"`
+}
+
+type batchRecord struct {
+	Indices       []int     `json:"indices,omitempty"`
+	Texts         []string  `json:"texts,omitempty"`
+	InputIDs      [][]int64 `json:"input_ids"`
+	AttentionMask [][]int64 `json:"attention_mask"`
+}
+
+func runEncode(args []string) error {
+	c := newCommonFlags("encode", "Encode every input record into Marian token ids.", "text")
+	addEOS := c.fs.Bool("eos", true, "append the EOS token")
+	c.fs.Parse(args)
+
+	return c.run(func(tok marian.Tokenizer, out *output) error {
+		return readInputs(c.fs.Args(), c.in, func(it item) error {
+			text, err := it.asText()
+			if err != nil {
+				return err
+			}
+			ids, err := tok.Encode(text, *addEOS)
+			if err != nil {
+				return fmt.Errorf("%s: %w", it, err)
+			}
+			rec := encodeRecord{IDs: ids}
+			if c.echo {
+				rec.Text = &text
+			}
+			return out.emit(rec)
+		})
+	})
+}
+
+func runDecode(args []string) error {
+	c := newCommonFlags("decode",
+		"Decode every input record of token ids back into text.\n"+
+			"Text and TSV records hold ids separated by spaces or commas.", "ids")
+	skipSpecial := c.fs.Bool("skip-special", true, "drop EOS / PAD / UNK before decoding")
+	c.fs.Parse(args)
+
+	return c.run(func(tok marian.Tokenizer, out *output) error {
+		return readInputs(c.fs.Args(), c.in, func(it item) error {
+			ids, err := it.asIDs()
+			if err != nil {
+				return err
+			}
+			text, err := tok.Decode(ids, *skipSpecial)
+			if err != nil {
+				return fmt.Errorf("%s: %w", it, err)
+			}
+			rec := decodeRecord{Text: text}
+			if c.echo {
+				rec.IDs = ids
+			}
+			return out.emit(rec)
+		})
+	})
+}
+
+func runBatch(args []string) error {
+	c := newCommonFlags("batch",
+		"Encode input records with EncodeBatch and print padded input_ids and\n"+
+			"attention_mask, one record per batch.", "text")
+	size := c.fs.Int("size", 32, "sentences per batch (0 puts all inputs in one batch)")
+	maxTokens := c.fs.Int("max-tokens", 0, "group inputs of similar length into batches of at most this many tokens,\n"+
+		"padding included (reads all inputs first; records carry the input indices)")
+	c.fs.Parse(args)
+
+	if *size < 0 {
+		return fmt.Errorf("batch size must be >= 0, got %d", *size)
+	}
+	if *maxTokens < 0 {
+		return fmt.Errorf("max tokens must be >= 0, got %d", *maxTokens)
+	}
+	if *maxTokens > 0 {
+		return runBudgetBatch(c, *size, *maxTokens)
+	}
+
+	return c.run(func(tok marian.Tokenizer, out *output) error {
+		var texts []string
+
+		flush := func() error {
+			if len(texts) == 0 {
+				return nil
+			}
+			ids, mask, err := tok.EncodeBatch(texts)
+			if err != nil {
+				return err
+			}
+			rec := batchRecord{InputIDs: ids, AttentionMask: mask}
+			if c.echo {
+				rec.Texts = texts
+			}
+			texts = nil
+			return out.emit(rec)
+		}
+
+		err := readInputs(c.fs.Args(), c.in, func(it item) error {
+			text, err := it.asText()
+			if err != nil {
+				return err
+			}
+			texts = append(texts, text)
+			if *size > 0 && len(texts) == *size {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return flush()
+	})
+}
+
+// runBudgetBatch reads every input and emits length-bucketed batches.
+func runBudgetBatch(c *commonFlags, size, maxTokens int) error {
+	return c.run(func(tok marian.Tokenizer, out *output) error {
+		var texts []string
+		err := readInputs(c.fs.Args(), c.in, func(it item) error {
+			text, err := it.asText()
+			if err != nil {
+				return err
+			}
+			texts = append(texts, text)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		batches, err := batching.Encode(tok, texts, batching.Options{MaxTokens: maxTokens, MaxRows: size})
+		if err != nil {
+			return err
+		}
+		for _, b := range batches {
+			rec := batchRecord{Indices: b.Indices, InputIDs: b.InputIDs, AttentionMask: b.AttentionMask}
+			if c.echo {
+				for _, i := range b.Indices {
+					rec.Texts = append(rec.Texts, texts[i])
+				}
+			}
+			if err := out.emit(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func runPieces(args []string) error {
+	c := newCommonFlags("pieces",
+		"Encode every input record and show the vocabulary piece of each id.", "text")
+	addEOS := c.fs.Bool("eos", false, "append the EOS token")
+	vocabPath := c.fs.String("vocab", "", "vocab.json to map ids to pieces (default: the tokenizer's or the model's source vocabulary)")
+	c.fs.Parse(args)
+
+	return c.run(func(tok marian.Tokenizer, out *output) error {
+		token, err := c.pieces(tok, *vocabPath)
+		if err != nil {
+			return err
+		}
+		return readInputs(c.fs.Args(), c.in, func(it item) error {
+			text, err := it.asText()
+			if err != nil {
+				return err
+			}
+			ids, err := tok.Encode(text, *addEOS)
+			if err != nil {
+				return fmt.Errorf("%s: %w", it, err)
+			}
+			pieces := make([]string, len(ids))
+			for i, id := range ids {
+				pieces[i] = token(id)
+			}
+			rec := encodeRecord{IDs: ids, Pieces: pieces}
+			if c.echo {
+				rec.Text = &text
+			}
+			return out.emit(rec)
+		})
+	})
+}
+
+// pieceTokenizer is implemented by backends that map ids to pieces
+// themselves, such as v4, which may read a model without vocab.json.
+type pieceTokenizer interface {
+	Token(id int64) string
+}
+
+// pieces returns the function mapping ids to pieces for tok: the vocabulary
+// at vocabPath when set, the backend's own pieces, or the source vocabulary
+// of the model directory.
+func (c *commonFlags) pieces(tok marian.Tokenizer, vocabPath string) (func(int64) string, error) {
+	if vocabPath != "" {
+		vocab, err := marian.LoadVocab(vocabPath)
+		if err != nil {
+			return nil, fmt.Errorf("load vocab: %w", err)
+		}
+		return vocab.Token, nil
+	}
+	for t := tok; t != nil; t = marian.Unwrap(t) {
+		if p, ok := t.(pieceTokenizer); ok {
+			return p.Token, nil
+		}
+	}
+	dir, _, err := c.modelDir()
+	if err != nil {
+		return nil, err
+	}
+	model, err := marian.LoadModel(dir)
+	if err != nil {
+		return nil, err
+	}
+	return model.SourceVocab.Token, nil
+}
+
+func runConfig(args []string) error {
+	c := newCommonFlags("config", "Print the tokenizer configuration.", "")
+	c.fs.Parse(args)
+
+	return c.run(func(tok marian.Tokenizer, out *output) error {
+		cfg, err := tok.Config()
+		if err != nil {
+			return err
+		}
+		return out.emit(cfg)
+	})
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	model := fs.String("model", defaultModelDir, "model directory")
+	format := fs.String("format", "marian", "output format: marian (vocab.yml), ct2 (CTranslate2 vocabulary JSON)\n"+
+		"or hf (HuggingFace tokenizer.json for the source side)")
+	dir := fs.String("o", "", "output directory (required)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: marian-tok export -o <dir> [flags]\n\n"+
+			"Write the model's tokenizer files in the format of another runtime, with the\n"+
+			"same ids and special tokens. The written paths are printed to stdout.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *dir == "" {
+		fs.Usage()
+		return fmt.Errorf("-o is required")
+	}
+
+	m, err := marian.LoadModel(*model)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	switch *format {
+	case "marian":
+		paths, err = export.MarianVocab(m, *dir)
+	case "ct2":
+		paths, err = export.CTranslate2Vocab(m, *dir)
+	case "hf":
+		var path string
+		path, err = export.HFTokenizerFile(m, *dir)
+		paths = []string{path}
+	default:
+		return fmt.Errorf("unknown format %q (want marian, ct2 or hf)", *format)
+	}
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		fmt.Println(p)
+	}
+	return nil
+}
+
+func runRPC(args []string) error {
+	c := newTokenizerFlags("rpc",
+		"Serve line-delimited JSON-RPC requests on stdin and write responses to\n"+
+			"stdout until stdin is closed. See package marian/stdio for the protocol.")
+	c.fs.Parse(args)
+
+	tok, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer tok.Close()
+
+	return stdio.Serve(tok, os.Stdin, os.Stdout)
+}
+
+func runRoute(args []string) error {
+	fs := flag.NewFlagSet("route", flag.ExitOnError)
+	dir := fs.String("models", defaultModelsDir, "directory of model directories")
+	from := fs.String("from", "", "source language (required)")
+	to := fs.String("to", "", "target language (required)")
+	format := fs.String("out", "jsonl", "output format: jsonl or json")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: marian-tok route -from <lang> -to <lang> [flags]\n\n"+
+			"Print the models that translate between two languages, one stage per record:\n"+
+			"a direct model, or a pivot route such as ru -> en -> de. Stages of models with\n"+
+			"several target languages name the >>lang<< token to start the input with.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		fs.Usage()
+		return fmt.Errorf("-from and -to are required")
+	}
+
+	catalog, err := models.Scan(*dir)
+	if err != nil {
+		return err
+	}
+	route, err := catalog.Route(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	out, err := newOutput(os.Stdout, *format)
+	if err != nil {
+		return err
+	}
+	for _, stage := range route {
+		if err := out.emit(stage); err != nil {
+			out.close()
+			return err
+		}
+	}
+	return out.close()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// item is one input record. raw is set only for JSONL input and holds the
+// selected JSON value; text holds the plain text (or TSV column) otherwise.
+type item struct {
+	src  string
+	line int
+	text string
+	raw  json.RawMessage
+}
+
+func (it item) String() string {
+	return fmt.Sprintf("%s:%d", it.src, it.line)
+}
+
+// asText returns the record as a sentence.
+func (it item) asText() (string, error) {
+	if it.raw == nil {
+		return it.text, nil
+	}
+	var s string
+	if err := json.Unmarshal(it.raw, &s); err != nil {
+		return "", fmt.Errorf("%s: expected a JSON string: %w", it, err)
+	}
+	return s, nil
+}
+
+// asIDs returns the record as a sequence of token ids. Plain text and TSV
+// accept ids separated by spaces and/or commas, optionally in brackets.
+func (it item) asIDs() ([]int64, error) {
+	if it.raw != nil {
+		var ids []int64
+		if err := json.Unmarshal(it.raw, &ids); err != nil {
+			return nil, fmt.Errorf("%s: expected a JSON array of ids: %w", it, err)
+		}
+		return ids, nil
+	}
+
+	s := strings.Trim(strings.TrimSpace(it.text), "[]")
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	ids := make([]int64, 0, len(fields))
+	for _, f := range fields {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad token id %q", it, f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// inputOptions describes how to split the input into records.
+type inputOptions struct {
+	format string // text | jsonl | tsv
+	field  string // JSONL object field
+	column int    // TSV column, 1-based
+}
+
+// readInputs calls fn for every record in files (stdin when empty or "-").
+func readInputs(files []string, opts inputOptions, fn func(item) error) error {
+	switch opts.format {
+	case "text", "jsonl", "tsv":
+	default:
+		return fmt.Errorf("unknown input format %q (want text, jsonl or tsv)", opts.format)
+	}
+	if opts.format == "tsv" && opts.column < 1 {
+		return fmt.Errorf("tsv column must be >= 1, got %d", opts.column)
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		if err := readFile(name, opts, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(name string, opts inputOptions, fn func(item) error) error {
+	var r io.Reader
+	src := name
+	if name == "-" {
+		r = os.Stdin
+		src = "<stdin>"
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	line := 0
+	for sc.Scan() {
+		line++
+		s := strings.TrimSuffix(sc.Text(), "\r")
+		it := item{src: src, line: line}
+
+		switch opts.format {
+		case "text":
+			it.text = s
+		case "tsv":
+			cols := strings.Split(s, "\t")
+			if opts.column > len(cols) {
+				return fmt.Errorf("%s: line has %d columns, want column %d", it, len(cols), opts.column)
+			}
+			it.text = cols[opts.column-1]
+		case "jsonl":
+			b := bytes.TrimSpace([]byte(s))
+			if len(b) == 0 {
+				continue
+			}
+			raw, err := jsonField(b, opts.field)
+			if err != nil {
+				return fmt.Errorf("%s: %w", it, err)
+			}
+			it.raw = raw
+		}
+
+		if err := fn(it); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// jsonField returns b itself unless it is an object, in which case it
+// returns the value of field.
+func jsonField(b []byte, field string) (json.RawMessage, error) {
+	if b[0] != '{' {
+		return json.RawMessage(b), nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	v, ok := obj[field]
+	if !ok {
+		return nil, fmt.Errorf("object has no field %q", field)
+	}
+	return v, nil
+}
+
+// output writes results as JSON Lines, or collects them into one JSON array
+// that is written on close.
+type output struct {
+	w      *bufio.Writer
+	enc    *json.Encoder
+	format string
+	values []any
+}
+
+func newOutput(w io.Writer, format string) (*output, error) {
+	switch format {
+	case "json", "jsonl":
+	default:
+		return nil, fmt.Errorf("unknown output format %q (want json or jsonl)", format)
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	if format == "json" {
+		enc.SetIndent("", "  ")
+	}
+	return &output{w: bw, enc: enc, format: format}, nil
+}
+
+func (o *output) emit(v any) error {
+	if o.format == "json" {
+		o.values = append(o.values, v)
+		return nil
+	}
+	return o.enc.Encode(v)
+}
+
+func (o *output) close() error {
+	if o.format == "json" {
+		values := o.values
+		if values == nil {
+			values = []any{}
+		}
+		if err := o.enc.Encode(values); err != nil {
+			return err
+		}
+	}
+	return o.w.Flush()
+}
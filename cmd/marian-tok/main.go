@@ -0,0 +1,85 @@
+// Command marian-tok tokenizes text with a Marian tokenizer from the shell.
+//
+// Usage:
+//
+//	marian-tok <command> [flags] [files...]
+//
+// Commands:
+//
+//	encode   encode each input line into token ids
+//	decode   decode each input line of token ids back to text
+//	batch    encode inputs with EncodeBatch (padded input_ids + attention_mask)
+//	pieces   show the vocabulary pieces of each encoded input
+//	config   print the tokenizer configuration
+//	export   write vocab.yml, CTranslate2 vocabularies or tokenizer.json
+//	rpc      serve line-delimited JSON-RPC requests on stdin/stdout
+//	route    print the models that translate between two languages
+//
+// With -pair <src>-<tgt>, the model is looked up in the -models directory
+// instead of given with -model.
+//
+// Inputs are read from the given files, or from stdin when no file (or "-")
+// is given. Results are written to stdout as JSON Lines (default) or as a
+// single JSON document.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	short string
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"encode", "encode each input line into token ids", runEncode},
+		{"decode", "decode each input line of token ids back to text", runDecode},
+		{"batch", "encode inputs with EncodeBatch (input_ids + attention_mask)", runBatch},
+		{"pieces", "show the vocabulary pieces of each encoded input", runPieces},
+		{"config", "print the tokenizer configuration", runConfig},
+		{"export", "write vocab.yml, CTranslate2 vocabularies or tokenizer.json", runExport},
+		{"rpc", "serve line-delimited JSON-RPC requests on stdin/stdout", runRPC},
+		{"route", "print the models that translate between two languages", runRoute},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: marian-tok <command> [flags] [files...]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'marian-tok <command> -h' for command flags.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "marian-tok %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "marian-tok: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
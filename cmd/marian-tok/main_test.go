@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bpeModel is a tokenizer.json-only model directory, readable by v4 only:
+// "hello world" encodes to 17 18 9 11 8 12, EOS is 1.
+const bpeModel = "../../marian_v4/testdata/bpe"
+
+// runCommand runs a command with stdin as standard input and returns what
+// it writes to standard output.
+func runCommand(t *testing.T, stdin string, run func([]string) error, args ...string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	in, err := os.Create(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if _, err := in.WriteString(stdin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	oldIn, oldOut := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = in, out
+	runErr := run(args)
+	os.Stdin, os.Stdout = oldIn, oldOut
+
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), runErr
+}
+
+// modelsDir returns a -models directory holding the BPE model as an
+// en -> xx model.
+func modelsDir(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "opus-mt-en-xx")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(bpeModel, "tokenizer.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tokenizer.json"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestCommands(t *testing.T) {
+	models := modelsDir(t)
+	vocab := filepath.Join(t.TempDir(), "vocab.json")
+	if err := os.WriteFile(vocab, []byte(`{"a": 17, "b": 18}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		run   func([]string) error
+		args  []string
+		stdin string
+		want  string
+	}{
+		{"encode", runEncode, []string{"-backend", "v4", "-model", bpeModel}, "hello world\nhello\n",
+			`{"ids":[17,18,9,11,8,12,1]}` + "\n" + `{"ids":[17,1]}` + "\n"},
+		{"encode echo", runEncode, []string{"-model", bpeModel, "-eos=false", "-echo"}, "hello\n",
+			`{"text":"hello","ids":[17]}` + "\n"},
+		{"encode jsonl", runEncode, []string{"-model", bpeModel, "-in", "jsonl"}, `{"text": "hello"}` + "\n" + `"world"` + "\n",
+			`{"ids":[17,1]}` + "\n" + `{"ids":[18,9,11,8,12,1]}` + "\n"},
+		{"encode utf8", runEncode, []string{"-model", bpeModel, "-utf8", "strip"}, "hel\xfflo\n",
+			`{"ids":[17,1]}` + "\n"},
+		{"encode pair", runEncode, []string{"-models", models, "-pair", "en-xx"}, "hello\n",
+			`{"ids":[17,1]}` + "\n"},
+		{"decode", runDecode, []string{"-model", bpeModel}, "17 18 9 11 8 12 1\n17,1\n",
+			`{"text":"hello world"}` + "\n" + `{"text":"hello"}` + "\n"},
+		{"batch", runBatch, []string{"-model", bpeModel}, "hello\nworld\n",
+			`{"input_ids":[[17,1,2,2,2,2],[18,9,11,8,12,1]],"attention_mask":[[1,1,0,0,0,0],[1,1,1,1,1,1]]}` + "\n"},
+		// tokenizer.json-only: the pieces come from the tokenizer.
+		{"pieces", runPieces, []string{"-model", bpeModel}, "hello world\n",
+			`{"ids":[17,18,9,11,8,12],"pieces":["▁hello","▁w","o","r","l","d"]}` + "\n"},
+		{"pieces vocab", runPieces, []string{"-model", bpeModel, "-vocab", vocab}, "hello world\n",
+			`{"ids":[17,18,9,11,8,12],"pieces":["a","b","","","",""]}` + "\n"},
+		{"rpc", runRPC, []string{"-models", models, "-pair", "en-xx"}, `{"id": 1, "method": "encode", "params": {"text": "hello"}}` + "\n",
+			`{"jsonrpc":"2.0","id":1,"result":{"ids":[17,1]}}` + "\n"},
+	}
+	for _, tt := range tests {
+		got, err := runCommand(t, tt.stdin, tt.run, tt.args...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	models := modelsDir(t)
+	tests := []struct {
+		name  string
+		run   func([]string) error
+		args  []string
+		stdin string
+		want  string
+	}{
+		{"out", runEncode, []string{"-model", bpeModel, "-out", "xml"}, "hello\n", "xml"},
+		{"utf8 policy", runEncode, []string{"-model", bpeModel, "-utf8", "ignore"}, "hello\n", "ignore"},
+		{"utf8 error", runEncode, []string{"-model", bpeModel, "-utf8", "error"}, "hel\xfflo\n", "invalid UTF-8 at byte 3"},
+		{"pair", runEncode, []string{"-models", models, "-pair", "en"}, "hello\n", "want <src>-<tgt>"},
+		{"route", runRPC, []string{"-models", models, "-pair", "xx-en"}, "", "no route"},
+		{"model", runConfig, []string{"-model", t.TempDir()}, "", "open"},
+	}
+	for _, tt := range tests {
+		_, err := runCommand(t, tt.stdin, tt.run, tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want an error mentioning %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -33,6 +33,56 @@ typedef void* marian_tok_t;
 //   - target.spm
 MARIAN_API marian_tok_t marian_tok_new(const char* model_dir);
 
+// Load-time options for marian_tok_new_ex. Initialize with
+// marian_tok_options_init, then set the fields to override.
+//
+// Whether batch encoding appends EOS is not an option here: it is chosen
+// per call with the add_eos argument of marian_tok_encode_batch(_n).
+typedef struct marian_tok_options {
+    // > 0: overrides model_max_length from config.json; 0 keeps it.
+    int model_max_length;
+    // Token that source pieces missing from vocab.json map to.
+    // NULL or "": "<unk>" (id 1 when missing from the vocabulary).
+    const char* unk_token;
+    // Ids removed by marian_tok_decode with skip_special = 1.
+    // num_skip_ids < 0: EOS, pad and unk.
+    const long long* skip_ids;
+    int num_skip_ids;
+    // Paths of the model files. NULL or "": config.json, vocab.json,
+    // source.spm and target.spm in model_dir. source_spm_path and
+    // target_spm_path may name the same file, which is then loaded once.
+    const char* config_path;
+    const char* vocab_path;
+    const char* source_spm_path;
+    const char* target_spm_path;
+} marian_tok_options;
+
+// Error codes reported by marian_tok_new_ex.
+enum {
+    MARIAN_TOK_OK = 0,
+    MARIAN_TOK_ERR_ARGS = -1,         // invalid options, or no path for a file
+    MARIAN_TOK_ERR_CONFIG = -2,       // config.json missing or invalid
+    MARIAN_TOK_ERR_VOCAB = -3,        // vocab.json missing or invalid
+    MARIAN_TOK_ERR_SPM = -4,          // source.spm or target.spm failed to load
+    MARIAN_TOK_ERR_UNK_TOKEN = -5,    // unk_token is not in vocab.json
+    MARIAN_TOK_ERR_SKIP_ID = -6       // a skip id is outside the vocabulary
+};
+
+// Set opts to the defaults (the behaviour of marian_tok_new).
+MARIAN_API void marian_tok_options_init(marian_tok_options* opts);
+
+// Like marian_tok_new, with options (NULL: defaults). On failure it returns
+// NULL and, if out_err is not NULL, stores one of the MARIAN_TOK_ERR_* codes.
+// model_dir may be NULL when opts names all four files.
+//
+// The config returned by marian_tok_get_config_json reflects the options:
+// model_max_length is overridden, and unk_token_id and skip_token_ids are
+// added.
+MARIAN_API marian_tok_t marian_tok_new_ex(
+        const char* model_dir,
+        const marian_tok_options* opts,
+        int* out_err);
+
 // Destroy a previously created Marian tokenizer instance.
 MARIAN_API void marian_tok_free(marian_tok_t handle);
 
@@ -58,6 +108,19 @@ MARIAN_API int marian_tok_encode(
         int max_ids,
         int add_eos);
 
+// Encode text_len bytes of UTF-8 text into Marian token ids.
+//
+// Like marian_tok_encode, but the text does not need to be NUL-terminated
+// and may contain NUL bytes, which are tokenized like any other character.
+// text may be NULL when text_len is 0.
+MARIAN_API int marian_tok_encode_n(
+        marian_tok_t handle,
+        const char* text,
+        size_t text_len,
+        long long* out_ids,
+        int max_ids,
+        int add_eos);
+
 // Batch-encode UTF-8 texts into Marian token ids.
 //
 // texts:       array of C-string pointers of length batch_size
@@ -77,6 +140,21 @@ MARIAN_API int marian_tok_encode_batch(
         int* out_seq_lens,
         int add_eos);
 
+// Batch-encode UTF-8 texts with explicit lengths into Marian token ids.
+//
+// Like marian_tok_encode_batch, but texts[i] holds text_lens[i] bytes that
+// do not need to be NUL-terminated and may contain NUL bytes. texts[i] may be
+// NULL when text_lens[i] is 0; it is then encoded as an empty string.
+MARIAN_API int marian_tok_encode_batch_n(
+        marian_tok_t handle,
+        const char* const* texts,
+        const size_t* text_lens,
+        int batch_size,
+        int max_len,
+        long long* out_ids,
+        int* out_seq_lens,
+        int add_eos);
+
 // Build attention masks from sequence lengths.
 //
 // seq_lens: size [batch_size]
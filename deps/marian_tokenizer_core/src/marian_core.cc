@@ -4,6 +4,7 @@
 #include "sentencepiece_processor.h"
 #include "json.hpp"
 
+#include <algorithm>
 #include <string>
 #include <vector>
 #include <unordered_map>
@@ -32,6 +33,8 @@ struct MarianCoreConfig {
 struct MarianCore {
     SentencePieceProcessor sp_source;
     SentencePieceProcessor sp_target;
+    // &sp_target, or &sp_source when both sides share one model file.
+    SentencePieceProcessor* target = nullptr;
 
     MarianCoreConfig cfg;
     std::string cfg_json;
@@ -52,6 +55,26 @@ static bool load_file(const std::string& path, std::string& out) {
     return true;
 }
 
+// Path of a model file: the explicit path when set, otherwise name in
+// model_dir. Returns false when neither is available.
+static bool model_file(
+        const char* model_dir,
+        const char* path,
+        const char* name,
+        std::string& out) {
+    if (path && path[0] != '\0') {
+        out = path;
+        return true;
+    }
+    if (!model_dir) return false;
+    out = model_dir;
+    if (!out.empty() && out.back() != '/' && out.back() != '\\') {
+        out += '/';
+    }
+    out += name;
+    return true;
+}
+
 static bool parse_config(const std::string& json_str, MarianCoreConfig& cfg) {
     try {
         json j = json::parse(json_str);
@@ -121,6 +144,94 @@ static bool parse_vocab(
     }
 }
 
+// Encode text with the source SentencePiece model and map the pieces to
+// Marian ids; the result (including EOS) is truncated to model_max_length.
+static bool encode_ids(
+        MarianCore* core,
+        const std::string& text,
+        int add_eos,
+        std::vector<long long>& ids) {
+    std::vector<std::string> pieces;
+    auto status = core->sp_source.Encode(text, &pieces);
+    if (!status.ok()) return false;
+
+    ids.clear();
+    ids.reserve(pieces.size() + 1);
+
+    for (const auto& p : pieces) {
+        auto it = core->token2id.find(p);
+        if (it != core->token2id.end()) {
+            ids.push_back(it->second);
+        } else {
+            ids.push_back(core->unk_id);
+        }
+    }
+
+    if (add_eos) {
+        ids.push_back(core->cfg.eos_id);
+    }
+
+    if ((int)ids.size() > core->cfg.model_max_length) {
+        ids.resize(core->cfg.model_max_length);
+    }
+    return true;
+}
+
+// Shared implementation of marian_tok_encode_batch and
+// marian_tok_encode_batch_n. text_lens is NULL for NUL-terminated texts.
+static int encode_batch(
+        MarianCore* core,
+        const char* const* texts,
+        const size_t* text_lens,
+        int batch_size,
+        int max_len,
+        long long* out_ids,
+        int* out_seq_lens,
+        int add_eos) {
+    int global_max_len = 0;
+
+    for (int b = 0; b < batch_size; ++b) {
+        const char* t = texts[b];
+        std::string text;
+        if (text_lens) {
+            if (!t && text_lens[b] > 0) return -1;
+            if (t) text.assign(t, text_lens[b]);
+        } else {
+            if (!t) {
+                out_seq_lens[b] = 0;
+                continue;
+            }
+            text = t;
+        }
+
+        std::vector<long long> ids;
+        if (!encode_ids(core, text, add_eos, ids)) return -2;
+
+        int seq_len = (int)ids.size();
+        if (seq_len > max_len) {
+            // buffer is too small
+            return -3;
+        }
+
+        out_seq_lens[b] = seq_len;
+        if (seq_len > global_max_len) {
+            global_max_len = seq_len;
+        }
+
+        // fills a strings in out_ids with padding
+        int row_offset = b * max_len;
+        int j = 0;
+        for (; j < seq_len; ++j) {
+            out_ids[row_offset + j] = ids[j];
+        }
+        for (; j < max_len; ++j) {
+            out_ids[row_offset + j] = core->cfg.pad_id;
+        }
+    }
+
+    return global_max_len; // actual maximum sequence length in the batch
+}
+
 extern "C" {
 
 // Create a Marian tokenizer instance from a model directory.
@@ -131,55 +242,142 @@ extern "C" {
 //   - source.spm
 //   - target.spm
 marian_tok_t marian_tok_new(const char* model_dir_cstr) {
-    if (!model_dir_cstr) return nullptr;
+    return marian_tok_new_ex(model_dir_cstr, nullptr, nullptr);
+}
 
-    auto* core = new MarianCore();
+// Set opts to the defaults (the behaviour of marian_tok_new).
+void marian_tok_options_init(marian_tok_options* opts) {
+    if (!opts) return;
+    opts->model_max_length = 0;
+    opts->unk_token = nullptr;
+    opts->skip_ids = nullptr;
+    opts->num_skip_ids = -1;
+    opts->config_path = nullptr;
+    opts->vocab_path = nullptr;
+    opts->source_spm_path = nullptr;
+    opts->target_spm_path = nullptr;
+}
 
-    std::string model_dir(model_dir_cstr);
+// Like marian_tok_new, with options (NULL: defaults). On failure it returns
+// NULL and, if out_err is not NULL, stores one of the MARIAN_TOK_ERR_* codes.
+marian_tok_t marian_tok_new_ex(
+        const char* model_dir_cstr,
+        const marian_tok_options* opts,
+        int* out_err) {
+    int err_storage = MARIAN_TOK_OK;
+    int& err = out_err ? *out_err : err_storage;
+    err = MARIAN_TOK_OK;
+
+    marian_tok_options defaults;
+    marian_tok_options_init(&defaults);
+    if (!opts) opts = &defaults;
+
+    std::string config_path, vocab_path, source_spm_path, target_spm_path;
+    if (opts->model_max_length < 0 || (opts->num_skip_ids > 0 && !opts->skip_ids) ||
+        !model_file(model_dir_cstr, opts->config_path, "config.json", config_path) ||
+        !model_file(model_dir_cstr, opts->vocab_path, "vocab.json", vocab_path) ||
+        !model_file(model_dir_cstr, opts->source_spm_path, "source.spm", source_spm_path) ||
+        !model_file(model_dir_cstr, opts->target_spm_path, "target.spm", target_spm_path)) {
+        err = MARIAN_TOK_ERR_ARGS;
+        return nullptr;
+    }
+
+    auto* core = new MarianCore();
 
     // 1) config.json
     std::string cfg_str;
-    if (!load_file(model_dir + "/config.json", cfg_str)) {
+    if (!load_file(config_path, cfg_str)) {
         delete core;
+        err = MARIAN_TOK_ERR_CONFIG;
         return nullptr;
     }
     if (!parse_config(cfg_str, core->cfg)) {
         delete core;
+        err = MARIAN_TOK_ERR_CONFIG;
         return nullptr;
     }
-    core->cfg_json = cfg_str;
+    if (opts->model_max_length > 0) {
+        core->cfg.model_max_length = opts->model_max_length;
+    }
 
     // 2) vocab.json
     std::string vocab_str;
-    if (!load_file(model_dir + "/vocab.json", vocab_str)) {
+    if (!load_file(vocab_path, vocab_str)) {
         delete core;
+        err = MARIAN_TOK_ERR_VOCAB;
         return nullptr;
     }
     if (!parse_vocab(vocab_str, core->token2id, core->id2token)) {
         delete core;
+        err = MARIAN_TOK_ERR_VOCAB;
         return nullptr;
     }
 
-    // 3) sentencepiece models
-    auto status_src = core->sp_source.Load(model_dir + "/source.spm");
+    // 3) sentencepiece models, loaded once when shared
+    auto status_src = core->sp_source.Load(source_spm_path);
     if (!status_src.ok()) {
         delete core;
+        err = MARIAN_TOK_ERR_SPM;
         return nullptr;
     }
-    auto status_tgt = core->sp_target.Load(model_dir + "/target.spm");
-    if (!status_tgt.ok()) {
-        delete core;
-        return nullptr;
+    core->target = &core->sp_source;
+    if (target_spm_path != source_spm_path) {
+        auto status_tgt = core->sp_target.Load(target_spm_path);
+        if (!status_tgt.ok()) {
+            delete core;
+            err = MARIAN_TOK_ERR_SPM;
+            return nullptr;
+        }
+        core->target = &core->sp_target;
     }
 
     // 4) special tokens
-    auto it_unk = core->token2id.find("<unk>");
-    core->unk_id = (it_unk != core->token2id.end()) ? it_unk->second : 1;
+    if (opts->unk_token && opts->unk_token[0] != '\0') {
+        auto it_unk = core->token2id.find(opts->unk_token);
+        if (it_unk == core->token2id.end()) {
+            delete core;
+            err = MARIAN_TOK_ERR_UNK_TOKEN;
+            return nullptr;
+        }
+        core->unk_id = it_unk->second;
+    } else {
+        auto it_unk = core->token2id.find("<unk>");
+        core->unk_id = (it_unk != core->token2id.end()) ? it_unk->second : 1;
+    }
 
     core->special_ids.clear();
-    core->special_ids.insert(core->cfg.eos_id);
-    core->special_ids.insert(core->cfg.pad_id);
-    core->special_ids.insert(core->unk_id);
+    if (opts->num_skip_ids < 0) {
+        core->special_ids.insert(core->cfg.eos_id);
+        core->special_ids.insert(core->cfg.pad_id);
+        core->special_ids.insert(core->unk_id);
+    } else {
+        for (int i = 0; i < opts->num_skip_ids; ++i) {
+            long long id = opts->skip_ids[i];
+            if (id < 0 || id >= (long long)core->cfg.decoder_vocab_size) {
+                delete core;
+                err = MARIAN_TOK_ERR_SKIP_ID;
+                return nullptr;
+            }
+            core->special_ids.insert(id);
+        }
+    }
+
+    // 5) config JSON with the effective settings
+    try {
+        json j = json::parse(cfg_str);
+        if (opts->model_max_length > 0) {
+            j["model_max_length"] = core->cfg.model_max_length;
+        }
+        j["unk_token_id"] = core->unk_id;
+        std::vector<long long> skip(core->special_ids.begin(), core->special_ids.end());
+        std::sort(skip.begin(), skip.end());
+        j["skip_token_ids"] = skip;
+        core->cfg_json = j.dump();
+    } catch (...) {
+        delete core;
+        err = MARIAN_TOK_ERR_CONFIG;
+        return nullptr;
+    }
 
     return reinterpret_cast<marian_tok_t>(core);
 }
@@ -225,31 +423,28 @@ int marian_tok_encode(
         long long* out_ids,
         int max_ids,
         int add_eos) {
-    if (!handle || !text || !out_ids || max_ids <= 0) return -1;
-    auto* core = reinterpret_cast<MarianCore*>(handle);
+    if (!text) return -1;
+    return marian_tok_encode_n(handle, text, std::strlen(text), out_ids, max_ids, add_eos);
+}
 
-    std::vector<std::string> pieces;
-    auto status = core->sp_source.Encode(std::string(text), &pieces);
-    if (!status.ok()) return -2;
+// Encode text_len bytes of UTF-8 text into Marian token ids.
+//
+// Like marian_tok_encode, but the text does not need to be NUL-terminated
+// and may contain NUL bytes, which are tokenized like any other character.
+// text may be NULL when text_len is 0.
+int marian_tok_encode_n(
+        marian_tok_t handle,
+        const char* text,
+        size_t text_len,
+        long long* out_ids,
+        int max_ids,
+        int add_eos) {
+    if (!handle || (!text && text_len > 0) || !out_ids || max_ids <= 0) return -1;
+    auto* core = reinterpret_cast<MarianCore*>(handle);
 
     std::vector<long long> ids;
-    ids.reserve(pieces.size() + 1);
-
-    for (const auto& p : pieces) {
-        auto it = core->token2id.find(p);
-        if (it != core->token2id.end()) {
-            ids.push_back(it->second);
-        } else {
-            ids.push_back(core->unk_id);
-        }
-    }
-
-    if (add_eos) {
-        ids.push_back(core->cfg.eos_id);
-    }
-
-    if ((int)ids.size() > core->cfg.model_max_length) {
-        ids.resize(core->cfg.model_max_length);
+    if (!encode_ids(core, text ? std::string(text, text_len) : std::string(), add_eos, ids)) {
+        return -2;
     }
 
     if ((int)ids.size() > max_ids) {
@@ -284,64 +479,28 @@ int marian_tok_encode_batch(
         return -1;
     }
     auto* core = reinterpret_cast<MarianCore*>(handle);
+    return encode_batch(core, texts, nullptr, batch_size, max_len, out_ids, out_seq_lens, add_eos);
+}
 
-    int global_max_len = 0;
-
-    for (int b = 0; b < batch_size; ++b) {
-        const char* t = texts[b];
-        if (!t) {
-            out_seq_lens[b] = 0;
-            continue;
-        }
-
-        std::vector<std::string> pieces;
-        auto status = core->sp_source.Encode(std::string(t), &pieces);
-        if (!status.ok()) return -2;
-
-        std::vector<long long> ids;
-        ids.reserve(pieces.size() + 1);
-
-        for (const auto& p : pieces) {
-            auto it = core->token2id.find(p);
-            if (it != core->token2id.end()) {
-                ids.push_back(it->second);
-            } else {
-                ids.push_back(core->unk_id);
-            }
-        }
-
-        if (add_eos) {
-            ids.push_back(core->cfg.eos_id);
-        }
-
-        // truncate by model_max_length
-        if ((int)ids.size() > core->cfg.model_max_length) {
-            ids.resize(core->cfg.model_max_length);
-        }
-
-        int seq_len = (int)ids.size();
-        if (seq_len > max_len) {
-            // buffer is too small
-            return -3;
-        }
-
-        out_seq_lens[b] = seq_len;
-        if (seq_len > global_max_len) {
-            global_max_len = seq_len;
-        }
-
-        // fills a strings in out_ids with padding
-        int row_offset = b * max_len;
-        int j = 0;
-        for (; j < seq_len; ++j) {
-            out_ids[row_offset + j] = ids[j];
-        }
-        for (; j < max_len; ++j) {
-            out_ids[row_offset + j] = core->cfg.pad_id;
-        }
+// Batch-encode UTF-8 texts with explicit lengths into Marian token ids.
+//
+// Like marian_tok_encode_batch, but texts[i] holds text_lens[i] bytes that
+// do not need to be NUL-terminated and may contain NUL bytes. texts[i] may be
+// NULL when text_lens[i] is 0; it is then encoded as an empty string.
+int marian_tok_encode_batch_n(
+        marian_tok_t handle,
+        const char* const* texts,
+        const size_t* text_lens,
+        int batch_size,
+        int max_len,
+        long long* out_ids,
+        int* out_seq_lens,
+        int add_eos) {
+    if (!handle || !texts || !text_lens || batch_size <= 0 || max_len <= 0 || !out_ids || !out_seq_lens) {
+        return -1;
     }
-
-    return global_max_len; // actual maximum sequence length in the batch
+    auto* core = reinterpret_cast<MarianCore*>(handle);
+    return encode_batch(core, texts, text_lens, batch_size, max_len, out_ids, out_seq_lens, add_eos);
 }
 
 // Build attention masks from sequence lengths.
@@ -416,7 +575,7 @@ int marian_tok_decode(
     }
 
     std::string result;
-    auto status = core->sp_target.Decode(pieces, &result);
+    auto status = core->target->Decode(pieces, &result);
     if (!status.ok()) return -2;
 
     if ((int)result.size() + 1 > max_text_len) {
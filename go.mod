@@ -1,3 +1,5 @@
 module github.com/techwithsergiu/marian_tokenizer_go
 
 go 1.25.4
+
+require golang.org/x/text v0.41.0
@@ -0,0 +1,5 @@
+//go:build !marian_v3
+
+package backends
+
+import _ "github.com/techwithsergiu/marian_tokenizer_go/marian_v2"
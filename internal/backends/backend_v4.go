@@ -0,0 +1,3 @@
+package backends
+
+import _ "github.com/techwithsergiu/marian_tokenizer_go/marian_v4"
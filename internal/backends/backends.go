@@ -0,0 +1,16 @@
+// Package backends links the tokenizer backends into the commands.
+//
+// Importing it registers every backend that is usable in this build with
+// marian.Open: v1 and v2 need cgo on amd64, v3 replaces v2 when built with
+// -tags marian_v3 (both export the same marian_tok_* symbols, so they cannot
+// share one binary), and v4 is pure Go and always available.
+package backends
+
+// Name names the value of a command's -backend flag in messages; empty
+// means the default choice of marian.Open.
+func Name(backend string) string {
+	if backend == "" {
+		return "default"
+	}
+	return backend
+}
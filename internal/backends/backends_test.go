@@ -0,0 +1,59 @@
+package backends_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	_ "github.com/techwithsergiu/marian_tokenizer_go/internal/backends"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/export"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/testmodel"
+)
+
+// A directory with only tokenizer.json is skipped by the SentencePiece
+// backends and opened by v4, whichever backends the build links in.
+func TestOpenTokenizerJSONOnly(t *testing.T) {
+	m := testmodel.TempDir(t, testmodel.Options{MaxLength: 64})
+	model, err := marian.LoadModel(m.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if _, err := export.HFTokenizerFile(model, dir); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := os.ReadFile(filepath.Join(m.Dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), cfg, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := marian.Open(dir)
+	if err != nil {
+		t.Fatalf("Open with %v: %v", marian.Backends(), err)
+	}
+	defer tok.Close()
+	ids, err := tok.Encode("hello world", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{m.Vocab["▁hello"], m.Vocab["▁world"], m.EosID}
+	if !slices.Equal(ids, want) {
+		t.Errorf("Encode = %v, want %v", ids, want)
+	}
+
+	for _, name := range marian.Backends() {
+		if name == "v4" {
+			continue
+		}
+		_, err := marian.Open(dir, marian.WithBackend(name))
+		if !errors.Is(err, marian.ErrUnsupportedLayout) {
+			t.Errorf("Open with %s: got %v, want ErrUnsupportedLayout", name, err)
+		}
+	}
+}
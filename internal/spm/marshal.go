@@ -0,0 +1,122 @@
+package spm
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Field numbers from sentencepiece_model.proto.
+const (
+	fieldModelPieces     = 1
+	fieldModelTrainer    = 2
+	fieldModelNormalizer = 3
+
+	fieldPiecePiece = 1
+	fieldPieceScore = 2
+	fieldPieceType  = 3
+
+	fieldTrainerModelType    = 3
+	fieldTrainerVocabSize    = 4
+	fieldTrainerByteFallback = 35
+	fieldTrainerUnkID        = 40
+	fieldTrainerBosID        = 41
+	fieldTrainerEosID        = 42
+	fieldTrainerPadID        = 43
+	fieldTrainerUnkPiece     = 45
+	fieldTrainerBosPiece     = 46
+	fieldTrainerEosPiece     = 47
+	fieldTrainerPadPiece     = 48
+	fieldTrainerUnkSurface   = 44
+
+	fieldNormName                   = 1
+	fieldNormPrecompiledCharsmap    = 2
+	fieldNormAddDummyPrefix         = 3
+	fieldNormRemoveExtraWhitespaces = 4
+	fieldNormEscapeWhitespaces      = 5
+)
+
+// Wire types.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+	wireI32    = 5
+)
+
+// Marshal serializes m as a ModelProto. Every field is written explicitly,
+// so proto2 defaults never apply on the reading side.
+func (m *Model) Marshal() []byte {
+	var b []byte
+	for _, p := range m.Pieces {
+		b = appendBytes(b, fieldModelPieces, p.marshal())
+	}
+	b = appendBytes(b, fieldModelTrainer, m.Trainer.marshal())
+	b = appendBytes(b, fieldModelNormalizer, m.Normalizer.marshal())
+	return b
+}
+
+func (p *Piece) marshal() []byte {
+	var b []byte
+	b = appendBytes(b, fieldPiecePiece, []byte(p.Piece))
+	b = appendTag(b, fieldPieceScore, wireI32)
+	b = binary.LittleEndian.AppendUint32(b, math.Float32bits(p.Score))
+	b = appendVarintField(b, fieldPieceType, uint64(p.Type))
+	return b
+}
+
+func (t *TrainerSpec) marshal() []byte {
+	var b []byte
+	b = appendVarintField(b, fieldTrainerModelType, uint64(t.ModelType))
+	b = appendVarintField(b, fieldTrainerVocabSize, int32Varint(t.VocabSize))
+	b = appendVarintField(b, fieldTrainerByteFallback, boolVarint(t.ByteFallback))
+	b = appendVarintField(b, fieldTrainerUnkID, int32Varint(t.UnkID))
+	b = appendVarintField(b, fieldTrainerBosID, int32Varint(t.BosID))
+	b = appendVarintField(b, fieldTrainerEosID, int32Varint(t.EosID))
+	b = appendVarintField(b, fieldTrainerPadID, int32Varint(t.PadID))
+	b = appendBytes(b, fieldTrainerUnkSurface, []byte(t.UnkSurface))
+	b = appendBytes(b, fieldTrainerUnkPiece, []byte(t.UnkPiece))
+	b = appendBytes(b, fieldTrainerBosPiece, []byte(t.BosPiece))
+	b = appendBytes(b, fieldTrainerEosPiece, []byte(t.EosPiece))
+	b = appendBytes(b, fieldTrainerPadPiece, []byte(t.PadPiece))
+	return b
+}
+
+func (n *NormalizerSpec) marshal() []byte {
+	var b []byte
+	b = appendBytes(b, fieldNormName, []byte(n.Name))
+	if len(n.PrecompiledCharsmap) > 0 {
+		b = appendBytes(b, fieldNormPrecompiledCharsmap, n.PrecompiledCharsmap)
+	}
+	b = appendVarintField(b, fieldNormAddDummyPrefix, boolVarint(n.AddDummyPrefix))
+	b = appendVarintField(b, fieldNormRemoveExtraWhitespaces, boolVarint(n.RemoveExtraWhitespaces))
+	b = appendVarintField(b, fieldNormEscapeWhitespaces, boolVarint(n.EscapeWhitespaces))
+	return b
+}
+
+func appendTag(b []byte, field int, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// int32Varint encodes an int32 the way protobuf does: negative values are
+// sign-extended to 64 bits.
+func int32Varint(v int32) uint64 {
+	return uint64(int64(v))
+}
+
+func boolVarint(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+// Package spm reads and writes serialized SentencePiece models (the
+// ModelProto message of sentencepiece_model.proto) without depending on a
+// protobuf library. Only the fields used by Marian tokenizers are kept.
+package spm
+
+// PieceType is SentencePiece.Type.
+type PieceType int32
+
+const (
+	Normal      PieceType = 1
+	Unknown     PieceType = 2
+	Control     PieceType = 3
+	UserDefined PieceType = 4
+	Unused      PieceType = 5
+	Byte        PieceType = 6
+)
+
+// ModelType is TrainerSpec.ModelType.
+type ModelType int32
+
+const (
+	Unigram ModelType = 1
+	BPE     ModelType = 2
+	Word    ModelType = 3
+	Char    ModelType = 4
+)
+
+// Piece is one vocabulary entry (ModelProto.SentencePiece).
+type Piece struct {
+	Piece string
+	Score float32
+	Type  PieceType
+}
+
+// TrainerSpec holds the trainer fields that affect encoding.
+type TrainerSpec struct {
+	ModelType    ModelType
+	VocabSize    int32
+	ByteFallback bool
+	UnkID        int32
+	BosID        int32
+	EosID        int32
+	PadID        int32
+	UnkPiece     string
+	BosPiece     string
+	EosPiece     string
+	PadPiece     string
+	UnkSurface   string
+}
+
+// NormalizerSpec holds the normalization rules.
+type NormalizerSpec struct {
+	Name                   string
+	PrecompiledCharsmap    []byte
+	AddDummyPrefix         bool
+	RemoveExtraWhitespaces bool
+	EscapeWhitespaces      bool
+}
+
+// Model is a SentencePiece ModelProto.
+type Model struct {
+	Pieces     []Piece
+	Trainer    TrainerSpec
+	Normalizer NormalizerSpec
+}
+
+// DefaultTrainerSpec returns the proto2 defaults of TrainerSpec.
+func DefaultTrainerSpec() TrainerSpec {
+	return TrainerSpec{
+		ModelType:  Unigram,
+		VocabSize:  8000,
+		UnkID:      0,
+		BosID:      1,
+		EosID:      2,
+		PadID:      -1,
+		UnkPiece:   "<unk>",
+		BosPiece:   "<s>",
+		EosPiece:   "</s>",
+		PadPiece:   "<pad>",
+		UnkSurface: " \u2047 ",
+	}
+}
+
+// DefaultNormalizerSpec returns the proto2 defaults of NormalizerSpec.
+func DefaultNormalizerSpec() NormalizerSpec {
+	return NormalizerSpec{
+		AddDummyPrefix:         true,
+		RemoveExtraWhitespaces: true,
+		EscapeWhitespaces:      true,
+	}
+}
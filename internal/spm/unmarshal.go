@@ -0,0 +1,171 @@
+package spm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+var errTruncated = errors.New("spm: truncated message")
+
+// Load reads and parses a serialized model file.
+func Load(path string) (*Model, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Unmarshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Unmarshal parses a ModelProto. Fields that are absent keep their proto2
+// defaults; fields this package doesn't model are skipped.
+func Unmarshal(b []byte) (*Model, error) {
+	m := &Model{
+		Trainer:    DefaultTrainerSpec(),
+		Normalizer: DefaultNormalizerSpec(),
+	}
+	err := walk(b, func(field, wire int, v uint64, data []byte) error {
+		if wire != wireBytes {
+			return nil
+		}
+		switch field {
+		case fieldModelPieces:
+			p, err := unmarshalPiece(data)
+			if err != nil {
+				return err
+			}
+			m.Pieces = append(m.Pieces, p)
+		case fieldModelTrainer:
+			return m.Trainer.unmarshal(data)
+		case fieldModelNormalizer:
+			return m.Normalizer.unmarshal(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func unmarshalPiece(b []byte) (Piece, error) {
+	p := Piece{Type: Normal}
+	err := walk(b, func(field, wire int, v uint64, data []byte) error {
+		switch {
+		case field == fieldPiecePiece && wire == wireBytes:
+			p.Piece = string(data)
+		case field == fieldPieceScore && wire == wireI32:
+			p.Score = math.Float32frombits(uint32(v))
+		case field == fieldPieceType && wire == wireVarint:
+			p.Type = PieceType(v)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func (t *TrainerSpec) unmarshal(b []byte) error {
+	return walk(b, func(field, wire int, v uint64, data []byte) error {
+		switch field {
+		case fieldTrainerModelType:
+			t.ModelType = ModelType(v)
+		case fieldTrainerVocabSize:
+			t.VocabSize = int32(v)
+		case fieldTrainerByteFallback:
+			t.ByteFallback = v != 0
+		case fieldTrainerUnkID:
+			t.UnkID = int32(v)
+		case fieldTrainerBosID:
+			t.BosID = int32(v)
+		case fieldTrainerEosID:
+			t.EosID = int32(v)
+		case fieldTrainerPadID:
+			t.PadID = int32(v)
+		case fieldTrainerUnkSurface:
+			t.UnkSurface = string(data)
+		case fieldTrainerUnkPiece:
+			t.UnkPiece = string(data)
+		case fieldTrainerBosPiece:
+			t.BosPiece = string(data)
+		case fieldTrainerEosPiece:
+			t.EosPiece = string(data)
+		case fieldTrainerPadPiece:
+			t.PadPiece = string(data)
+		}
+		return nil
+	})
+}
+
+func (n *NormalizerSpec) unmarshal(b []byte) error {
+	return walk(b, func(field, wire int, v uint64, data []byte) error {
+		switch field {
+		case fieldNormName:
+			n.Name = string(data)
+		case fieldNormPrecompiledCharsmap:
+			n.PrecompiledCharsmap = append([]byte(nil), data...)
+		case fieldNormAddDummyPrefix:
+			n.AddDummyPrefix = v != 0
+		case fieldNormRemoveExtraWhitespaces:
+			n.RemoveExtraWhitespaces = v != 0
+		case fieldNormEscapeWhitespaces:
+			n.EscapeWhitespaces = v != 0
+		}
+		return nil
+	})
+}
+
+// walk calls fn for every field of a message. Scalar values are passed in
+// v (fixed-size values as their raw bits), length-delimited ones in data.
+func walk(b []byte, fn func(field, wire int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+
+		var v uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireI64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			v = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireI32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			v = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errTruncated
+			}
+			data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return fmt.Errorf("spm: unsupported wire type %d", wire)
+		}
+
+		if err := fn(field, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
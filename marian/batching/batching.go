@@ -0,0 +1,151 @@
+// Package batching groups sentences into padded batches by token budget.
+//
+// EncodeBatch pads every row to the longest sentence of the batch, so a
+// batch mixing a 5-token and a 400-token sentence is mostly padding. Encode
+// sorts sentences by length and fills each batch up to a maximum number of
+// tokens (rows × longest row, padding included), so batches hold sentences
+// of similar length:
+//
+//	batches, err := batching.Encode(tok, texts, batching.Options{MaxTokens: 8192})
+//	for _, b := range batches {
+//		out := translate(b.InputIDs, b.AttentionMask)
+//		results = append(results, out...)
+//	}
+//	results, err = batching.Restore(batching.Permutation(batches), results)
+package batching
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Options bounds the batches. At least one field should be set.
+type Options struct {
+	// MaxTokens is the maximum number of tokens in a batch, padding
+	// included: rows × longest row. A sentence longer than MaxTokens gets
+	// a batch of its own.
+	MaxTokens int
+	// MaxRows is the maximum number of sentences in a batch.
+	MaxRows int
+}
+
+// Batch is a group of input sentences encoded together.
+type Batch struct {
+	// Indices are the positions of the rows in the input.
+	Indices []int
+	// InputIDs and AttentionMask have the same shape as the EncodeBatch
+	// result for the sentences at Indices.
+	InputIDs      [][]int64
+	AttentionMask [][]int64
+}
+
+// Tokens returns the number of tokens in the batch, padding included.
+func (b *Batch) Tokens() int {
+	if len(b.InputIDs) == 0 {
+		return 0
+	}
+	return len(b.InputIDs) * len(b.InputIDs[0])
+}
+
+// Group splits the sentence lengths (in tokens) into batches that respect
+// opts and returns the input positions of every batch. Sentences are taken
+// longest first, so the largest batch comes first and memory problems show
+// up early.
+func Group(lengths []int, opts Options) [][]int {
+	order := make([]int, len(lengths))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return lengths[order[a]] > lengths[order[b]]
+	})
+
+	var groups [][]int
+	var cur []int
+	longest := 0
+	for _, i := range order {
+		if len(cur) > 0 {
+			rowsFull := opts.MaxRows > 0 && len(cur)+1 > opts.MaxRows
+			tokensFull := opts.MaxTokens > 0 && (len(cur)+1)*longest > opts.MaxTokens
+			if rowsFull || tokensFull {
+				groups = append(groups, cur)
+				cur = nil
+			}
+		}
+		if len(cur) == 0 {
+			// Sorted longest first: the first row sets the width.
+			longest = lengths[i]
+		}
+		cur = append(cur, i)
+	}
+	if len(cur) > 0 {
+		groups = append(groups, cur)
+	}
+	return groups
+}
+
+// Encode encodes texts like EncodeBatch (with EOS unless the config's
+// NoBatchEOS is set) and groups them into padded batches with Group. Every
+// text is encoded once.
+func Encode(tok marian.Tokenizer, texts []string, opts Options) ([]Batch, error) {
+	cfg, err := tok.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := make([][]int64, len(texts))
+	lengths := make([]int, len(texts))
+	for i, text := range texts {
+		ids, err := tok.Encode(text, !cfg.NoBatchEOS)
+		if err != nil {
+			return nil, fmt.Errorf("texts[%d]: %w", i, err)
+		}
+		seqs[i], lengths[i] = ids, len(ids)
+	}
+
+	groups := Group(lengths, opts)
+	batches := make([]Batch, len(groups))
+	for g, indices := range groups {
+		rows := make([][]int64, len(indices))
+		for j, i := range indices {
+			rows[j] = seqs[i]
+		}
+		ids, mask := marian.PadBatch(rows, cfg.PadTokenID)
+		batches[g] = Batch{Indices: indices, InputIDs: ids, AttentionMask: mask}
+	}
+	return batches, nil
+}
+
+// Permutation returns the input positions of all batch rows in batch order:
+// row k of the concatenated batches belongs to input Permutation(b)[k].
+func Permutation(batches []Batch) []int {
+	var perm []int
+	for _, b := range batches {
+		perm = append(perm, b.Indices...)
+	}
+	return perm
+}
+
+// Restore puts results produced in batch order back into input order, so
+// that Restore(perm, results)[perm[k]] == results[k]. perm must hold every
+// position from 0 to len(results)-1 exactly once.
+func Restore[T any](perm []int, results []T) ([]T, error) {
+	if len(perm) != len(results) {
+		return nil, fmt.Errorf("batching: permutation of %d positions for %d results", len(perm), len(results))
+	}
+	out := make([]T, len(results))
+	seen := make([]bool, len(results))
+	for k, i := range perm {
+		if i < 0 || i >= len(out) {
+			return nil, fmt.Errorf("batching: position %d out of range", i)
+		}
+		if seen[i] {
+			return nil, fmt.Errorf("batching: position %d repeated", i)
+		}
+		seen[i] = true
+		out[i] = results[k]
+	}
+	return out, nil
+}
@@ -0,0 +1,136 @@
+package batching
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+func TestGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		lengths []int
+		opts    Options
+		want    [][]int
+	}{
+		{"none", nil, Options{MaxTokens: 10}, nil},
+		{"unbounded", []int{1, 3, 2}, Options{}, [][]int{{1, 2, 0}}},
+		// Longest first, equal lengths in input order.
+		{"rows", []int{2, 5, 2, 5, 1}, Options{MaxRows: 2}, [][]int{{1, 3}, {0, 2}, {4}}},
+		// The first row sets the width: 3 rows of 4 fit 12 tokens.
+		{"tokens", []int{4, 2, 3, 1, 4}, Options{MaxTokens: 12}, [][]int{{0, 4, 2}, {1, 3}}},
+		{"both", []int{1, 1, 1, 1, 1}, Options{MaxTokens: 4, MaxRows: 3}, [][]int{{0, 1, 2}, {3, 4}}},
+		// A sentence longer than MaxTokens gets a batch of its own.
+		{"too long", []int{3, 20, 3}, Options{MaxTokens: 8}, [][]int{{1}, {0, 2}}},
+	}
+	for _, tt := range tests {
+		got := Group(tt.lengths, tt.opts)
+		if !slices.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("%s: Group(%v) = %v, want %v", tt.name, tt.lengths, got, tt.want)
+		}
+	}
+}
+
+// wordTokenizer encodes a token per word, plus EOS as 0.
+type wordTokenizer struct {
+	noBatchEOS bool
+}
+
+func (w *wordTokenizer) Encode(text string, addEOS bool) ([]int64, error) {
+	if strings.Contains(text, "!") {
+		return nil, errors.New("bad text")
+	}
+	var ids []int64
+	for _, word := range strings.Fields(text) {
+		ids = append(ids, int64(len(word)))
+	}
+	if addEOS {
+		ids = append(ids, 0)
+	}
+	return ids, nil
+}
+
+func (w *wordTokenizer) EncodeBatch([]string) ([][]int64, [][]int64, error) {
+	return nil, nil, errors.New("not used")
+}
+
+func (w *wordTokenizer) Decode([]int64, bool) (string, error) { return "", nil }
+
+func (w *wordTokenizer) Config() (*marian.Config, error) {
+	return &marian.Config{PadTokenID: 9, NoBatchEOS: w.noBatchEOS}, nil
+}
+
+func (w *wordTokenizer) Close() {}
+
+func TestEncode(t *testing.T) {
+	texts := []string{"a", "bb cc dd", "e f", "ggg hh i"}
+	batches, err := Encode(&wordTokenizer{}, texts, Options{MaxTokens: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Batch{
+		{Indices: []int{1, 3}, InputIDs: [][]int64{{2, 2, 2, 0}, {3, 2, 1, 0}}, AttentionMask: [][]int64{{1, 1, 1, 1}, {1, 1, 1, 1}}},
+		{Indices: []int{2, 0}, InputIDs: [][]int64{{1, 1, 0}, {1, 0, 9}}, AttentionMask: [][]int64{{1, 1, 1}, {1, 1, 0}}},
+	}
+	if len(batches) != len(want) {
+		t.Fatalf("got %d batches, want %d", len(batches), len(want))
+	}
+	for i, b := range batches {
+		w := want[i]
+		if !slices.Equal(b.Indices, w.Indices) || !slices.EqualFunc(b.InputIDs, w.InputIDs, slices.Equal) || !slices.EqualFunc(b.AttentionMask, w.AttentionMask, slices.Equal) {
+			t.Errorf("batch %d = %+v, want %+v", i, b, w)
+		}
+	}
+	if got := []int{batches[0].Tokens(), batches[1].Tokens(), (&Batch{}).Tokens()}; !slices.Equal(got, []int{8, 6, 0}) {
+		t.Errorf("Tokens = %v", got)
+	}
+
+	// Rows are encoded like EncodeBatch: without EOS under NoBatchEOS.
+	batches, err = Encode(&wordTokenizer{noBatchEOS: true}, texts, Options{MaxRows: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]int64{{2, 2, 2}, {3, 2, 1}, {1, 1, 9}, {1, 9, 9}}; len(batches) != 1 || !slices.EqualFunc(batches[0].InputIDs, want, slices.Equal) {
+		t.Errorf("NoBatchEOS: batches = %+v, want rows %v", batches, want)
+	}
+
+	if _, err := Encode(&wordTokenizer{}, []string{"ok", "bad!"}, Options{}); err == nil || !strings.HasPrefix(err.Error(), "texts[1]:") {
+		t.Errorf("Encode of a bad text: %v, want the index in the error", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	texts := []string{"a", "bb cc dd", "e f", "ggg hh i", "j"}
+	batches, err := Encode(&wordTokenizer{}, texts, Options{MaxRows: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	perm := Permutation(batches)
+
+	// Results in batch order: the texts the rows came from.
+	var results []string
+	for _, b := range batches {
+		for _, i := range b.Indices {
+			results = append(results, texts[i])
+		}
+	}
+	got, err := Restore(perm, results)
+	if err != nil || !slices.Equal(got, texts) {
+		t.Errorf("Restore = %q, %v, want %q", got, err, texts)
+	}
+
+	for _, perm := range [][]int{
+		{0, 1},       // too short
+		{0, 1, 2, 3}, // too long
+		{0, 1, 3},    // out of range
+		{0, -1, 2},   // negative
+		{0, 2, 2},    // repeated, 1 missing
+	} {
+		if got, err := Restore(perm, []string{"a", "b", "c"}); err == nil {
+			t.Errorf("Restore(%v) = %q, want an error", perm, got)
+		}
+	}
+}
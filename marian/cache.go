@@ -0,0 +1,336 @@
+package marian
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheOptions bounds an EncodeCache. Zero fields are not enforced, but at
+// least one of them should be set.
+type CacheOptions struct {
+	// MaxEntries is the maximum number of cached sentences.
+	MaxEntries int
+	// MaxBytes is the approximate maximum memory used by cached entries
+	// (text + ids + bookkeeping).
+	MaxBytes int64
+}
+
+// CacheStats is a snapshot of EncodeCache counters.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+// entryOverhead approximates the per-entry cost of the list element, the
+// map slot and the slice headers.
+const entryOverhead = 128
+
+// cacheKey identifies an Encode result. owner separates the key spaces of
+// the tokenizers sharing one cache, gen the tokenizers a Reloadable swapped
+// in.
+type cacheKey struct {
+	owner  uint64
+	gen    uint64
+	text   string
+	addEOS bool
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	ids  []int64
+	size int64
+}
+
+// EncodeCache is an LRU cache of Encode results. One cache can be shared by
+// several tokenizers through the Cached middleware; it is safe for
+// concurrent use.
+type EncodeCache struct {
+	opts CacheOptions
+
+	mu    sync.Mutex
+	ll    *list.List // front = most recently used
+	items map[cacheKey]*list.Element
+	bytes int64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// NewEncodeCache creates an empty cache bounded by opts.
+func NewEncodeCache(opts CacheOptions) *EncodeCache {
+	return &EncodeCache{
+		opts:  opts,
+		ll:    list.New(),
+		items: map[cacheKey]*list.Element{},
+	}
+}
+
+// Stats returns the current counters.
+func (c *EncodeCache) Stats() CacheStats {
+	c.mu.Lock()
+	entries, bytes := c.ll.Len(), c.bytes
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+		Bytes:     bytes,
+	}
+}
+
+// Purge removes all entries. Counters are kept.
+func (c *EncodeCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = map[cacheKey]*list.Element{}
+	c.bytes = 0
+}
+
+// removeIf removes the entries whose key matches drop. Counters are kept.
+func (c *EncodeCache) removeIf(drop func(cacheKey) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*cacheEntry); drop(e.key) {
+			c.ll.Remove(el)
+			delete(c.items, e.key)
+			c.bytes -= e.size
+		}
+		el = next
+	}
+}
+
+// get returns a copy of the cached ids for key.
+func (c *EncodeCache) get(key cacheKey) ([]int64, bool) {
+	c.mu.Lock()
+	el, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	ids := append([]int64(nil), el.Value.(*cacheEntry).ids...)
+	c.mu.Unlock()
+
+	c.hits.Add(1)
+	return ids, true
+}
+
+// put stores a copy of ids under key and evicts the least recently used
+// entries until the cache fits its bounds again.
+func (c *EncodeCache) put(key cacheKey, ids []int64) {
+	e := &cacheEntry{
+		key:  key,
+		ids:  append([]int64(nil), ids...),
+		size: int64(len(key.text)+8*len(ids)) + entryOverhead,
+	}
+	if c.opts.MaxBytes > 0 && e.size > c.opts.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.bytes -= el.Value.(*cacheEntry).size
+		el.Value = e
+		c.bytes += e.size
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(e)
+		c.bytes += e.size
+	}
+
+	for c.ll.Len() > 0 &&
+		((c.opts.MaxEntries > 0 && c.ll.Len() > c.opts.MaxEntries) ||
+			(c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes)) {
+		el := c.ll.Back()
+		old := el.Value.(*cacheEntry)
+		c.ll.Remove(el)
+		delete(c.items, old.key)
+		c.bytes -= old.size
+		c.evictions.Add(1)
+	}
+}
+
+// cacheOwners hands out the key space ids used by Cached.
+var cacheOwners atomic.Uint64
+
+// Cached returns a middleware that serves Encode results from c. Each
+// tokenizer wrapped by the middleware gets its own key space in c, so one
+// cache can be shared by tokenizers for different models.
+//
+// When the next tokenizer is, or wraps through Override middleware, a
+// Reloadable, entries are kept per Generation: a reload drops the entries
+// of the tokenizer it replaced, and results are never served across it.
+// Wrapping each generation instead (Cached applied by ReloadOptions.Open)
+// works too, as Close removes the tokenizer's entries from c.
+//
+// EncodeBatch is served from the cache as far as possible: only the missing
+// sentences are passed to the next tokenizer's EncodeBatch, and the result
+// is padded with the configured pad id exactly like an uncached batch.
+// Cached ids are copied in and out, so callers may modify returned slices.
+// After Close the cache is bypassed, so calls fail like the next tokenizer's.
+func Cached(c *EncodeCache) Middleware {
+	return func(next Tokenizer) Tokenizer {
+		owner := cacheOwners.Add(1)
+		generation := generationOf(next)
+		var closed atomic.Bool
+		var seen atomic.Uint64
+
+		// current returns the generation of next, dropping the entries of
+		// older ones when it changed.
+		current := func() uint64 {
+			gen := generation()
+			if seen.Swap(gen) != gen {
+				c.removeIf(func(k cacheKey) bool { return k.owner == owner && k.gen != gen })
+			}
+			return gen
+		}
+
+		return Override(next, Funcs{
+			Encode: func(text string, addEOS bool) ([]int64, error) {
+				if closed.Load() {
+					return next.Encode(text, addEOS)
+				}
+				key := cacheKey{owner: owner, gen: current(), text: text, addEOS: addEOS}
+				if ids, ok := c.get(key); ok {
+					return ids, nil
+				}
+				ids, err := next.Encode(text, addEOS)
+				if err != nil {
+					return nil, err
+				}
+				c.put(key, ids)
+				return ids, nil
+			},
+			EncodeBatch: func(texts []string) ([][]int64, [][]int64, error) {
+				if closed.Load() {
+					return next.EncodeBatch(texts)
+				}
+				return cachedEncodeBatch(c, cacheKey{owner: owner, gen: current()}, next, texts)
+			},
+			Close: func() {
+				closed.Store(true)
+				c.removeIf(func(k cacheKey) bool { return k.owner == owner })
+				next.Close()
+			},
+		})
+	}
+}
+
+// generationOf returns the Generation method of the first Reloadable in the
+// middleware chain from tok, or a constant when there is none.
+func generationOf(tok Tokenizer) func() uint64 {
+	for ; tok != nil; tok = Unwrap(tok) {
+		if r, ok := tok.(interface{ Generation() uint64 }); ok {
+			return r.Generation
+		}
+	}
+	return func() uint64 { return 0 }
+}
+
+// cachedEncodeBatch serves texts from c under the owner and generation of
+// base, and encodes the missing ones with next.
+func cachedEncodeBatch(c *EncodeCache, base cacheKey, next Tokenizer, texts []string) ([][]int64, [][]int64, error) {
+	cfg, err := next.Config()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Batch rows are cached like Encode with the batch's addEOS.
+	base.addEOS = !cfg.NoBatchEOS
+	key := func(text string) cacheKey {
+		k := base
+		k.text = text
+		return k
+	}
+
+	rows := make([][]int64, len(texts))
+	hit := make([]bool, len(texts))
+	var missTexts []string
+	missIndex := map[string]int{} // text -> index in missTexts
+
+	for i, text := range texts {
+		if ids, ok := c.get(key(text)); ok {
+			rows[i], hit[i] = ids, true
+			continue
+		}
+		if _, ok := missIndex[text]; !ok {
+			missIndex[text] = len(missTexts)
+			missTexts = append(missTexts, text)
+		}
+	}
+
+	if len(missTexts) > 0 {
+		ids, mask, err := next.EncodeBatch(missTexts)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, text := range missTexts {
+			c.put(key(text), ids[i][:rowLen(mask[i])])
+		}
+		// Nothing was cached and nothing repeated: the native result is
+		// already the answer.
+		if len(missTexts) == len(texts) {
+			return ids, mask, nil
+		}
+		for i, text := range texts {
+			if !hit[i] {
+				j := missIndex[text]
+				rows[i] = append([]int64(nil), ids[j][:rowLen(mask[j])]...)
+			}
+		}
+	}
+
+	inputIDs, attn := PadBatch(rows, cfg.PadTokenID)
+	return inputIDs, attn, nil
+}
+
+// rowLen returns the number of real tokens in an attention mask row.
+func rowLen(mask []int64) int {
+	n := 0
+	for _, v := range mask {
+		if v != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// PadBatch pads seqs to the length of the longest one and returns the
+// padded ids and the matching attention mask (1 for tokens, 0 for padding),
+// in the same shape EncodeBatch returns.
+func PadBatch(seqs [][]int64, padID int64) ([][]int64, [][]int64) {
+	maxUsed := 0
+	for _, seq := range seqs {
+		if len(seq) > maxUsed {
+			maxUsed = len(seq)
+		}
+	}
+
+	inputIDs := make([][]int64, len(seqs))
+	attn := make([][]int64, len(seqs))
+	for i, seq := range seqs {
+		inputIDs[i] = make([]int64, maxUsed)
+		attn[i] = make([]int64, maxUsed)
+		for j := 0; j < maxUsed; j++ {
+			if j < len(seq) {
+				inputIDs[i][j] = seq[j]
+				attn[i][j] = 1
+			} else {
+				inputIDs[i][j] = padID
+			}
+		}
+	}
+	return inputIDs, attn
+}
@@ -0,0 +1,233 @@
+package marian
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+// runeTokenizer encodes every rune as its code point plus offset, appends 0
+// as EOS and records the texts its EncodeBatch was called with.
+type runeTokenizer struct {
+	fakeTokenizer
+	offset     int64
+	noBatchEOS bool
+
+	mu      sync.Mutex
+	encodes int
+	batches [][]string
+}
+
+func (r *runeTokenizer) Encode(text string, addEOS bool) ([]int64, error) {
+	r.mu.Lock()
+	r.encodes++
+	r.mu.Unlock()
+	return r.encode(text, addEOS), nil
+}
+
+func (r *runeTokenizer) encode(text string, addEOS bool) []int64 {
+	var ids []int64
+	for _, c := range text {
+		ids = append(ids, int64(c)+r.offset)
+	}
+	if addEOS {
+		ids = append(ids, 0)
+	}
+	return ids
+}
+
+func (r *runeTokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	r.mu.Lock()
+	r.batches = append(r.batches, slices.Clone(texts))
+	r.mu.Unlock()
+	rows := make([][]int64, len(texts))
+	for i, text := range texts {
+		rows[i] = r.encode(text, !r.noBatchEOS)
+	}
+	ids, mask := PadBatch(rows, -1)
+	return ids, mask, nil
+}
+
+func (r *runeTokenizer) Config() (*Config, error) {
+	return &Config{PadTokenID: -1, NoBatchEOS: r.noBatchEOS}, nil
+}
+
+func TestEncodeCacheMaxEntries(t *testing.T) {
+	c := NewEncodeCache(CacheOptions{MaxEntries: 2})
+	key := func(text string) cacheKey { return cacheKey{text: text} }
+	c.put(key("a"), []int64{1})
+	c.put(key("b"), []int64{2})
+	c.get(key("a")) // b is now the least recently used
+	c.put(key("c"), []int64{3})
+
+	for text, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(key(text)); ok != want {
+			t.Errorf("get(%q): cached = %v, want %v", text, ok, want)
+		}
+	}
+	if s := c.Stats(); s.Entries != 2 || s.Evictions != 1 {
+		t.Errorf("Stats = %+v, want 2 entries and 1 eviction", s)
+	}
+}
+
+func TestEncodeCacheMaxBytes(t *testing.T) {
+	size := int64(len("aa")+8*2) + entryOverhead
+	c := NewEncodeCache(CacheOptions{MaxBytes: 2 * size})
+	key := func(text string) cacheKey { return cacheKey{text: text} }
+	c.put(key("aa"), []int64{1, 2})
+	c.put(key("bb"), []int64{1, 2})
+	if s := c.Stats(); s.Entries != 2 || s.Bytes != 2*size {
+		t.Fatalf("Stats = %+v, want 2 entries of %d bytes", s, size)
+	}
+
+	c.put(key("cc"), []int64{1, 2})
+	if _, ok := c.get(key("aa")); ok {
+		t.Error("aa is still cached past MaxBytes")
+	}
+	if s := c.Stats(); s.Entries != 2 || s.Bytes != 2*size {
+		t.Errorf("Stats = %+v, want 2 entries of %d bytes", s, size)
+	}
+
+	// An entry larger than the whole cache is not stored.
+	c.put(key("big"), make([]int64, 100))
+	if _, ok := c.get(key("big")); ok {
+		t.Error("entry larger than MaxBytes was cached")
+	}
+	if s := c.Stats(); s.Entries != 2 {
+		t.Errorf("Stats = %+v, want the 2 entries kept", s)
+	}
+}
+
+func TestCachedCopies(t *testing.T) {
+	next := &runeTokenizer{}
+	tok := Wrap(next, Cached(NewEncodeCache(CacheOptions{MaxEntries: 10})))
+
+	ids, _ := tok.Encode("ab", true)
+	ids[0] = 42 // must not reach the cached copy
+	again, _ := tok.Encode("ab", true)
+	if want := []int64{'a', 'b', 0}; !slices.Equal(again, want) {
+		t.Fatalf("Encode after modifying the first result = %v, want %v", again, want)
+	}
+	again[1] = 42 // nor must this
+	if got, _ := tok.Encode("ab", true); !slices.Equal(got, []int64{'a', 'b', 0}) {
+		t.Errorf("Encode after modifying a cached result = %v", got)
+	}
+	if next.encodes != 1 {
+		t.Errorf("next.Encode called %d times, want 1", next.encodes)
+	}
+}
+
+func TestCachedEncodeBatch(t *testing.T) {
+	for _, noBatchEOS := range []bool{false, true} {
+		next := &runeTokenizer{noBatchEOS: noBatchEOS}
+		tok := Wrap(next, Cached(NewEncodeCache(CacheOptions{MaxEntries: 10})))
+
+		// Rows are cached like Encode with the batch's addEOS.
+		if _, err := tok.Encode("bbbb", !noBatchEOS); err != nil {
+			t.Fatal(err)
+		}
+		texts := []string{"a", "bbbb", "a", "cc"}
+		ids, mask, err := tok.EncodeBatch(texts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantIDs, wantMask, _ := next.EncodeBatch(texts)
+		if !slices.EqualFunc(ids, wantIDs, slices.Equal) || !slices.EqualFunc(mask, wantMask, slices.Equal) {
+			t.Errorf("noBatchEOS=%v: EncodeBatch = %v %v, want %v %v", noBatchEOS, ids, mask, wantIDs, wantMask)
+		}
+		// Only the misses went to next, each once.
+		if want := []string{"a", "cc"}; !slices.Equal(next.batches[0], want) {
+			t.Errorf("noBatchEOS=%v: next.EncodeBatch(%q), want %q", noBatchEOS, next.batches[0], want)
+		}
+
+		// A batch of hits is padded without calling next.
+		ids, _, err = tok.EncodeBatch([]string{"cc", "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _, _ := next.EncodeBatch([]string{"cc", "a"})
+		if !slices.EqualFunc(ids, want, slices.Equal) {
+			t.Errorf("noBatchEOS=%v: EncodeBatch of hits = %v, want %v", noBatchEOS, ids, want)
+		}
+		if len(next.batches) != 3 { // the two reference calls above
+			t.Errorf("noBatchEOS=%v: next.EncodeBatch called for a batch of hits", noBatchEOS)
+		}
+		if _, err := tok.Encode("cc", !noBatchEOS); err != nil || next.encodes != 1 {
+			t.Errorf("noBatchEOS=%v: batch row not served to Encode", noBatchEOS)
+		}
+	}
+}
+
+func TestCachedCloseRemovesEntries(t *testing.T) {
+	c := NewEncodeCache(CacheOptions{MaxEntries: 10})
+	a := Wrap(&runeTokenizer{}, Cached(c))
+	b := Wrap(&runeTokenizer{offset: 1000}, Cached(c))
+	a.Encode("x", true)
+	b.Encode("x", true)
+	b.Encode("y", true)
+
+	// The key spaces are separate.
+	if got, _ := b.Encode("x", true); got[0] != 'x'+1000 {
+		t.Errorf("b.Encode = %v, served from a's entries", got)
+	}
+	a.Close()
+	if s := c.Stats(); s.Entries != 2 {
+		t.Errorf("after closing a: %d entries, want b's 2", s.Entries)
+	}
+	b.Close()
+	if s := c.Stats(); s.Entries != 0 {
+		t.Errorf("after closing b: %d entries, want 0", s.Entries)
+	}
+}
+
+// Cached outside a Reloadable keys its entries by generation.
+func TestCachedReloadable(t *testing.T) {
+	var opens int64
+	r, err := NewReloadable(t.TempDir(), ReloadOptions{
+		Open: func(string, ...Option) (Tokenizer, error) {
+			opens++
+			return &runeTokenizer{offset: 1000 * opens}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewEncodeCache(CacheOptions{MaxEntries: 10})
+	tok := Wrap(r, Metrics("cached_reloadable_test"), Cached(c))
+	defer tok.Close()
+
+	encode := func(text string) int64 {
+		t.Helper()
+		ids, err := tok.Encode(text, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids[0]
+	}
+	batch := func(text string) int64 {
+		t.Helper()
+		ids, _, err := tok.EncodeBatch([]string{text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids[0][0]
+	}
+	if got := encode("a"); got != 'a'+1000 {
+		t.Fatalf("Encode = %d, want %d", got, 'a'+1000)
+	}
+	if got := batch("b"); got != 'b'+1000 {
+		t.Fatalf("EncodeBatch = %d, want %d", got, 'b'+1000)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := encode("a"); got != 'a'+2000 {
+		t.Errorf("Encode after Reload = %d, want %d", got, 'a'+2000)
+	}
+	if got := batch("b"); got != 'b'+2000 {
+		t.Errorf("EncodeBatch after Reload = %d, want %d", got, 'b'+2000)
+	}
+	if s := c.Stats(); s.Entries != 2 {
+		t.Errorf("%d entries after Reload, want the 2 of the new generation", s.Entries)
+	}
+}
@@ -10,6 +10,11 @@ type Config struct {
 	MaxLength           int      `json:"max_length"`
 	ModelMaxLength      int      `json:"model_max_length"`
 	BadWordsIDs         [][]int  `json:"bad_words_ids"`
+
+	// Set by the backend when loading, from the model and the Options.
+	UnkTokenID   int64   `json:"unk_token_id"`
+	SkipTokenIDs []int64 `json:"skip_token_ids"` // skipped by Decode with skipSpecial
+	NoBatchEOS   bool    `json:"no_batch_eos"`   // EncodeBatch rows end without EOS
 }
 
 func (t *Config) NormalizeConfig() {
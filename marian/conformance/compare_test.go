@@ -0,0 +1,70 @@
+//go:build linux && amd64 && cgo
+
+package conformance_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/conformance"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/export"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/testmodel"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v1"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v2"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v4"
+)
+
+func factory(open marian.OpenFunc, dir string) conformance.Factory {
+	return func(t *testing.T) marian.Tokenizer {
+		tok, err := open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+}
+
+// exportTokenizerJSON writes the tokenizer.json of the model in dir next to
+// it. The truncation block is dropped: with it v4 truncates like tokenizers
+// and keeps </s> on long inputs, which the SentencePiece backends cut.
+func exportTokenizerJSON(t *testing.T, dir string) {
+	t.Helper()
+	model, err := marian.LoadModel(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := export.HFTokenizerFile(model, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	doc["truncation"] = nil
+	if b, err = json.Marshal(doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tokenizer.json"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Compare decodes source ids, so the model shares its alphabet between the
+// spms: v1 and v2 decode through target.spm, which spells out the pieces it
+// lacks instead of decoding them.
+func TestCompare(t *testing.T) {
+	dir := testmodel.TempDir(t, testmodel.Options{MaxLength: 64}).Dir
+	exportTokenizerJSON(t, dir)
+	v1 := factory(marian_v1.NewTokenizer, dir)
+
+	t.Run("v2", func(t *testing.T) { conformance.Compare(t, v1, factory(marian_v2.NewTokenizer, dir)) })
+	t.Run("v4", func(t *testing.T) { conformance.Compare(t, v1, factory(marian_v4.NewTokenizer, dir)) })
+}
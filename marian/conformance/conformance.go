@@ -0,0 +1,537 @@
+// Package conformance checks that a marian.Tokenizer implementation behaves
+// like the reference backends.
+//
+// Use it from a test in the implementation's package:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, func(t *testing.T) marian.Tokenizer {
+//			tok, err := mybackend.NewTokenizer("./models/opus-mt-ru-en")
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return tok
+//		})
+//	}
+//
+// Compare additionally checks that two implementations produce identical
+// ids and texts for the same inputs.
+package conformance
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Factory creates a fresh tokenizer for one check. Run closes it when the
+// check is done (checks that close it themselves rely on a second Close
+// being harmless).
+type Factory func(t *testing.T) marian.Tokenizer
+
+// Corpus is the set of sentences used by the encode, batch and Compare
+// checks. It mixes scripts, punctuation, whitespace and emoji.
+var Corpus = []string{
+	"Привет, как у тебя дела?",
+	"Это тестовая строка для проверки.",
+	"Hello, world!",
+	"  leading and trailing spaces  ",
+	"multiple   inner    spaces",
+	"tab\tand\nnewline",
+	"«Кавычки» — и тире…",
+	"Emoji: 👋🏽 🌍 🇷🇺",
+	"数字 123 и 4.56",
+	"ё Ё й Й",
+	"a",
+}
+
+// longText produces far more tokens than any model_max_length.
+var longText = strings.Repeat("Это очень длинное предложение. ", 2000)
+
+// Run executes every conformance check against the tokenizers returned by
+// factory, each in its own subtest.
+func Run(t *testing.T, factory Factory) {
+	t.Helper()
+
+	checks := []struct {
+		name string
+		fn   func(t *testing.T, tok marian.Tokenizer)
+	}{
+		{"Config", checkConfig},
+		{"EncodeEOS", checkEncodeEOS},
+		{"EncodeEmpty", checkEncodeEmpty},
+		{"EncodeEmoji", checkEncodeEmoji},
+		{"EncodeNUL", checkEncodeNUL},
+		{"EncodeLong", checkEncodeLong},
+		{"EncodeDeterministic", checkEncodeDeterministic},
+		{"EncodeBatch", checkEncodeBatch},
+		{"EncodeBatchEmpty", checkEncodeBatchEmpty},
+		{"EncodeBatchLong", checkEncodeBatchLong},
+		{"EncodeInto", checkEncodeInto},
+		{"EncodeBatchInto", checkEncodeBatchInto},
+		{"DecodeRoundTrip", checkDecodeRoundTrip},
+		{"DecodeEmpty", checkDecodeEmpty},
+		{"DecodeSkipSpecial", checkDecodeSkipSpecial},
+		{"DecodeUnknownIDs", checkDecodeUnknownIDs},
+		{"DecodeNegativeIDs", checkDecodeNegativeIDs},
+		{"Close", checkClose},
+		{"CloseConcurrent", checkCloseConcurrent},
+	}
+
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			tok := factory(t)
+			defer tok.Close()
+			c.fn(t, tok)
+		})
+	}
+}
+
+// Compare checks that want and got produce the same ids, batches and
+// decoded texts for Corpus and the edge-case inputs used by Run.
+func Compare(t *testing.T, want, got Factory) {
+	t.Helper()
+
+	a := want(t)
+	defer a.Close()
+	b := got(t)
+	defer b.Close()
+
+	texts := append(slices.Clone(Corpus), "", "a\x00b", longText)
+
+	for _, addEOS := range []bool{true, false} {
+		for _, text := range texts {
+			wantIDs, wantErr := a.Encode(text, addEOS)
+			gotIDs, gotErr := b.Encode(text, addEOS)
+			if (wantErr == nil) != (gotErr == nil) {
+				t.Errorf("Encode(%.40q, %v): errors differ: want %v, got %v", text, addEOS, wantErr, gotErr)
+				continue
+			}
+			if !slices.Equal(wantIDs, gotIDs) {
+				t.Errorf("Encode(%.40q, %v):\n want %v\n got  %v", text, addEOS, wantIDs, gotIDs)
+			}
+		}
+	}
+
+	wantIDs, wantMask, err := a.EncodeBatch(texts)
+	if err != nil {
+		t.Fatalf("want EncodeBatch: %v", err)
+	}
+	gotIDs, gotMask, err := b.EncodeBatch(texts)
+	if err != nil {
+		t.Fatalf("got EncodeBatch: %v", err)
+	}
+	if !equal2D(wantIDs, gotIDs) || !equal2D(wantMask, gotMask) {
+		t.Errorf("EncodeBatch results differ")
+	}
+
+	for _, text := range Corpus {
+		ids, err := a.Encode(text, true)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", text, err)
+		}
+		for _, skip := range []bool{true, false} {
+			wantText, wantErr := a.Decode(ids, skip)
+			gotText, gotErr := b.Decode(ids, skip)
+			if (wantErr == nil) != (gotErr == nil) || wantText != gotText {
+				t.Errorf("Decode(%v, %v): want %q (%v), got %q (%v)", ids, skip, wantText, wantErr, gotText, gotErr)
+			}
+		}
+	}
+}
+
+func mustConfig(t *testing.T, tok marian.Tokenizer) *marian.Config {
+	t.Helper()
+	cfg, err := tok.Config()
+	if err != nil {
+		t.Fatalf("Config: %v", err)
+	}
+	if cfg == nil {
+		t.Fatalf("Config returned nil")
+	}
+	return cfg
+}
+
+func mustEncode(t *testing.T, tok marian.Tokenizer, text string, addEOS bool) []int64 {
+	t.Helper()
+	ids, err := tok.Encode(text, addEOS)
+	if err != nil {
+		t.Fatalf("Encode(%.40q, %v): %v", text, addEOS, err)
+	}
+	return ids
+}
+
+func checkIDsInRange(t *testing.T, cfg *marian.Config, text string, ids []int64) {
+	t.Helper()
+	for _, id := range ids {
+		if id < 0 || (cfg.VocabSize > 0 && id >= int64(cfg.VocabSize)) {
+			t.Errorf("Encode(%.40q): id %d outside [0, %d)", text, id, cfg.VocabSize)
+		}
+	}
+}
+
+func checkConfig(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	if cfg.ModelMaxLength <= 0 {
+		t.Errorf("ModelMaxLength = %d, want > 0", cfg.ModelMaxLength)
+	}
+	if cfg.VocabSize <= 0 {
+		t.Errorf("VocabSize = %d, want > 0", cfg.VocabSize)
+	}
+	if cfg.DecoderVocabSize <= 0 {
+		t.Errorf("DecoderVocabSize = %d, want > 0 (NormalizeConfig not applied?)", cfg.DecoderVocabSize)
+	}
+
+	again := mustConfig(t, tok)
+	if again.ModelMaxLength != cfg.ModelMaxLength || again.EosTokenID != cfg.EosTokenID {
+		t.Errorf("Config is not stable across calls")
+	}
+}
+
+func checkEncodeEOS(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	for _, text := range Corpus {
+		with := mustEncode(t, tok, text, true)
+		without := mustEncode(t, tok, text, false)
+		checkIDsInRange(t, cfg, text, with)
+
+		if len(without) >= cfg.ModelMaxLength {
+			continue
+		}
+		want := append(slices.Clone(without), cfg.EosTokenID)
+		if !slices.Equal(with, want) {
+			t.Errorf("Encode(%q, true) = %v, want Encode(%q, false) + EOS = %v", text, with, text, want)
+		}
+	}
+}
+
+func checkEncodeEmpty(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	if ids := mustEncode(t, tok, "", false); len(ids) != 0 {
+		t.Errorf(`Encode("", false) = %v, want []`, ids)
+	}
+	if ids := mustEncode(t, tok, "", true); !slices.Equal(ids, []int64{cfg.EosTokenID}) {
+		t.Errorf(`Encode("", true) = %v, want [%d]`, ids, cfg.EosTokenID)
+	}
+}
+
+func checkEncodeEmoji(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	for _, text := range []string{"👋🏽", "🇷🇺🇬🇧", "👨‍👩‍👧‍👦 family", "text 🌍 text"} {
+		ids := mustEncode(t, tok, text, true)
+		checkIDsInRange(t, cfg, text, ids)
+		if len(ids) < 2 {
+			t.Errorf("Encode(%q) = %v, want at least one token before EOS", text, ids)
+		}
+		if _, err := tok.Decode(ids, true); err != nil {
+			t.Errorf("Decode(Encode(%q)): %v", text, err)
+		}
+	}
+}
+
+func checkEncodeNUL(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	for _, text := range []string{"a\x00b", "\x00", "Привет\x00мир"} {
+		ids := mustEncode(t, tok, text, true)
+		checkIDsInRange(t, cfg, text, ids)
+		if again := mustEncode(t, tok, text, true); !slices.Equal(ids, again) {
+			t.Errorf("Encode(%q) is not deterministic: %v vs %v", text, ids, again)
+		}
+		if b, err := marian.EncodeBytes(tok, []byte(text), true); err != nil || !slices.Equal(ids, b) {
+			t.Errorf("EncodeBytes(%q) = %v, %v; Encode gave %v", text, b, err, ids)
+		}
+		if rows, _, err := tok.EncodeBatch([]string{text}); err != nil || len(rows) != 1 || !slices.Equal(rows[0], ids) {
+			t.Errorf("EncodeBatch([%q]) = %v, %v; Encode gave %v", text, rows, err, ids)
+		}
+
+		// The text after the NUL byte must be tokenized too.
+		head, _, _ := strings.Cut(text, "\x00")
+		if truncated := mustEncode(t, tok, head, true); slices.Equal(ids, truncated) {
+			t.Errorf("Encode(%q) = %v, the same as Encode(%q): input truncated at NUL", text, ids, head)
+		}
+	}
+}
+
+func checkEncodeLong(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	for _, addEOS := range []bool{true, false} {
+		ids := mustEncode(t, tok, longText, addEOS)
+		if len(ids) != cfg.ModelMaxLength {
+			t.Errorf("Encode(long, %v): got %d ids, want truncation to model_max_length %d", addEOS, len(ids), cfg.ModelMaxLength)
+		}
+	}
+}
+
+func checkEncodeDeterministic(t *testing.T, tok marian.Tokenizer) {
+	for _, text := range Corpus {
+		a := mustEncode(t, tok, text, true)
+		b := mustEncode(t, tok, text, true)
+		if !slices.Equal(a, b) {
+			t.Errorf("Encode(%q) is not deterministic: %v vs %v", text, a, b)
+		}
+	}
+}
+
+func checkEncodeBatch(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	ids, mask, err := tok.EncodeBatch(Corpus)
+	if err != nil {
+		t.Fatalf("EncodeBatch: %v", err)
+	}
+	if len(ids) != len(Corpus) || len(mask) != len(Corpus) {
+		t.Fatalf("EncodeBatch: got %d rows / %d mask rows, want %d", len(ids), len(mask), len(Corpus))
+	}
+
+	width := 0
+	for i, text := range Corpus {
+		seq := mustEncode(t, tok, text, true)
+		width = max(width, len(seq))
+
+		if len(ids[i]) != len(ids[0]) || len(mask[i]) != len(ids[0]) {
+			t.Fatalf("EncodeBatch: row %d has width %d/%d, want %d", i, len(ids[i]), len(mask[i]), len(ids[0]))
+		}
+		for j := range ids[i] {
+			wantID, wantMask := cfg.PadTokenID, int64(0)
+			if j < len(seq) {
+				wantID, wantMask = seq[j], 1
+			}
+			if ids[i][j] != wantID || mask[i][j] != wantMask {
+				t.Errorf("EncodeBatch row %d (%q) col %d = (%d, %d), want (%d, %d)",
+					i, text, j, ids[i][j], mask[i][j], wantID, wantMask)
+				break
+			}
+		}
+	}
+	if len(ids[0]) != width {
+		t.Errorf("EncodeBatch width = %d, want longest sequence %d", len(ids[0]), width)
+	}
+}
+
+func checkEncodeBatchEmpty(t *testing.T, tok marian.Tokenizer) {
+	ids, mask, err := tok.EncodeBatch(nil)
+	if err != nil {
+		t.Fatalf("EncodeBatch(nil): %v", err)
+	}
+	if len(ids) != 0 || len(mask) != 0 {
+		t.Errorf("EncodeBatch(nil) = %v, %v, want no rows", ids, mask)
+	}
+
+	ids, mask, err = tok.EncodeBatch([]string{"", ""})
+	if err != nil {
+		t.Fatalf(`EncodeBatch(["", ""]): %v`, err)
+	}
+	if len(ids) != 2 || len(ids[0]) != 1 || mask[0][0] != 1 {
+		t.Errorf(`EncodeBatch(["", ""]) = %v, %v, want one EOS per row`, ids, mask)
+	}
+}
+
+func checkEncodeBatchLong(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	ids, _, err := tok.EncodeBatch([]string{"short", longText})
+	if err != nil {
+		t.Fatalf("EncodeBatch(long): %v", err)
+	}
+	if len(ids) != 2 || len(ids[1]) != cfg.ModelMaxLength {
+		t.Errorf("EncodeBatch(long): width %d, want model_max_length %d", len(ids[1]), cfg.ModelMaxLength)
+	}
+}
+
+func checkEncodeInto(t *testing.T, tok marian.Tokenizer) {
+	prefix := []int64{-1, -2}
+	for _, text := range append(slices.Clone(Corpus), "", longText) {
+		want := mustEncode(t, tok, text, true)
+		for _, dst := range [][]int64{nil, slices.Clip(prefix), slices.Grow(slices.Clone(prefix), 1024)} {
+			got, err := marian.EncodeInto(tok, dst, text, true)
+			if err != nil {
+				t.Fatalf("EncodeInto(%.40q): %v", text, err)
+			}
+			if !slices.Equal(got[:len(dst)], dst) || !slices.Equal(got[len(dst):], want) {
+				t.Errorf("EncodeInto(%v, %.40q) = %v, want dst followed by %v", dst, text, got, want)
+			}
+		}
+	}
+}
+
+func checkEncodeBatchInto(t *testing.T, tok marian.Tokenizer) {
+	texts := append(slices.Clone(Corpus), "", longText)
+	wantIDs, wantMask, err := tok.EncodeBatch(texts)
+	if err != nil {
+		t.Fatalf("EncodeBatch: %v", err)
+	}
+
+	n, err := marian.BatchBufferLen(tok, len(texts))
+	if err != nil {
+		t.Fatalf("BatchBufferLen: %v", err)
+	}
+	if _, err := marian.EncodeBatchInto(tok, make([]int64, n-1), nil, texts); err == nil {
+		t.Errorf("EncodeBatchInto with a short buffer: no error")
+	}
+
+	ids, mask := make([]int64, n), make([]int64, n)
+	width, err := marian.EncodeBatchInto(tok, ids, mask, texts)
+	if err != nil {
+		t.Fatalf("EncodeBatchInto: %v", err)
+	}
+	for i := range texts {
+		row := ids[i*width : (i+1)*width]
+		m := mask[i*width : (i+1)*width]
+		if !slices.Equal(row, wantIDs[i]) || !slices.Equal(m, wantMask[i]) {
+			t.Errorf("EncodeBatchInto row %d = %v / %v, want %v / %v", i, row, m, wantIDs[i], wantMask[i])
+		}
+	}
+
+	if _, err := marian.EncodeBatchInto(tok, ids, nil, texts); err != nil {
+		t.Errorf("EncodeBatchInto without mask: %v", err)
+	}
+}
+
+func checkDecodeRoundTrip(t *testing.T, tok marian.Tokenizer) {
+	for _, text := range Corpus {
+		ids := mustEncode(t, tok, text, true)
+		withEOS, err := tok.Decode(ids, true)
+		if err != nil {
+			t.Errorf("Decode(Encode(%q)): %v", text, err)
+			continue
+		}
+		if _, err := tok.Decode(ids, false); err != nil {
+			t.Errorf("Decode(Encode(%q), false): %v", text, err)
+		}
+		withoutEOS, err := tok.Decode(ids[:len(ids)-1], true)
+		if err != nil {
+			t.Errorf("Decode(Encode(%q) without EOS): %v", text, err)
+			continue
+		}
+		if withEOS != withoutEOS {
+			t.Errorf("Decode(%v, true) = %q, want EOS to be skipped: %q", ids, withEOS, withoutEOS)
+		}
+	}
+}
+
+func checkDecodeEmpty(t *testing.T, tok marian.Tokenizer) {
+	for _, ids := range [][]int64{nil, {}} {
+		for _, skip := range []bool{true, false} {
+			text, err := tok.Decode(ids, skip)
+			if err != nil || text != "" {
+				t.Errorf("Decode(%v, %v) = %q, %v, want \"\", nil", ids, skip, text, err)
+			}
+		}
+	}
+}
+
+func checkDecodeSkipSpecial(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	ids := []int64{cfg.EosTokenID, cfg.PadTokenID, cfg.EosTokenID}
+	text, err := tok.Decode(ids, true)
+	if err != nil || text != "" {
+		t.Errorf("Decode(%v, true) = %q, %v, want \"\", nil", ids, text, err)
+	}
+}
+
+// IDs outside the vocabulary decode as the unknown token, which the skip
+// list does not remove.
+func checkDecodeUnknownIDs(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	known := mustEncode(t, tok, Corpus[0], false)
+	for _, id := range []int64{int64(cfg.VocabSize), int64(cfg.VocabSize) + 1000, 1 << 40} {
+		checkDecodesAsUnk(t, tok, cfg, append(slices.Clone(known), id), len(known))
+	}
+}
+
+func checkDecodeNegativeIDs(t *testing.T, tok marian.Tokenizer) {
+	cfg := mustConfig(t, tok)
+	known := mustEncode(t, tok, Corpus[0], false)
+	for _, id := range []int64{-1, -1 << 40} {
+		checkDecodesAsUnk(t, tok, cfg, append([]int64{id}, known...), 0)
+	}
+}
+
+// checkDecodesAsUnk checks that ids decodes like ids with ids[i] replaced
+// by the unknown token, with and without skipSpecial.
+func checkDecodesAsUnk(t *testing.T, tok marian.Tokenizer, cfg *marian.Config, ids []int64, i int) {
+	t.Helper()
+	var want string
+	if cfg.UnkTokenID >= 0 {
+		unk := slices.Clone(ids)
+		unk[i] = cfg.UnkTokenID
+		var err error
+		if want, err = tok.Decode(unk, false); err != nil {
+			t.Fatalf("Decode(%v, false): %v", unk, err)
+		}
+	}
+	for _, skip := range []bool{true, false} {
+		got, err := tok.Decode(ids, skip)
+		if err != nil {
+			t.Errorf("Decode(id %d, %v): %v", ids[i], skip, err)
+			continue
+		}
+		if cfg.UnkTokenID >= 0 && got != want {
+			t.Errorf("Decode(id %d, %v) = %q, want the unknown token: %q", ids[i], skip, got, want)
+		}
+	}
+}
+
+func checkClose(t *testing.T, tok marian.Tokenizer) {
+	ids := mustEncode(t, tok, Corpus[0], true)
+	tok.Close()
+
+	if _, err := tok.Encode(Corpus[0], true); !errors.Is(err, marian.ErrClosed) {
+		t.Errorf("Encode after Close: got %v, want ErrClosed", err)
+	}
+	if _, _, err := tok.EncodeBatch(Corpus[:1]); !errors.Is(err, marian.ErrClosed) {
+		t.Errorf("EncodeBatch after Close: got %v, want ErrClosed", err)
+	}
+	if _, err := tok.Decode(ids, true); !errors.Is(err, marian.ErrClosed) {
+		t.Errorf("Decode after Close: got %v, want ErrClosed", err)
+	}
+
+	// A second Close must be a no-op.
+	tok.Close()
+}
+
+// checkCloseConcurrent closes the tokenizer while other goroutines use it:
+// every call must either succeed or fail with ErrClosed.
+func checkCloseConcurrent(t *testing.T, tok marian.Tokenizer) {
+	ids := mustEncode(t, tok, Corpus[0], true)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < cap(errs); g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				var err error
+				switch i % 3 {
+				case 0:
+					_, err = tok.Encode(Corpus[i%len(Corpus)], true)
+				case 1:
+					_, _, err = tok.EncodeBatch(Corpus)
+				case 2:
+					_, err = tok.Decode(ids, true)
+				}
+				if err != nil {
+					if !errors.Is(err, marian.ErrClosed) {
+						errs <- err
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	tok.Close()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("call during Close: got %v, want success or ErrClosed", err)
+	}
+}
+
+func equal2D(a, b [][]int64) bool {
+	return slices.EqualFunc(a, b, func(x, y []int64) bool { return slices.Equal(x, y) })
+}
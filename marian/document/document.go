@@ -0,0 +1,289 @@
+// Package document translates whole documents with a sentence-level
+// Marian tokenizer.
+//
+// Encode splits a text into sentences, makes every segment fit the model's
+// maximum length and encodes all segments as one batch. After the model has
+// translated the batch, Decode (or Reassemble for already decoded text) puts
+// the translations back into the original layout: paragraphs, line breaks
+// and the white space between sentences are preserved.
+//
+//	doc, err := document.Encode(tok, text, document.Options{Lang: "ru"})
+//	// translate doc.InputIDs / doc.AttentionMask ...
+//	translated, err := doc.Decode(targetTok, outputIDs)
+package document
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Options configures Encode.
+type Options struct {
+	// Lang selects the sentence splitting profile with ProfileFor. It is
+	// ignored when Profile is set.
+	Lang string
+	// Profile overrides the sentence splitting profile.
+	Profile *Profile
+	// MaxTokens is the maximum segment length in tokens, EOS included
+	// (default: the tokenizer's ModelMaxLength). Longer sentences are split
+	// at clause punctuation, then between words. Since backends truncate at
+	// ModelMaxLength, segments always stay below it.
+	MaxTokens int
+	// Merge joins consecutive sentences of a line into one segment as long
+	// as the segment still fits MaxTokens, giving the model more context
+	// per segment.
+	Merge bool
+}
+
+// Document is a text split into segments that can be translated separately
+// and reassembled.
+type Document struct {
+	// Segments are the texts to translate, in document order.
+	Segments []string
+	// InputIDs and AttentionMask are the EncodeBatch result for Segments,
+	// set by Encode.
+	InputIDs      [][]int64
+	AttentionMask [][]int64
+
+	text  string
+	spans []span
+}
+
+// Split splits text into sentences with profile p (Generic if nil), without
+// length limits.
+func Split(text string, p *Profile) *Document {
+	if p == nil {
+		p = Generic
+	}
+	return newDocument(text, sentences(text, p))
+}
+
+func newDocument(text string, spans []span) *Document {
+	segments := make([]string, len(spans))
+	for i, sp := range spans {
+		segments[i] = text[sp.start:sp.end]
+	}
+	return &Document{Segments: segments, text: text, spans: spans}
+}
+
+// Encode splits text into segments of at most opts.MaxTokens tokens and
+// encodes them with tok.EncodeBatch.
+func Encode(tok marian.Tokenizer, text string, opts Options) (*Document, error) {
+	p := opts.Profile
+	if p == nil {
+		p = ProfileFor(opts.Lang)
+	}
+	cfg, err := tok.Config()
+	if err != nil {
+		return nil, err
+	}
+	maxTokens := opts.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = cfg.ModelMaxLength
+	}
+	if maxTokens < 2 {
+		return nil, fmt.Errorf("document: max tokens %d leaves no room for text and EOS", maxTokens)
+	}
+
+	f := &fitter{tok: tok, text: text, max: maxTokens, limit: cfg.ModelMaxLength}
+	var spans []span
+	for _, sp := range sentences(text, p) {
+		parts, err := f.fit(sp)
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, parts...)
+	}
+	if opts.Merge {
+		if spans, err = f.merge(spans); err != nil {
+			return nil, err
+		}
+	}
+
+	doc := newDocument(text, spans)
+	if len(doc.Segments) > 0 {
+		doc.InputIDs, doc.AttentionMask, err = tok.EncodeBatch(doc.Segments)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// Reassemble replaces every segment of the original text with the matching
+// translation and returns the result. Text between segments (white space,
+// line breaks) is kept as it was.
+func (d *Document) Reassemble(translations []string) (string, error) {
+	if len(translations) != len(d.spans) {
+		return "", fmt.Errorf("document: got %d translations for %d segments", len(translations), len(d.spans))
+	}
+
+	var b strings.Builder
+	prev := 0
+	for i, sp := range d.spans {
+		b.WriteString(d.text[prev:sp.start])
+		b.WriteString(translations[i])
+		prev = sp.end
+	}
+	b.WriteString(d.text[prev:])
+	return b.String(), nil
+}
+
+// Decode decodes one output row per segment with tok (skipping special
+// tokens) and reassembles the document.
+func (d *Document) Decode(tok marian.Tokenizer, outputs [][]int64) (string, error) {
+	if len(outputs) != len(d.spans) {
+		return "", fmt.Errorf("document: got %d outputs for %d segments", len(outputs), len(d.spans))
+	}
+	translations := make([]string, len(outputs))
+	for i, ids := range outputs {
+		text, err := tok.Decode(ids, true)
+		if err != nil {
+			return "", fmt.Errorf("segment %d: %w", i, err)
+		}
+		translations[i] = text
+	}
+	return d.Reassemble(translations)
+}
+
+// fitter measures spans of text in tokens.
+type fitter struct {
+	tok   marian.Tokenizer
+	text  string
+	max   int
+	limit int // the tokenizer's ModelMaxLength
+}
+
+func (f *fitter) fits(sp span) (bool, error) {
+	ids, err := f.tok.Encode(f.text[sp.start:sp.end], true)
+	if err != nil {
+		return false, err
+	}
+	// Backends truncate to ModelMaxLength, so a span that encodes to
+	// exactly that many tokens may have been cut and counts as too long.
+	if f.limit > 0 && len(ids) >= f.limit {
+		return false, nil
+	}
+	return len(ids) <= f.max, nil
+}
+
+// fit splits sp into spans that fit f.max tokens: at clause punctuation
+// first, then between words, and as a last resort inside a word.
+func (f *fitter) fit(sp span) ([]span, error) {
+	var out []span
+	for {
+		ok, err := f.fits(sp)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return append(out, sp), nil
+		}
+
+		var best span
+		for _, breaks := range []func(span) []int{f.clauseBreaks, f.wordBreaks, f.runeBreaks} {
+			cuts := breaks(sp)
+			// Binary search for the longest prefix that fits; token
+			// counts grow with the prefix length.
+			lo, hi := 0, len(cuts)
+			for lo < hi {
+				mid := (lo + hi) / 2
+				ok, err := f.fits(f.trimmed(span{sp.start, cuts[mid]}))
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					lo = mid + 1
+				} else {
+					hi = mid
+				}
+			}
+			if lo > 0 {
+				best = f.trimmed(span{sp.start, cuts[lo-1]})
+				break
+			}
+		}
+		if best.end <= best.start {
+			return nil, errors.New("document: a single character exceeds the token limit")
+		}
+		out = append(out, best)
+		sp = f.trimmed(span{best.end, sp.end})
+		if sp.start == sp.end {
+			return out, nil
+		}
+	}
+}
+
+func (f *fitter) trimmed(sp span) span {
+	sp.start, sp.end = trim(f.text, sp.start, sp.end)
+	return sp
+}
+
+// clauseBreaks returns the offsets after clause punctuation followed by a
+// space, and before dashes surrounded by spaces.
+func (f *fitter) clauseBreaks(sp span) []int {
+	var cuts []int
+	s := f.text[sp.start:sp.end]
+	for i, r := range s {
+		switch r {
+		case ',', ';', ':':
+			if next, _ := utf8.DecodeRuneInString(s[i+1:]); unicode.IsSpace(next) {
+				cuts = append(cuts, sp.start+i+1)
+			}
+		case '—', '–', '-':
+			if i > 0 && s[i-1] == ' ' {
+				cuts = append(cuts, sp.start+i-1)
+			}
+		}
+	}
+	return cuts
+}
+
+// wordBreaks returns the offsets of the white space between words.
+func (f *fitter) wordBreaks(sp span) []int {
+	var cuts []int
+	s := f.text[sp.start:sp.end]
+	for i, r := range s {
+		if unicode.IsSpace(r) && i > 0 {
+			cuts = append(cuts, sp.start+i)
+		}
+	}
+	return cuts
+}
+
+// runeBreaks returns every rune boundary.
+func (f *fitter) runeBreaks(sp span) []int {
+	var cuts []int
+	s := f.text[sp.start:sp.end]
+	for i := range s {
+		if i > 0 {
+			cuts = append(cuts, sp.start+i)
+		}
+	}
+	return cuts
+}
+
+// merge joins consecutive spans on the same line while they fit.
+func (f *fitter) merge(spans []span) ([]span, error) {
+	var out []span
+	for _, sp := range spans {
+		if n := len(out); n > 0 && !strings.Contains(f.text[out[n-1].end:sp.start], "\n") {
+			joined := span{out[n-1].start, sp.end}
+			ok, err := f.fits(joined)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out[n-1] = joined
+				continue
+			}
+		}
+		out = append(out, sp)
+	}
+	return out, nil
+}
@@ -0,0 +1,201 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		text    string
+		profile *Profile
+		want    []string
+	}{
+		{"Hello world. How are you?", nil, []string{"Hello world.", "How are you?"}},
+		{"  \n\n \t", nil, nil},
+		{"Line one\nline two", nil, []string{"Line one", "line two"}},
+		{"Wait... what? Yes!", nil, []string{"Wait... what?", "Yes!"}},
+		{"Pi is 3.14 today. Really.", nil, []string{"Pi is 3.14 today.", "Really."}},
+		{`He said "Stop." Then he left.`, nil, []string{`He said "Stop."`, "Then he left."}},
+		{"Done. «Next» one.", nil, []string{"Done.", "«Next» one."}},
+		{"Done. - Next one.", nil, []string{"Done.", "- Next one."}},
+		{"J. Smith came. The U.S. Army left.", nil, []string{"J. Smith came.", "The U.S. Army left."}},
+		{"Dr. Smith came. He sat.", English, []string{"Dr. Smith came.", "He sat."}},
+		{"Dr. Smith came. He sat.", Generic, []string{"Dr.", "Smith came.", "He sat."}},
+		{"See No. 5 and No. More.", English, []string{"See No. 5 and No.", "More."}},
+		{"Он живёт на ул. Ленина. Там тихо.", Russian, []string{"Он живёт на ул. Ленина.", "Там тихо."}},
+		{"Он живёт на ул. Ленина.", Generic, []string{"Он живёт на ул.", "Ленина."}},
+		{"Am 3. Oktober kam er.", German, []string{"Am 3. Oktober kam er."}},
+		{"It was 3. Then 4.", English, []string{"It was 3.", "Then 4."}},
+	}
+	for _, tt := range tests {
+		got := Split(tt.text, tt.profile).Segments
+		if !slices.Equal(got, tt.want) && (len(got) > 0 || len(tt.want) > 0) {
+			t.Errorf("Split(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestProfileFor(t *testing.T) {
+	for lang, want := range map[string]*Profile{
+		"en": English, "en-US": English, "RU": Russian, "de-AT": German, "fr": Generic, "": Generic,
+	} {
+		if got := ProfileFor(lang); got != want {
+			t.Errorf("ProfileFor(%q) returned the wrong profile", lang)
+		}
+	}
+}
+
+func TestReassemble(t *testing.T) {
+	text := "  First one. Second one.\n\n\tIndented para!  \r\nLast line"
+	doc := Split(text, nil)
+	if want := []string{"First one.", "Second one.", "Indented para!", "Last line"}; !slices.Equal(doc.Segments, want) {
+		t.Fatalf("Segments = %q, want %q", doc.Segments, want)
+	}
+
+	// Segments are offsets into the text: putting them back gives the text.
+	got, err := doc.Reassemble(doc.Segments)
+	if err != nil || got != text {
+		t.Errorf("Reassemble(Segments) = %q, %v, want the original text", got, err)
+	}
+
+	got, err = doc.Reassemble([]string{"A.", "Bb.", "", "D"})
+	if want := "  A. Bb.\n\n\t  \r\nD"; err != nil || got != want {
+		t.Errorf("Reassemble = %q, %v, want %q", got, err, want)
+	}
+
+	if _, err := doc.Reassemble([]string{"A."}); err == nil {
+		t.Error("Reassemble with too few translations succeeded")
+	}
+}
+
+// wordTokenizer encodes a token per word, plus one per 4 more runes of long
+// words, and EOS as 0; it truncates to maxLen like the backends. Decode
+// returns "t<id>" per id.
+type wordTokenizer struct {
+	maxLen  int
+	encoded int // texts passed to EncodeBatch
+}
+
+func (w *wordTokenizer) Encode(text string, addEOS bool) ([]int64, error) {
+	var ids []int64
+	for _, word := range strings.Fields(text) {
+		for range (utf8.RuneCountInString(word) + 3) / 4 {
+			ids = append(ids, int64(len(word)))
+		}
+	}
+	if addEOS {
+		ids = append(ids, 0)
+	}
+	if len(ids) > w.maxLen {
+		ids = ids[:w.maxLen]
+	}
+	return ids, nil
+}
+
+func (w *wordTokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	w.encoded += len(texts)
+	rows := make([][]int64, len(texts))
+	for i, text := range texts {
+		rows[i], _ = w.Encode(text, true)
+	}
+	ids, mask := marian.PadBatch(rows, -1)
+	return ids, mask, nil
+}
+
+func (w *wordTokenizer) Decode(ids []int64, _ bool) (string, error) {
+	words := make([]string, len(ids))
+	for i, id := range ids {
+		if id < 0 {
+			return "", errors.New("bad id")
+		}
+		words[i] = fmt.Sprintf("t%d", id)
+	}
+	return strings.Join(words, " "), nil
+}
+
+func (w *wordTokenizer) Config() (*marian.Config, error) {
+	return &marian.Config{ModelMaxLength: w.maxLen}, nil
+}
+
+func (w *wordTokenizer) Close() {}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		maxLen int
+		opts   Options
+		want   []string
+	}{
+		{"sentences", "Hi there. Bye.\n\nNext para.", 64, Options{},
+			[]string{"Hi there.", "Bye.", "Next para."}},
+		{"clauses", "One two, six ten one. Ten.", 64, Options{MaxTokens: 4},
+			[]string{"One two,", "six ten one.", "Ten."}},
+		{"dashes", "One two three — four five.", 64, Options{MaxTokens: 5},
+			[]string{"One two three", "— four five."}},
+		{"words", "a b c d e f", 64, Options{MaxTokens: 4},
+			[]string{"a b c", "d e f"}},
+		{"runes", "abcdefghijkl", 64, Options{MaxTokens: 3},
+			[]string{"abcdefgh", "ijkl"}},
+		// A segment of model_max_length tokens may have been truncated.
+		{"model max length", "a b c d", 4, Options{},
+			[]string{"a b", "c d"}},
+		{"merge", "A b. C d. E f g h i.\nJ.", 64, Options{MaxTokens: 5, Merge: true},
+			[]string{"A b. C d.", "E f g h", "i.", "J."}},
+		{"profile", "Dr. Who came.", 64, Options{Lang: "en"},
+			[]string{"Dr. Who came."}},
+		{"profile override", "Dr. Who came.", 64, Options{Lang: "en", Profile: Generic},
+			[]string{"Dr.", "Who came."}},
+		{"empty", " \n ", 64, Options{}, nil},
+	}
+	for _, tt := range tests {
+		tok := &wordTokenizer{maxLen: tt.maxLen}
+		doc, err := Encode(tok, tt.text, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(doc.Segments, tt.want) && (len(doc.Segments) > 0 || len(tt.want) > 0) {
+			t.Errorf("%s: Segments = %q, want %q", tt.name, doc.Segments, tt.want)
+		}
+		if len(doc.InputIDs) != len(doc.Segments) || len(doc.AttentionMask) != len(doc.Segments) || tok.encoded != len(doc.Segments) {
+			t.Errorf("%s: %d rows for %d segments", tt.name, len(doc.InputIDs), len(doc.Segments))
+		}
+		if got, err := doc.Reassemble(doc.Segments); err != nil || got != tt.text {
+			t.Errorf("%s: Reassemble(Segments) = %q, %v, want the original text", tt.name, got, err)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := Encode(&wordTokenizer{maxLen: 64}, "text", Options{MaxTokens: 1}); err == nil {
+		t.Error("Encode with MaxTokens 1 succeeded")
+	}
+	// Every span is at model_max_length, so nothing fits.
+	if _, err := Encode(&wordTokenizer{maxLen: 2}, "a", Options{}); err == nil {
+		t.Error("Encode of an unsplittable text succeeded")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	doc := Split("Hi. There.\n\n  Bye.", nil)
+	tok := &wordTokenizer{maxLen: 64}
+
+	got, err := doc.Decode(tok, [][]int64{{1}, {2, 3}, {4}})
+	if want := "t1 t2 t3\n\n  t4"; err != nil || got != want {
+		t.Errorf("Decode = %q, %v, want %q", got, err, want)
+	}
+	if _, err := doc.Decode(tok, [][]int64{{1}}); err == nil {
+		t.Error("Decode with too few outputs succeeded")
+	}
+	if _, err := doc.Decode(tok, [][]int64{{1}, {-1}, {4}}); err == nil || !strings.HasPrefix(err.Error(), "segment 1:") {
+		t.Errorf("Decode of a bad row: %v, want the segment in the error", err)
+	}
+}
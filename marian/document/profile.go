@@ -0,0 +1,86 @@
+package document
+
+import "strings"
+
+// Profile holds the language rules used to find sentence boundaries.
+type Profile struct {
+	// Abbreviations never end a sentence when followed by a period.
+	// Entries are matched as written and in lower case, without the final
+	// period ("Dr", "e.g", "т.е").
+	Abbreviations map[string]bool
+	// NumericAbbreviations don't end a sentence when followed by a period
+	// and a number ("No. 5", "S. 12").
+	NumericAbbreviations map[string]bool
+	// OrdinalNumbers treats a number followed by a period as an ordinal
+	// ("am 3. Oktober"), not as the end of a sentence.
+	OrdinalNumbers bool
+}
+
+func set(words string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(words) {
+		m[w] = true
+	}
+	return m
+}
+
+// English is the profile for English.
+var English = &Profile{
+	Abbreviations: set(`
+		Mr Mrs Ms Messrs Dr Prof Sr Jr St Mt Rev Hon Gen Col Lt Sgt Capt Cmdr Gov Sen Rep Pres
+		Inc Ltd Co Corp Bros Dept Univ Assn Ave Blvd Rd
+		Jan Feb Mar Apr Jun Jul Aug Sep Sept Oct Nov Dec
+		e.g i.e etc vs cf al approx est
+	`),
+	NumericAbbreviations: set(`No Nos Art Nr pp p fig Fig vol Vol ch Ch`),
+}
+
+// Russian is the profile for Russian.
+var Russian = &Profile{
+	Abbreviations: set(`
+		г гг в вв т тт др пр ул пл пер просп д кв им ср см стр тыс млн млрд руб коп
+		проф акад доц канд чл.-корр ген полк подп
+		т.е т.д т.п т.к т.н т.о н.э напр рис табл гл прим
+		янв фев февр мар апр авг сен сент окт нояб дек
+	`),
+	NumericAbbreviations: set(`№ с стр рис табл гл п пп ч ст`),
+}
+
+// German is the profile for German.
+var German = &Profile{
+	Abbreviations: set(`
+		Dr Prof Hr Fr Frl Dipl Ing Mag St
+		bzw ca usw vgl evtl ggf inkl bspw sog etc Str Abs Jh Jhd Mio Mrd
+		z.B d.h u.a o.ä s.o s.u u.U z.T
+		Jan Feb Mär Apr Jun Jul Aug Sep Sept Okt Nov Dez
+	`),
+	NumericAbbreviations: set(`Nr S Art Abs Bd Kap`),
+	OrdinalNumbers:       true,
+}
+
+// Generic only knows the rules shared by all languages: initials and
+// dotted acronyms.
+var Generic = &Profile{}
+
+// ProfileFor returns the profile for a language code ("ru", "en-US", ...),
+// or Generic when there is none.
+func ProfileFor(lang string) *Profile {
+	lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+	switch lang {
+	case "en":
+		return English
+	case "ru":
+		return Russian
+	case "de":
+		return German
+	}
+	return Generic
+}
+
+func (p *Profile) abbreviation(word string) bool {
+	return p.Abbreviations[word] || p.Abbreviations[strings.ToLower(word)]
+}
+
+func (p *Profile) numericAbbreviation(word string) bool {
+	return p.NumericAbbreviations[word] || p.NumericAbbreviations[strings.ToLower(word)]
+}
@@ -0,0 +1,184 @@
+package document
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// span is a byte range of the document text.
+type span struct {
+	start, end int
+}
+
+func isTerminator(r rune) bool {
+	return r == '.' || r == '!' || r == '?' || r == '…'
+}
+
+// isCloser reports whether r may follow a terminator and still belong to
+// the sentence: closing quotes and brackets.
+func isCloser(r rune) bool {
+	switch r {
+	case '"', '\'', ')', ']', '}', '»', '”', '’', '“':
+		return true
+	}
+	return false
+}
+
+// isOpener reports whether r may precede the first word of a sentence.
+func isOpener(r rune) bool {
+	switch r {
+	case '"', '\'', '(', '[', '{', '«', '„', '“', '‘', '¿', '¡':
+		return true
+	}
+	return false
+}
+
+// sentences returns the sentence spans of text. Line breaks always end a
+// sentence; within a line a sentence ends after terminal punctuation that
+// is followed by white space and a word that doesn't start in lower case,
+// unless p says the period belongs to an abbreviation, initial or number.
+func sentences(text string, p *Profile) []span {
+	var spans []span
+	lineStart := 0
+	for lineStart <= len(text) {
+		lineEnd := strings.IndexByte(text[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(text)
+		} else {
+			lineEnd += lineStart
+		}
+		spans = appendLine(spans, text, lineStart, lineEnd, p)
+		lineStart = lineEnd + 1
+	}
+	return spans
+}
+
+func appendLine(spans []span, text string, start, end int, p *Profile) []span {
+	start, end = trim(text, start, end)
+	if start == end {
+		return spans
+	}
+
+	sentStart := start
+	for i := start; i < end; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !isTerminator(r) {
+			i += size
+			continue
+		}
+
+		// Terminators, then closers, then white space.
+		j := i
+		for j < end {
+			r, size := utf8.DecodeRuneInString(text[j:])
+			if !isTerminator(r) {
+				break
+			}
+			j += size
+		}
+		for j < end {
+			r, size := utf8.DecodeRuneInString(text[j:])
+			if !isCloser(r) {
+				break
+			}
+			j += size
+		}
+		k := j
+		for k < end {
+			r, size := utf8.DecodeRuneInString(text[k:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			k += size
+		}
+		if k == j {
+			// "3.14", "?!" inside a word, or the end of the line.
+			i = j
+			continue
+		}
+
+		if boundary(text[sentStart:i], text[i:j], text[k:end], p) {
+			spans = append(spans, span{sentStart, j})
+			sentStart = k
+		}
+		i = k
+	}
+	return append(spans, span{sentStart, end})
+}
+
+// boundary decides whether the sentence before ends with punct, given the
+// text that follows it.
+func boundary(before, punct, next string, p *Profile) bool {
+	n, _ := utf8.DecodeRuneInString(next)
+	for isOpener(n) || n == '-' || n == '—' || n == '–' {
+		next = strings.TrimLeft(next[utf8.RuneLen(n):], " ")
+		if next == "" {
+			return true
+		}
+		n, _ = utf8.DecodeRuneInString(next)
+	}
+	if unicode.IsLower(n) {
+		return false
+	}
+	if punct != "." {
+		return true
+	}
+
+	word := lastWord(before)
+	switch {
+	case word == "":
+		return true
+	case p.abbreviation(word):
+		return false
+	case p.numericAbbreviation(word) && unicode.IsDigit(n):
+		return false
+	case utf8.RuneCountInString(word) == 1 && unicode.IsLetter([]rune(word)[0]):
+		// An initial: "J. Smith", "А. С. Пушкин".
+		return false
+	case strings.Contains(word, ".") && strings.IndexFunc(word, unicode.IsLetter) >= 0:
+		// A dotted acronym: "U.S.", "т.е.".
+		return false
+	case p.OrdinalNumbers && isNumber(word):
+		return false
+	}
+	return true
+}
+
+// lastWord returns the last white-space separated word of s without
+// leading opening punctuation.
+func lastWord(s string) string {
+	if i := strings.LastIndexFunc(s, unicode.IsSpace); i >= 0 {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		s = s[i+size:]
+	}
+	return strings.TrimLeftFunc(s, isOpener)
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// trim shrinks [start, end) to exclude leading and trailing white space.
+func trim(text string, start, end int) (int, int) {
+	for start < end {
+		r, size := utf8.DecodeRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		start += size
+	}
+	for end > start {
+		r, size := utf8.DecodeLastRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		end -= size
+	}
+	return start, end
+}
@@ -0,0 +1,275 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/internal/spm"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// The tokenizer.json schema of the HuggingFace tokenizers library, limited
+// to the components a Marian source tokenizer needs. Field order follows
+// the files written by tokenizers itself.
+
+type hfTokenizer struct {
+	Version       string         `json:"version"`
+	Truncation    *hfTruncation  `json:"truncation"`
+	Padding       any            `json:"padding"`
+	AddedTokens   []hfAddedToken `json:"added_tokens"`
+	Normalizer    any            `json:"normalizer"`
+	PreTokenizer  hfMetaspace    `json:"pre_tokenizer"`
+	PostProcessor hfTemplate     `json:"post_processor"`
+	Decoder       hfMetaspace    `json:"decoder"`
+	Model         hfUnigramModel `json:"model"`
+}
+
+type hfTruncation struct {
+	Direction string `json:"direction"`
+	MaxLength int    `json:"max_length"`
+	Strategy  string `json:"strategy"`
+	Stride    int    `json:"stride"`
+}
+
+type hfAddedToken struct {
+	ID         int64  `json:"id"`
+	Content    string `json:"content"`
+	SingleWord bool   `json:"single_word"`
+	LStrip     bool   `json:"lstrip"`
+	RStrip     bool   `json:"rstrip"`
+	Normalized bool   `json:"normalized"`
+	Special    bool   `json:"special"`
+}
+
+type hfNormalizerSequence struct {
+	Type        string `json:"type"`
+	Normalizers []any  `json:"normalizers"`
+}
+
+type hfPrecompiled struct {
+	Type                string `json:"type"`
+	PrecompiledCharsmap []byte `json:"precompiled_charsmap"`
+}
+
+type hfStrip struct {
+	Type       string `json:"type"`
+	StripLeft  bool   `json:"strip_left"`
+	StripRight bool   `json:"strip_right"`
+}
+
+type hfReplace struct {
+	Type    string            `json:"type"`
+	Pattern map[string]string `json:"pattern"`
+	Content string            `json:"content"`
+}
+
+type hfMetaspace struct {
+	Type          string `json:"type"`
+	Replacement   string `json:"replacement"`
+	PrependScheme string `json:"prepend_scheme"`
+	Split         bool   `json:"split"`
+}
+
+type hfTemplate struct {
+	Type          string                     `json:"type"`
+	Single        []map[string]any           `json:"single"`
+	Pair          []map[string]any           `json:"pair"`
+	SpecialTokens map[string]hfSpecialTokens `json:"special_tokens"`
+}
+
+type hfSpecialTokens struct {
+	ID     string   `json:"id"`
+	IDs    []int64  `json:"ids"`
+	Tokens []string `json:"tokens"`
+}
+
+type hfUnigramModel struct {
+	Type         string  `json:"type"`
+	UnkID        int64   `json:"unk_id"`
+	Vocab        [][]any `json:"vocab"`
+	ByteFallback bool    `json:"byte_fallback"`
+}
+
+// unkPenalty is how far below the lowest piece score tokenizers scores
+// unknown characters, as SentencePiece does.
+const unkPenalty = 10
+
+// HFTokenizer builds a HuggingFace tokenizer.json for the source side of m:
+//
+//   - model: Unigram over the Marian vocabulary, in Marian id order, with
+//     the scores of source.spm
+//   - normalizer: the precompiled charsmap of source.spm, plus stripping and
+//     collapsing of white space when the spm removes extra white space
+//   - pre_tokenizer and decoder: Metaspace ("▁", prepended like the spm's
+//     add_dummy_prefix)
+//   - post_processor: "$A </s>", like Encode with EOS
+//   - truncation: model_max_length, from the right
+//
+// Inputs longer than model_max_length are truncated differently: tokenizers
+// and marian_v4 reserve room for the post-processor's </s>, giving
+// model_max_length-1 pieces and </s>, while the SentencePiece backends
+// append </s> first and cut the result, giving model_max_length pieces and
+// no </s>. tokenizer.json can't express the latter.
+//
+// Caveat: pieces that only exist in the target spm are part of a shared
+// Marian vocabulary but unknown to source.spm, which never produces them.
+// Unigram has no way to disable a piece, so they get a score below any
+// segmentation of their text into source pieces, and never win while
+// source.spm can encode that text; a character source.spm lacks (Encode
+// maps it to <unk>) may still come out as a target-only piece. Source
+// pieces missing from the vocabulary have no id and can't be represented
+// at all.
+func HFTokenizer(m *marian.Model) ([]byte, error) {
+	sp, err := spm.Load(m.Layout.SourceSPM)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := Tokens(m.SourceVocab)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]float64, len(sp.Pieces))
+	minScore := math.Inf(1)
+	for _, p := range sp.Pieces {
+		if p.Type != spm.Normal && p.Type != spm.UserDefined {
+			continue
+		}
+		if _, dup := scores[p.Piece]; !dup {
+			scores[p.Piece] = float64(p.Score)
+		}
+		minScore = math.Min(minScore, float64(p.Score))
+	}
+	if math.IsInf(minScore, 1) {
+		return nil, fmt.Errorf("export: %s has no normal pieces", m.Layout.SourceSPM)
+	}
+
+	// A piece of n runes competes with segmentations into at most n source
+	// pieces, each scoring at least minScore.
+	targetOnly := 0
+	for _, tok := range tokens {
+		if _, ok := scores[tok]; !ok {
+			targetOnly = max(targetOnly, utf8.RuneCountInString(tok))
+		}
+	}
+	targetOnlyScore := math.Min(minScore, 0)*float64(targetOnly+1) - unkPenalty
+
+	cfg := m.Config
+	unkID, ok := m.SourceVocab.ID("<unk>")
+	if !ok {
+		return nil, fmt.Errorf("export: vocabulary has no <unk>")
+	}
+	eos := tokens[cfg.EosTokenID]
+	special := map[int64]bool{cfg.EosTokenID: true, unkID: true, cfg.PadTokenID: true}
+
+	vocab := make([][]any, len(tokens))
+	var added []hfAddedToken
+	for id, tok := range tokens {
+		score, ok := scores[tok]
+		switch {
+		case special[int64(id)]:
+			score = 0
+			added = append(added, hfAddedToken{ID: int64(id), Content: tok, Special: true})
+		case !ok:
+			score = targetOnlyScore
+		}
+		vocab[id] = []any{tok, score}
+	}
+
+	prepend := "never"
+	if sp.Normalizer.AddDummyPrefix {
+		prepend = "always"
+	}
+	metaspace := hfMetaspace{Type: "Metaspace", Replacement: "▁", PrependScheme: prepend, Split: true}
+
+	var norms []any
+	if len(sp.Normalizer.PrecompiledCharsmap) > 0 {
+		norms = append(norms, hfPrecompiled{Type: "Precompiled", PrecompiledCharsmap: sp.Normalizer.PrecompiledCharsmap})
+	}
+	if sp.Normalizer.RemoveExtraWhitespaces {
+		norms = append(norms,
+			hfStrip{Type: "Strip", StripLeft: true, StripRight: true},
+			hfReplace{Type: "Replace", Pattern: map[string]string{"Regex": " {2,}"}, Content: " "})
+	}
+	var normalizer any
+	if len(norms) > 0 {
+		normalizer = hfNormalizerSequence{Type: "Sequence", Normalizers: norms}
+	}
+
+	seq := func(id string) map[string]any {
+		return map[string]any{"Sequence": map[string]any{"id": id, "type_id": 0}}
+	}
+	eosTok := map[string]any{"SpecialToken": map[string]any{"id": eos, "type_id": 0}}
+
+	var truncation *hfTruncation
+	if cfg.ModelMaxLength > 0 {
+		truncation = &hfTruncation{Direction: "Right", MaxLength: cfg.ModelMaxLength, Strategy: "LongestFirst"}
+	}
+
+	doc := hfTokenizer{
+		Version:      "1.0",
+		Truncation:   truncation,
+		AddedTokens:  added,
+		Normalizer:   normalizer,
+		PreTokenizer: metaspace,
+		PostProcessor: hfTemplate{
+			Type:   "TemplateProcessing",
+			Single: []map[string]any{seq("A"), eosTok},
+			Pair:   []map[string]any{seq("A"), seq("B"), eosTok},
+			SpecialTokens: map[string]hfSpecialTokens{
+				eos: {ID: eos, IDs: []int64{cfg.EosTokenID}, Tokens: []string{eos}},
+			},
+		},
+		Decoder: metaspace,
+		Model: hfUnigramModel{
+			Type:         "Unigram",
+			UnkID:        unkID,
+			Vocab:        vocab,
+			ByteFallback: sp.Trainer.ByteFallback,
+		},
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// WriteHFTokenizer writes the HFTokenizer document for m to w.
+func WriteHFTokenizer(w io.Writer, m *marian.Model) error {
+	b, err := HFTokenizer(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// HFTokenizerFile writes dir/tokenizer.json for m and returns its path.
+func HFTokenizerFile(m *marian.Model, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "tokenizer.json")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	err = WriteHFTokenizer(f, m)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return path, nil
+}
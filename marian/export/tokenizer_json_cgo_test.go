@@ -0,0 +1,79 @@
+//go:build linux && amd64 && cgo
+
+package export_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/conformance"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v1"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v2"
+)
+
+var referenceBackends = map[string]func(string, ...marian.Option) (marian.Tokenizer, error){
+	"v1": marian_v1.NewTokenizer,
+	"v2": marian_v2.NewTokenizer,
+}
+
+// TestHFTokenizerReencodes checks that v4 encodes a corpus with the
+// exported tokenizer.json exactly like the backends reading the spm files.
+func TestHFTokenizerReencodes(t *testing.T) {
+	m, dir := exportModel(t)
+	v4 := openV4(t, dir)
+
+	corpus := append(slices.Clone(conformance.Corpus),
+		"hallo welt",
+		"Hallo, welt! hallowelt test testtest",
+		"hello world, hallo welt",
+	)
+	for name, open := range referenceBackends {
+		t.Run(name, func(t *testing.T) {
+			ref, err := open(m.Dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ref.Close()
+
+			for _, text := range corpus {
+				for _, addEOS := range []bool{true, false} {
+					want, err := ref.Encode(text, addEOS)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := v4.Encode(text, addEOS)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !slices.Equal(got, want) {
+						t.Errorf("Encode(%q, %v) = %v, want %v", text, addEOS, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// The backends cut long inputs after appending EOS, which the exported
+// truncation can't express (see HFTokenizer).
+func TestHFTokenizerLongInputBackends(t *testing.T) {
+	m, dir := exportModel(t)
+	pieces := untruncated(t, dir, longInput)
+	want := pieces[:64]
+
+	for name, open := range referenceBackends {
+		ref, err := open(m.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ref.Encode(longInput, true)
+		ref.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: Encode(long input) = %v, want the first 64 pieces %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package export_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/export"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/testmodel"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v4"
+)
+
+// targetWords are whole words of target.spm that source.spm spells out.
+var targetWords = []string{"hallo", "welt"}
+
+// exportModel generates a model whose target.spm has targetWords and
+// exports its tokenizer.json, with a copy of config.json, into a new
+// directory, which it returns.
+func exportModel(t *testing.T) (*testmodel.Model, string) {
+	t.Helper()
+	m := testmodel.TempDir(t, testmodel.Options{
+		MaxLength:      64,
+		TargetAlphabet: testmodel.LatinAlphabet,
+		TargetWords:    targetWords,
+	})
+	model, err := marian.LoadModel(m.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if _, err := export.HFTokenizerFile(model, dir); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := os.ReadFile(filepath.Join(m.Dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), cfg, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return m, dir
+}
+
+func openV4(t *testing.T, dir string, opts ...marian.Option) marian.Tokenizer {
+	t.Helper()
+	tok, err := marian_v4.NewTokenizer(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tok.Close)
+	return tok
+}
+
+func TestHFTokenizerTruncation(t *testing.T) {
+	_, dir := exportModel(t)
+	b, err := os.ReadFile(filepath.Join(dir, "tokenizer.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Truncation map[string]any `json:"truncation"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"direction": "Right", "max_length": 64.0, "strategy": "LongestFirst", "stride": 0.0}
+	for k, v := range want {
+		if doc.Truncation[k] != v {
+			t.Errorf("truncation.%s = %v, want %v", k, doc.Truncation[k], v)
+		}
+	}
+}
+
+// Target-only pieces must lose against spelling their text in source pieces.
+func TestHFTokenizerTargetOnlyPieces(t *testing.T) {
+	m, dir := exportModel(t)
+	tok := openV4(t, dir)
+
+	for _, word := range targetWords {
+		if _, ok := m.Vocab["▁"+word]; !ok {
+			t.Fatalf("vocabulary lacks target-only piece ▁%s", word)
+		}
+		want := []int64{m.Vocab["▁"+word[:1]]}
+		for _, r := range word[1:] {
+			want = append(want, m.Vocab[string(r)])
+		}
+		got, err := tok.Encode(word, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("Encode(%q) = %v, want source pieces %v", word, got, want)
+		}
+	}
+}
+
+// longInput is far longer than the model_max_length of exportModel.
+var longInput = strings.Repeat("hallo welt hello world ", 40)
+
+// untruncated returns the pieces of text without EOS or truncation.
+func untruncated(t *testing.T, dir, text string) []int64 {
+	t.Helper()
+	ids, err := openV4(t, dir, marian.WithModelMaxLength(1<<20)).Encode(text, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) <= 64 {
+		t.Fatalf("%d pieces, want more than model_max_length", len(ids))
+	}
+	return ids
+}
+
+// v4 applies the truncation block like tokenizers: the pieces are cut to
+// leave room for </s>.
+func TestHFTokenizerLongInput(t *testing.T) {
+	m, dir := exportModel(t)
+	pieces := untruncated(t, dir, longInput)
+	tok := openV4(t, dir)
+
+	got, err := tok.Encode(longInput, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(slices.Clone(pieces[:63]), m.EosID); !slices.Equal(got, want) {
+		t.Errorf("Encode(long, true) = %v, want %v", got, want)
+	}
+	if got, err = tok.Encode(longInput, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := pieces[:64]; !slices.Equal(got, want) {
+		t.Errorf("Encode(long, false) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+// Package export writes the vocabulary of a Marian model in the formats of
+// other runtimes, so their tokenizer files are derived mechanically from the
+// same model directory this module reads and the ids always match.
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Tokens returns the tokens of v in id order. Every id must be used, since
+// the list formats identify tokens by position.
+func Tokens(v *marian.Vocab) ([]string, error) {
+	tokens := make([]string, v.Size())
+	for id, tok := range v.ID2Token {
+		if tok == "" {
+			if real, ok := v.ID(""); !ok || real != int64(id) {
+				return nil, fmt.Errorf("export: vocabulary has no token for id %d", id)
+			}
+		}
+		tokens[id] = tok
+	}
+	return tokens, nil
+}
+
+// WriteVocabYAML writes v as a Marian-NMT vocab.yml: one "token: id" line
+// per token, in id order. Tokens YAML could misread are double-quoted.
+func WriteVocabYAML(w io.Writer, v *marian.Vocab) error {
+	tokens, err := Tokens(v)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for id, tok := range tokens {
+		fmt.Fprintf(bw, "%s: %d\n", yamlKey(tok), id)
+	}
+	return bw.Flush()
+}
+
+// WriteCTranslate2Vocab writes v as a CTranslate2 vocabulary file: a JSON
+// array of the tokens in id order.
+func WriteCTranslate2Vocab(w io.Writer, v *marian.Vocab) error {
+	tokens, err := Tokens(v)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tokens)
+}
+
+// MarianVocab writes the vocabularies of m into dir in the Marian-NMT
+// layout: vocab.yml for a shared vocabulary, otherwise source.vocab.yml and
+// target.vocab.yml. It returns the written paths.
+func MarianVocab(m *marian.Model, dir string) ([]string, error) {
+	if shared(m) {
+		return writeFiles(dir, WriteVocabYAML, file{"vocab.yml", m.SourceVocab})
+	}
+	return writeFiles(dir, WriteVocabYAML,
+		file{"source.vocab.yml", m.SourceVocab},
+		file{"target.vocab.yml", m.TargetVocab})
+}
+
+// CTranslate2Vocab writes the vocabularies of m into dir the way the
+// CTranslate2 converters do: shared_vocabulary.json for a shared
+// vocabulary, otherwise source_vocabulary.json and target_vocabulary.json.
+// It returns the written paths.
+func CTranslate2Vocab(m *marian.Model, dir string) ([]string, error) {
+	if shared(m) {
+		return writeFiles(dir, WriteCTranslate2Vocab, file{"shared_vocabulary.json", m.SourceVocab})
+	}
+	return writeFiles(dir, WriteCTranslate2Vocab,
+		file{"source_vocabulary.json", m.SourceVocab},
+		file{"target_vocabulary.json", m.TargetVocab})
+}
+
+// shared reports whether source and target use the same vocabulary.
+func shared(m *marian.Model) bool {
+	return m.SourceVocab == m.TargetVocab || slices.Equal(m.SourceVocab.ID2Token, m.TargetVocab.ID2Token)
+}
+
+type file struct {
+	name  string
+	vocab *marian.Vocab
+}
+
+func writeFiles(dir string, write func(io.Writer, *marian.Vocab) error, files ...file) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		out, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		err = write(out, f.vocab)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// yamlKey returns tok as a YAML mapping key: plain when it only holds
+// letters, digits, marks, "▁" and a few inner punctuation characters and
+// can't be read as another type, double-quoted otherwise.
+func yamlKey(tok string) string {
+	if plainYAML(tok) {
+		return tok
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range tok {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case !unicode.IsPrint(r) && r != ' ':
+			if r > 0xffff {
+				fmt.Fprintf(&b, `\U%08x`, r)
+			} else {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func plainYAML(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	for i, r := range tok {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '▁' || r == '_':
+		case i > 0 && (r == '-' || r == '.' || r == '\''):
+		default:
+			return false
+		}
+	}
+	switch strings.ToLower(tok) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null":
+		return false
+	}
+	if _, err := strconv.ParseFloat(tok, 64); err == nil {
+		return false
+	}
+	if _, err := strconv.ParseInt(tok, 0, 64); err == nil {
+		return false
+	}
+	return true
+}
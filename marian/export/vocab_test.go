@@ -0,0 +1,129 @@
+package export_test
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/export"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/testmodel"
+)
+
+// trickyTokens need quoting or escaping in vocab.yml.
+var trickyTokens = []string{
+	"</s>", "<unk>", "<pad>", "▁", "▁a", "a-b", "it's", "", " ", "#", "#x", "a #b", "a: b", ":", "-", "- x",
+	"'", `"`, `\`, "\t", "\n", "\x00", "\x7f", "­", "\U000e0001", "[", "{", "]", "&a", "*a", "!x", "%", "@",
+	"yes", "No", "null", "~", "1", "-2", "0x1f", "1e5", ".5", "true", "нет", "日本",
+}
+
+func newVocab(t *testing.T, tokens []string) *marian.Vocab {
+	t.Helper()
+	m := make(map[string]int64, len(tokens))
+	for id, tok := range tokens {
+		m[tok] = int64(id)
+	}
+	v, err := marian.NewVocab(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// loadExported writes decoder.yml for the exported vocabularies at paths
+// and loads dir as a Marian-NMT model.
+func loadExported(t *testing.T, dir string, paths []string) *marian.Model {
+	t.Helper()
+	vocabs := []string{filepath.Base(paths[0]), filepath.Base(paths[len(paths)-1])}
+	decoder := "vocabs:\n  - " + vocabs[0] + "\n  - " + vocabs[1] + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "decoder.yml"), []byte(decoder), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := marian.LoadModel(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func checkVocab(t *testing.T, name string, got, want *marian.Vocab) {
+	t.Helper()
+	if !maps.Equal(got.Token2ID, want.Token2ID) || !slices.Equal(got.ID2Token, want.ID2Token) {
+		t.Errorf("%s: reloaded %q, want %q", name, got.ID2Token, want.ID2Token)
+	}
+}
+
+func TestMarianVocabRoundTrip(t *testing.T) {
+	m := testmodel.TempDir(t, testmodel.Options{MaxLength: 64})
+	model, err := marian.LoadModel(m.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	paths, err := export.MarianVocab(model, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || filepath.Base(paths[0]) != "vocab.yml" {
+		t.Fatalf("shared vocabulary written as %v", paths)
+	}
+	reloaded := loadExported(t, dir, paths)
+	checkVocab(t, "vocab.yml", reloaded.SourceVocab, model.SourceVocab)
+	if reloaded.Config.PadTokenID != model.Config.PadTokenID || reloaded.Config.EosTokenID != model.Config.EosTokenID {
+		t.Errorf("reloaded special ids %+v, want %+v", reloaded.Config, model.Config)
+	}
+}
+
+func TestMarianVocabRoundTripTricky(t *testing.T) {
+	target := slices.Concat(trickyTokens[:3], []string{"▁b", "c: d"})
+	model := &marian.Model{SourceVocab: newVocab(t, trickyTokens), TargetVocab: newVocab(t, target)}
+
+	dir := t.TempDir()
+	paths, err := export.MarianVocab(model, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("separate vocabularies written as %v", paths)
+	}
+	reloaded := loadExported(t, dir, paths)
+	checkVocab(t, "source.vocab.yml", reloaded.SourceVocab, model.SourceVocab)
+	checkVocab(t, "target.vocab.yml", reloaded.TargetVocab, model.TargetVocab)
+}
+
+func TestCTranslate2VocabRoundTrip(t *testing.T) {
+	model := &marian.Model{SourceVocab: newVocab(t, trickyTokens)}
+	model.TargetVocab = model.SourceVocab
+
+	paths, err := export.CTranslate2Vocab(model, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || filepath.Base(paths[0]) != "shared_vocabulary.json" {
+		t.Fatalf("shared vocabulary written as %v", paths)
+	}
+	b, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []string
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tokens, trickyTokens) {
+		t.Errorf("reloaded %q, want %q", tokens, trickyTokens)
+	}
+}
+
+func TestTokensGap(t *testing.T) {
+	v, err := marian.NewVocab(map[string]int64{"a": 0, "c": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens, err := export.Tokens(v); err == nil {
+		t.Errorf("Tokens of a vocabulary without id 1 = %q, want an error", tokens)
+	}
+}
@@ -0,0 +1,60 @@
+package marian
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInputTooLarge is returned (wrapped) by the Guard middleware when a call
+// exceeds one of its Limits.
+var ErrInputTooLarge = errors.New("marian: input too large")
+
+// Limits bounds the size of tokenizer inputs. Zero fields are not checked.
+type Limits struct {
+	// MaxTextBytes is the maximum size of a single text passed to Encode or
+	// EncodeBatch.
+	MaxTextBytes int
+	// MaxBatchSize is the maximum number of texts passed to EncodeBatch.
+	MaxBatchSize int
+	// MaxIDs is the maximum number of ids passed to Decode.
+	MaxIDs int
+}
+
+// Guard returns a middleware that rejects inputs exceeding limits with an
+// error wrapping ErrInputTooLarge, before they reach the next Tokenizer.
+func Guard(limits Limits) Middleware {
+	checkText := func(text string) error {
+		if limits.MaxTextBytes > 0 && len(text) > limits.MaxTextBytes {
+			return fmt.Errorf("%w: text of %d bytes exceeds %d", ErrInputTooLarge, len(text), limits.MaxTextBytes)
+		}
+		return nil
+	}
+
+	return func(next Tokenizer) Tokenizer {
+		return Override(next, Funcs{
+			Encode: func(text string, addEOS bool) ([]int64, error) {
+				if err := checkText(text); err != nil {
+					return nil, err
+				}
+				return next.Encode(text, addEOS)
+			},
+			EncodeBatch: func(texts []string) ([][]int64, [][]int64, error) {
+				if limits.MaxBatchSize > 0 && len(texts) > limits.MaxBatchSize {
+					return nil, nil, fmt.Errorf("%w: batch of %d exceeds %d", ErrInputTooLarge, len(texts), limits.MaxBatchSize)
+				}
+				for i, text := range texts {
+					if err := checkText(text); err != nil {
+						return nil, nil, fmt.Errorf("texts[%d]: %w", i, err)
+					}
+				}
+				return next.EncodeBatch(texts)
+			},
+			Decode: func(ids []int64, skipSpecial bool) (string, error) {
+				if limits.MaxIDs > 0 && len(ids) > limits.MaxIDs {
+					return "", fmt.Errorf("%w: %d ids exceed %d", ErrInputTooLarge, len(ids), limits.MaxIDs)
+				}
+				return next.Decode(ids, skipSpecial)
+			},
+		})
+	}
+}
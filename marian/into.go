@@ -0,0 +1,141 @@
+package marian
+
+import "fmt"
+
+// IntoEncoder is implemented by tokenizers that can encode into a
+// caller-provided buffer.
+type IntoEncoder interface {
+	// EncodeInto is Encode that appends the ids to dst and returns the
+	// extended slice, like append. When dst has room for the result, the
+	// call does not allocate on the Go heap.
+	EncodeInto(dst []int64, text string, addEOS bool) ([]int64, error)
+}
+
+// BatchIntoEncoder is implemented by tokenizers that can batch-encode into
+// caller-provided flat buffers. See EncodeBatchInto for the layout.
+type BatchIntoEncoder interface {
+	EncodeBatchInto(ids, mask []int64, texts []string) (width int, err error)
+}
+
+// EncodeInto appends the ids of text to dst, without intermediate
+// allocations when tok implements IntoEncoder. Otherwise, for example when
+// tok is wrapped in middleware, it appends the result of Encode.
+func EncodeInto(tok Tokenizer, dst []int64, text string, addEOS bool) ([]int64, error) {
+	if ie, ok := tok.(IntoEncoder); ok {
+		return ie.EncodeInto(dst, text, addEOS)
+	}
+	ids, err := tok.Encode(text, addEOS)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, ids...), nil
+}
+
+// EncodeBatchInto encodes texts like EncodeBatch, but writes the padded ids
+// and the attention mask row-major into the flat buffers ids and mask, and
+// returns the row width (the longest row). Row i is ids[i*width:(i+1)*width].
+//
+// Because the width is only known afterwards, both buffers must hold at
+// least BatchBufferLen(tok, len(texts)) values; only the first
+// len(texts)*width are written. mask may be nil when it is not needed.
+//
+// Backends implementing BatchIntoEncoder write straight into the buffers;
+// IntoEncoder backends are called row by row; others go through
+// EncodeBatch and are copied.
+func EncodeBatchInto(tok Tokenizer, ids, mask []int64, texts []string) (int, error) {
+	if be, ok := tok.(BatchIntoEncoder); ok {
+		return be.EncodeBatchInto(ids, mask, texts)
+	}
+	cfg, err := tok.Config()
+	if err != nil {
+		return 0, err
+	}
+	if err := CheckBatchBuffers(ids, mask, len(texts), cfg.ModelMaxLength); err != nil {
+		return 0, err
+	}
+
+	ie, ok := tok.(IntoEncoder)
+	if !ok {
+		rows, attn, err := tok.EncodeBatch(texts)
+		if err != nil {
+			return 0, err
+		}
+		width := 0
+		if len(rows) > 0 {
+			width = len(rows[0])
+		}
+		for i := range rows {
+			copy(ids[i*width:], rows[i])
+			if mask != nil {
+				copy(mask[i*width:], attn[i])
+			}
+		}
+		return width, nil
+	}
+
+	// Encode every row at stride ModelMaxLength, then pack the rows to the
+	// longest one.
+	stride := cfg.ModelMaxLength
+	lens := make([]int, len(texts))
+	width := 0
+	for i, text := range texts {
+		row := ids[i*stride : i*stride : (i+1)*stride]
+		row, err := ie.EncodeInto(row, text, !cfg.NoBatchEOS)
+		if err != nil {
+			return 0, err
+		}
+		lens[i] = len(row)
+		width = max(width, len(row))
+	}
+	PackBatch(ids, mask, lens, stride, width, cfg.PadTokenID)
+	return width, nil
+}
+
+// BatchBufferLen returns the buffer length EncodeBatchInto needs for n
+// texts: n rows of model_max_length values.
+func BatchBufferLen(tok Tokenizer, n int) (int, error) {
+	cfg, err := tok.Config()
+	if err != nil {
+		return 0, err
+	}
+	return n * cfg.ModelMaxLength, nil
+}
+
+// CheckBatchBuffers reports an error when ids, or mask if it is not nil, is
+// too small for n rows of stride values. It is meant for BatchIntoEncoder
+// implementations.
+func CheckBatchBuffers(ids, mask []int64, n, stride int) error {
+	if stride <= 0 {
+		return fmt.Errorf("model_max_length is not positive")
+	}
+	if need := n * stride; len(ids) < need || (mask != nil && len(mask) < need) {
+		return fmt.Errorf("marian: batch buffers hold %d ids and %d mask values, %d texts need %d", len(ids), len(mask), n, need)
+	}
+	return nil
+}
+
+// PackBatch turns rows written at the given stride, row i holding lens[i]
+// ids, into rows of width: it moves them together, pads them with padID and
+// fills mask (if not nil) with 1 for ids and 0 for padding. It is meant for
+// BatchIntoEncoder implementations.
+func PackBatch(ids, mask []int64, lens []int, stride, width int, padID int64) {
+	for i, n := range lens {
+		// Rows only move towards the front, and row i's destination ends
+		// before row i+1's source starts.
+		row := ids[i*width : (i+1)*width]
+		copy(row, ids[i*stride:i*stride+n])
+		for j := n; j < width; j++ {
+			row[j] = padID
+		}
+		if mask != nil {
+			m := mask[i*width : (i+1)*width]
+			for j := range m {
+				if j < n {
+					m[j] = 1
+				} else {
+					m[j] = 0
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package marian
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed is returned by tokenizer calls made after Close.
+var ErrClosed = errors.New("marian: tokenizer closed")
+
+// closedBit marks a Lifecycle closed; the bits below it count the calls in
+// progress.
+const closedBit = 1 << 62
+
+// Lifecycle makes a tokenizer safe to Close while other goroutines use it.
+// Every call is bracketed by Acquire and Release, and Close waits for the
+// calls in progress before the tokenizer frees its native resources:
+//
+//	func (t *Tokenizer) Encode(text string, addEOS bool) ([]int64, error) {
+//		if err := t.life.Acquire(); err != nil {
+//			return nil, err
+//		}
+//		defer t.life.Release()
+//		...
+//	}
+//
+//	func (t *Tokenizer) Close() {
+//		if t.life.Close() {
+//			C.free_handle(t.h)
+//		}
+//	}
+//
+// Acquire never blocks, so a call may acquire again while it holds the
+// Lifecycle. The zero value is ready to use; a Lifecycle must not be copied.
+type Lifecycle struct {
+	state atomic.Int64
+
+	closeMu sync.Mutex
+	done    chan struct{} // closed when the last call returns after Close
+	once    sync.Once
+
+	leak *leakState
+}
+
+// Acquire registers a call in progress, or returns ErrClosed after Close.
+// Each successful Acquire must be paired with a Release.
+func (l *Lifecycle) Acquire() error {
+	if l.state.Add(1)&closedBit != 0 {
+		l.Release()
+		return ErrClosed
+	}
+	return nil
+}
+
+// Release ends a call registered by Acquire.
+func (l *Lifecycle) Release() {
+	if l.state.Add(-1) == closedBit {
+		l.once.Do(func() { close(l.done) })
+	}
+}
+
+// Close marks the Lifecycle closed and waits until all calls in progress
+// have returned. It reports whether this was the first Close: only then
+// should the caller free its resources. Later Closes wait for the first one
+// to finish and return false.
+func (l *Lifecycle) Close() bool {
+	l.closeMu.Lock()
+	defer l.closeMu.Unlock()
+	if l.Closed() {
+		return false
+	}
+	if l.leak != nil {
+		l.leak.closed.Store(true)
+	}
+	// done must exist before the closed bit is visible to Release.
+	l.done = make(chan struct{})
+	if l.state.Add(closedBit) != closedBit {
+		<-l.done
+	}
+	return true
+}
+
+// Closed reports whether Close has been called.
+func (l *Lifecycle) Closed() bool {
+	return l.state.Load()&closedBit != 0
+}
+
+// Leak describes a tokenizer that became unreachable without being closed.
+type Leak struct {
+	// Backend is the backend name the tokenizer was tracked with.
+	Backend string
+	// ModelDir is the model directory the tokenizer was created for.
+	ModelDir string
+	// Stack is the goroutine stack that created the tokenizer.
+	Stack []byte
+}
+
+var leakHandler atomic.Pointer[func(Leak)]
+
+// SetLeakHandler enables leak detection: tokenizers created afterwards that
+// are garbage collected without Close are reported to h, from a runtime
+// goroutine, with the stack that created them. Leaked native resources are
+// reported, not freed. Capturing the stack makes loading slower, so
+// detection is meant for tests and debugging. A nil h disables it.
+func SetLeakHandler(h func(Leak)) {
+	if h == nil {
+		leakHandler.Store(nil)
+		return
+	}
+	leakHandler.Store(&h)
+}
+
+type leakState struct {
+	closed atomic.Bool
+	leak   Leak
+}
+
+// Track registers owner, a new tokenizer closed through l, with the leak
+// handler set by SetLeakHandler, if any. Backends call it once the
+// tokenizer is created.
+func Track[T any](owner *T, l *Lifecycle, backend, modelDir string) {
+	h := leakHandler.Load()
+	if h == nil {
+		return
+	}
+	buf := make([]byte, 4096)
+	buf = buf[:runtime.Stack(buf, false)]
+	l.leak = &leakState{leak: Leak{Backend: backend, ModelDir: modelDir, Stack: buf}}
+	runtime.AddCleanup(owner, func(s *leakState) {
+		if !s.closed.Load() {
+			(*h)(s.leak)
+		}
+	}, l.leak)
+}
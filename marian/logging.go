@@ -0,0 +1,65 @@
+package marian
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Logging returns a middleware that logs every Encode, EncodeBatch, Decode
+// and Close call through logger. Successful calls are logged at level,
+// failed calls at slog.LevelError. Only sizes and durations are logged,
+// never the text itself.
+func Logging(logger *slog.Logger, level slog.Level) Middleware {
+	ctx := context.Background()
+
+	log := func(msg string, start time.Time, err error, attrs ...slog.Attr) {
+		attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+		lvl := level
+		if err != nil {
+			lvl = slog.LevelError
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		logger.LogAttrs(ctx, lvl, msg, attrs...)
+	}
+
+	return func(next Tokenizer) Tokenizer {
+		return Override(next, Funcs{
+			Encode: func(text string, addEOS bool) ([]int64, error) {
+				start := time.Now()
+				ids, err := next.Encode(text, addEOS)
+				log("marian.encode", start, err,
+					slog.Int("text_bytes", len(text)),
+					slog.Bool("add_eos", addEOS),
+					slog.Int("tokens", len(ids)))
+				return ids, err
+			},
+			EncodeBatch: func(texts []string) ([][]int64, [][]int64, error) {
+				start := time.Now()
+				ids, mask, err := next.EncodeBatch(texts)
+				width := 0
+				if len(ids) > 0 {
+					width = len(ids[0])
+				}
+				log("marian.encode_batch", start, err,
+					slog.Int("batch", len(texts)),
+					slog.Int("width", width))
+				return ids, mask, err
+			},
+			Decode: func(ids []int64, skipSpecial bool) (string, error) {
+				start := time.Now()
+				text, err := next.Decode(ids, skipSpecial)
+				log("marian.decode", start, err,
+					slog.Int("tokens", len(ids)),
+					slog.Bool("skip_special", skipSpecial),
+					slog.Int("text_bytes", len(text)))
+				return text, err
+			},
+			Close: func() {
+				start := time.Now()
+				next.Close()
+				log("marian.close", start, nil)
+			},
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package marian
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Metrics returns a middleware that counts calls, errors, items, tokens and
+// latency per method and publishes them as an expvar.Map under name (served
+// on /debug/vars by the expvar package). Middlewares created with the same
+// name share the same counters. If name is already published as another
+// kind of expvar.Var, the counters are kept but not published.
+//
+// For every method (encode, encode_batch, decode) the map holds:
+//
+//	<method>.calls       number of calls
+//	<method>.errors      number of calls that returned an error
+//	<method>.items       sentences encoded / id sequences decoded
+//	<method>.tokens      token ids produced (encode) or consumed (decode)
+//	<method>.latency_ns  total time spent in the call, in nanoseconds
+//
+// Throughput and mean latency are derived by dividing the counters.
+func Metrics(name string) Middleware {
+	m := publishedMap(name)
+
+	return func(next Tokenizer) Tokenizer {
+		return Override(next, Funcs{
+			Encode: func(text string, addEOS bool) ([]int64, error) {
+				start := time.Now()
+				ids, err := next.Encode(text, addEOS)
+				record(m, "encode", start, 1, len(ids), err)
+				return ids, err
+			},
+			EncodeBatch: func(texts []string) ([][]int64, [][]int64, error) {
+				start := time.Now()
+				ids, mask, err := next.EncodeBatch(texts)
+				tokens := 0
+				for _, row := range mask {
+					for _, v := range row {
+						tokens += int(v)
+					}
+				}
+				record(m, "encode_batch", start, len(texts), tokens, err)
+				return ids, mask, err
+			},
+			Decode: func(ids []int64, skipSpecial bool) (string, error) {
+				start := time.Now()
+				text, err := next.Decode(ids, skipSpecial)
+				record(m, "decode", start, 1, len(ids), err)
+				return text, err
+			},
+		})
+	}
+}
+
+// metricsMu serializes the lookup and creation of published maps, which
+// expvar does not do as one step.
+var metricsMu sync.Mutex
+
+// publishedMap returns the expvar.Map published under name, creating it on
+// first use. A name taken by another kind of variable gets a private map.
+func publishedMap(name string) *expvar.Map {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		return expvar.NewMap(name)
+	case *expvar.Map:
+		return v
+	default:
+		return new(expvar.Map)
+	}
+}
+
+func record(m *expvar.Map, method string, start time.Time, items, tokens int, err error) {
+	m.Add(method+".latency_ns", int64(time.Since(start)))
+	m.Add(method+".calls", 1)
+	if err != nil {
+		m.Add(method+".errors", 1)
+		return
+	}
+	m.Add(method+".items", int64(items))
+	m.Add(method+".tokens", int64(tokens))
+}
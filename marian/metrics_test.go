@@ -0,0 +1,40 @@
+package marian
+
+import (
+	"expvar"
+	"sync"
+	"testing"
+)
+
+func TestMetricsSharesPublishedMap(t *testing.T) {
+	const name = "test_metrics_shared"
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			tok := Wrap(&fakeTokenizer{}, Metrics(name))
+			tok.Encode("a", true)
+		})
+	}
+	wg.Wait()
+
+	m, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		t.Fatalf("expvar %q is %T, want *expvar.Map", name, expvar.Get(name))
+	}
+	if got := m.Get("encode.calls").String(); got != "8" {
+		t.Errorf("encode.calls = %s, want 8", got)
+	}
+}
+
+func TestMetricsNameCollision(t *testing.T) {
+	const name = "test_metrics_collision"
+	expvar.NewInt(name)
+
+	tok := Wrap(&fakeTokenizer{}, Metrics(name))
+	if _, err := tok.Encode("a", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := expvar.Get(name).(*expvar.Int); !ok {
+		t.Errorf("expvar %q was replaced by %T", name, expvar.Get(name))
+	}
+}
@@ -0,0 +1,102 @@
+package marian
+
+// Middleware layers cross-cutting behaviour (metrics, logging, guards, ...)
+// onto a Tokenizer. It receives the next Tokenizer in the chain and returns
+// the Tokenizer that callers will use.
+type Middleware func(next Tokenizer) Tokenizer
+
+// Wrap applies mws to tok. The first middleware is the outermost one, so
+//
+//	Wrap(tok, Logging(l), Metrics("marian"))
+//
+// logs every call before it reaches the metrics layer and then tok.
+func Wrap(tok Tokenizer, mws ...Middleware) Tokenizer {
+	for i := len(mws) - 1; i >= 0; i-- {
+		tok = mws[i](tok)
+	}
+	return tok
+}
+
+// Funcs holds replacements for some of the Tokenizer methods. Nil fields
+// forward to the next Tokenizer.
+type Funcs struct {
+	Encode      func(text string, addEOS bool) ([]int64, error)
+	EncodeBatch func(texts []string) ([][]int64, [][]int64, error)
+	Decode      func(ids []int64, skipSpecial bool) (string, error)
+	Config      func() (*Config, error)
+	Close       func()
+}
+
+// Override returns a Tokenizer that calls the non-nil fields of f and
+// forwards every other method to next. It is the building block for
+// middlewares that only care about some of the methods:
+//
+//	func Upper() marian.Middleware {
+//		return func(next marian.Tokenizer) marian.Tokenizer {
+//			return marian.Override(next, marian.Funcs{
+//				Encode: func(text string, addEOS bool) ([]int64, error) {
+//					return next.Encode(strings.ToUpper(text), addEOS)
+//				},
+//			})
+//		}
+//	}
+func Override(next Tokenizer, f Funcs) Tokenizer {
+	return &overridden{next: next, f: f}
+}
+
+// Unwrap returns the Tokenizer wrapped by a middleware created with
+// Override, or nil if tok is not such a wrapper.
+func Unwrap(tok Tokenizer) Tokenizer {
+	if u, ok := tok.(interface{ Unwrap() Tokenizer }); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
+
+type overridden struct {
+	next Tokenizer
+	f    Funcs
+}
+
+// ensure interface implementation
+var _ Tokenizer = (*overridden)(nil)
+
+func (o *overridden) Unwrap() Tokenizer {
+	return o.next
+}
+
+func (o *overridden) Encode(text string, addEOS bool) ([]int64, error) {
+	if o.f.Encode != nil {
+		return o.f.Encode(text, addEOS)
+	}
+	return o.next.Encode(text, addEOS)
+}
+
+func (o *overridden) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	if o.f.EncodeBatch != nil {
+		return o.f.EncodeBatch(texts)
+	}
+	return o.next.EncodeBatch(texts)
+}
+
+func (o *overridden) Decode(ids []int64, skipSpecial bool) (string, error) {
+	if o.f.Decode != nil {
+		return o.f.Decode(ids, skipSpecial)
+	}
+	return o.next.Decode(ids, skipSpecial)
+}
+
+func (o *overridden) Config() (*Config, error) {
+	if o.f.Config != nil {
+		return o.f.Config()
+	}
+	return o.next.Config()
+}
+
+func (o *overridden) Close() {
+	if o.f.Close != nil {
+		o.f.Close()
+		return
+	}
+	o.next.Close()
+}
@@ -0,0 +1,341 @@
+package marian
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Layout lists the files of a model directory.
+//
+// Two layouts are recognized:
+//
+//   - HuggingFace conversions: config.json, vocab.json, source.spm and
+//     target.spm.
+//   - Marian-NMT models: decoder.yml, whose "vocabs" list names the source
+//     and target vocabularies (*.vocab.yml, or *.spm files used directly as
+//     vocabularies), plus source.spm and target.spm when the vocabularies
+//     are not SentencePiece models themselves.
+//
+// In both, a directory without source.spm and target.spm may hold a single
+// SentencePiece model shared by both sides, such as spm.model or
+// opus.spm32k. Any file can also be named explicitly, see Files.
+type Layout struct {
+	Dir string
+	// Native is true for the Marian-NMT layout.
+	Native bool
+	// Config is config.json, or decoder.yml for native models.
+	Config string
+
+	SourceSPM   string
+	TargetSPM   string
+	SourceVocab string
+	TargetVocab string
+}
+
+// Files names model files explicitly, overriding the names ResolveLayout
+// would look for. Empty fields keep the default; relative paths are relative
+// to the model directory. Vocab and SPM set both sides, and the per-side
+// fields take precedence over them. A Config ending in .yml or .yaml selects
+// the Marian-NMT layout.
+type Files struct {
+	Config      string
+	Vocab       string
+	SourceVocab string
+	TargetVocab string
+	SPM         string
+	SourceSPM   string
+	TargetSPM   string
+}
+
+// FindLayout inspects dir and returns the paths of its model files.
+func FindLayout(dir string) (*Layout, error) {
+	return ResolveLayout(dir, Files{})
+}
+
+// ModelFiles returns the files a tokenizer for dir reads, sorted: those of
+// its layout (see ResolveLayout) or, for a directory with tokenizer.json
+// but no source SentencePiece model, tokenizer.json and the configs read
+// with it. Some of the files may not exist.
+func ModelFiles(dir string, f Files) ([]string, error) {
+	l, err := ResolveLayout(dir, f)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if l.TokenizerJSONOnly() {
+		files = []string{filepath.Join(l.Dir, "tokenizer.json"), l.Config, filepath.Join(l.Dir, "tokenizer_config.json")}
+	} else {
+		files = []string{l.Config, l.SourceSPM, l.TargetSPM, l.SourceVocab, l.TargetVocab}
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
+
+// TokenizerJSONOnly reports whether the directory has a HuggingFace
+// tokenizer.json but no source SentencePiece model: a layout only the v4
+// backend reads.
+func (l *Layout) TokenizerJSONOnly() bool {
+	return !exists(l.SourceSPM) && exists(filepath.Join(l.Dir, "tokenizer.json"))
+}
+
+// ResolveLayout is FindLayout with the file names in f taking precedence.
+func ResolveLayout(dir string, f Files) (*Layout, error) {
+	dir = filepath.Clean(dir)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	or := func(paths ...string) string {
+		for _, p := range paths {
+			if p != "" {
+				return resolve(p)
+			}
+		}
+		return ""
+	}
+
+	l := &Layout{Dir: dir}
+	switch {
+	case f.Config != "":
+		l.Config = resolve(f.Config)
+		l.Native = isYAML(l.Config)
+	case exists(filepath.Join(dir, "config.json")) || !exists(filepath.Join(dir, "decoder.yml")):
+		l.Config = filepath.Join(dir, "config.json")
+	default:
+		l.Config = filepath.Join(dir, "decoder.yml")
+		l.Native = true
+	}
+
+	if !l.Native {
+		l.SourceVocab = or(f.SourceVocab, f.Vocab, "vocab.json")
+		l.TargetVocab = or(f.TargetVocab, f.Vocab, "vocab.json")
+	} else {
+		var vocabs []string
+		if f.SourceVocab == "" && f.Vocab == "" || f.TargetVocab == "" && f.Vocab == "" {
+			doc, err := readDecoderYAML(l.Config)
+			if err != nil {
+				return nil, err
+			}
+			vocabs = doc["vocabs"]
+			if len(vocabs) < 2 {
+				return nil, fmt.Errorf("marian: %s: expected source and target in vocabs, got %d entries", l.Config, len(vocabs))
+			}
+		}
+		// Multi-source models list several source vocabularies; the target is
+		// always last.
+		if l.SourceVocab = or(f.SourceVocab, f.Vocab); l.SourceVocab == "" {
+			l.SourceVocab = resolve(vocabs[0])
+		}
+		if l.TargetVocab = or(f.TargetVocab, f.Vocab); l.TargetVocab == "" {
+			l.TargetVocab = resolve(vocabs[len(vocabs)-1])
+		}
+	}
+
+	l.SourceSPM = or(f.SourceSPM, f.SPM)
+	if l.SourceSPM == "" && l.Native && isSPM(l.SourceVocab) {
+		l.SourceSPM = l.SourceVocab
+	}
+	l.TargetSPM = or(f.TargetSPM, f.SPM)
+	if l.TargetSPM == "" && l.Native && isSPM(l.TargetVocab) {
+		l.TargetSPM = l.TargetVocab
+	}
+	if l.SourceSPM != "" && l.TargetSPM != "" {
+		return l, nil
+	}
+
+	// Default names, or the directory's only SentencePiece model when
+	// neither source.spm nor target.spm exists.
+	src, tgt := filepath.Join(dir, "source.spm"), filepath.Join(dir, "target.spm")
+	if !exists(src) && !exists(tgt) {
+		shared, err := findSharedSPM(dir)
+		if err != nil {
+			return nil, err
+		}
+		if shared != "" {
+			src, tgt = shared, shared
+		}
+	}
+	if l.SourceSPM == "" {
+		l.SourceSPM = src
+	}
+	if l.TargetSPM == "" {
+		l.TargetSPM = tgt
+	}
+	return l, nil
+}
+
+// spmName matches the names of SentencePiece models: *.spm, *.model and
+// OPUS-style *.spm32k.
+var spmName = regexp.MustCompile(`(?i)\.(spm\d*k?|model)$`)
+
+// findSharedSPM returns the only SentencePiece model in dir, "" when there
+// is none, and an error when there are several.
+func findSharedSPM(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil // reported when the model files are opened
+	}
+	var found []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && spmName.MatchString(e.Name()) {
+			found = append(found, e.Name())
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return filepath.Join(dir, found[0]), nil
+	}
+	return "", fmt.Errorf("marian: %s: no source.spm and target.spm, and several SentencePiece models to share (%s); name them with marian.WithFiles",
+		dir, strings.Join(found, ", "))
+}
+
+// Model is a loaded model directory: its layout, configuration and
+// vocabularies. SourceVocab and TargetVocab are the same *Vocab when the
+// model has a shared vocabulary.
+type Model struct {
+	Layout      *Layout
+	Config      Config
+	SourceVocab *Vocab
+	TargetVocab *Vocab
+}
+
+// LoadModel loads the configuration and vocabularies of a model directory
+// in either layout (see Layout). Of opts, only WithFiles applies.
+//
+// For native models the configuration is derived the way the HuggingFace
+// converter does it: </s> and <unk> keep their vocabulary ids (0 and 1 by
+// Marian convention), <pad> is appended to the vocabulary when missing and
+// also serves as decoder start token, and the maximum length is
+// decoder.yml's max-length (512 when unset).
+func LoadModel(dir string, opts ...Option) (*Model, error) {
+	l, err := ResolveLayout(dir, ApplyOptions(opts...).Files)
+	if err != nil {
+		return nil, err
+	}
+	if !l.Native {
+		return loadHFModel(l)
+	}
+	return loadNativeModel(l)
+}
+
+func loadHFModel(l *Layout) (*Model, error) {
+	var cfg Config
+	b, err := os.ReadFile(l.Config)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	cfg.NormalizeConfig()
+
+	src, err := LoadVocab(l.SourceVocab)
+	if err != nil {
+		return nil, fmt.Errorf("load vocab: %w", err)
+	}
+	tgt := src
+	if l.TargetVocab != l.SourceVocab {
+		if tgt, err = LoadVocab(l.TargetVocab); err != nil {
+			return nil, fmt.Errorf("load target vocab: %w", err)
+		}
+	}
+	return &Model{Layout: l, Config: cfg, SourceVocab: src, TargetVocab: tgt}, nil
+}
+
+func loadNativeModel(l *Layout) (*Model, error) {
+	doc, err := readDecoderYAML(l.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := loadAnyVocab(l.SourceVocab)
+	if err != nil {
+		return nil, fmt.Errorf("load source vocab: %w", err)
+	}
+	tgt := src
+	if l.TargetVocab != l.SourceVocab {
+		if tgt, err = loadAnyVocab(l.TargetVocab); err != nil {
+			return nil, fmt.Errorf("load target vocab: %w", err)
+		}
+	}
+
+	maxLen := 512
+	if v := doc["max-length"]; len(v) == 1 {
+		if maxLen, err = strconv.Atoi(v[0]); err != nil || maxLen <= 0 {
+			return nil, fmt.Errorf("marian: %s: invalid max-length %q", l.Config, v[0])
+		}
+	}
+
+	eos := idOr(src, "</s>", 0)
+	srcPad := src.add("<pad>")
+	tgtPad := tgt.add("<pad>")
+
+	cfg := Config{
+		VocabSize:           src.Size(),
+		DecoderVocabSize:    tgt.Size(),
+		EosTokenID:          eos,
+		BosTokenID:          eos,
+		PadTokenID:          srcPad,
+		DecoderStartTokenID: tgtPad,
+		MaxLength:           maxLen,
+		ModelMaxLength:      maxLen,
+		BadWordsIDs:         [][]int{{int(tgtPad)}},
+	}
+	return &Model{Layout: l, Config: cfg, SourceVocab: src, TargetVocab: tgt}, nil
+}
+
+// loadAnyVocab loads a vocabulary by file type: vocab.json, *.yml or *.spm.
+func loadAnyVocab(path string) (*Vocab, error) {
+	switch {
+	case isSPM(path):
+		return LoadVocabSPM(path)
+	case isYAML(path):
+		return LoadVocabYAML(path)
+	case strings.HasSuffix(path, ".json"):
+		return LoadVocab(path)
+	}
+	return nil, fmt.Errorf("marian: %s: unknown vocabulary format", path)
+}
+
+func readDecoderYAML(path string) (map[string][]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseDecoderYAML(b)
+	if err != nil {
+		return nil, fmt.Errorf("marian: %s: %w", path, err)
+	}
+	return doc, nil
+}
+
+func idOr(v *Vocab, token string, def int64) int64 {
+	if id, ok := v.ID(token); ok {
+		return id
+	}
+	return def
+}
+
+func isSPM(path string) bool {
+	return spmName.MatchString(path)
+}
+
+func isYAML(path string) bool {
+	return strings.HasSuffix(path, ".yml") || strings.HasSuffix(path, ".yaml")
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,154 @@
+package marian
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// modelDir creates a directory with the given files.
+func modelDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestResolveLayoutNative(t *testing.T) {
+	abs := filepath.Join(t.TempDir(), "abs.spm")
+	tests := []struct {
+		name  string
+		files map[string]string
+		f     Files
+		want  Layout // paths relative to the directory, except abs
+	}{
+		{"vocab.yml", map[string]string{
+			"decoder.yml": "vocabs:\n  - vocab.src.yml\n  - vocab.trg.yml\n",
+			"source.spm":  "", "target.spm": "",
+		}, Files{}, Layout{Native: true, Config: "decoder.yml",
+			SourceVocab: "vocab.src.yml", TargetVocab: "vocab.trg.yml", SourceSPM: "source.spm", TargetSPM: "target.spm"}},
+		// Multi-source models: the first source and the last (target) entry.
+		{"multi-source", map[string]string{
+			"decoder.yml": "vocabs: [ctx.yml, src.yml, trg.yml]\n",
+		}, Files{}, Layout{Native: true, Config: "decoder.yml",
+			SourceVocab: "ctx.yml", TargetVocab: "trg.yml", SourceSPM: "source.spm", TargetSPM: "target.spm"}},
+		{"spm vocabs", map[string]string{
+			"decoder.yml": "vocabs:\n  - src.spm\n  - trg.spm\n",
+		}, Files{}, Layout{Native: true, Config: "decoder.yml",
+			SourceVocab: "src.spm", TargetVocab: "trg.spm", SourceSPM: "src.spm", TargetSPM: "trg.spm"}},
+		{"shared spm", map[string]string{
+			"decoder.yml": "vocabs: [vocab.yml, vocab.yml]\n", "opus.spm32k": "",
+		}, Files{}, Layout{Native: true, Config: "decoder.yml",
+			SourceVocab: "vocab.yml", TargetVocab: "vocab.yml", SourceSPM: "opus.spm32k", TargetSPM: "opus.spm32k"}},
+		// config.json wins over decoder.yml.
+		{"config.json", map[string]string{
+			"config.json": "{}", "decoder.yml": "vocabs: [a.yml, b.yml]\n",
+		}, Files{}, Layout{Config: "config.json",
+			SourceVocab: "vocab.json", TargetVocab: "vocab.json", SourceSPM: "source.spm", TargetSPM: "target.spm"}},
+		{"config override", map[string]string{
+			"config.json": "{}", "model.yaml": "vocabs: [a.yml, b.yml]\n",
+		}, Files{Config: "model.yaml"}, Layout{Native: true, Config: "model.yaml",
+			SourceVocab: "a.yml", TargetVocab: "b.yml", SourceSPM: "source.spm", TargetSPM: "target.spm"}},
+		// With both vocabularies named, decoder.yml isn't read.
+		{"vocab override", nil, Files{Config: "missing.yml", Vocab: "v.yml", SPM: abs},
+			Layout{Native: true, Config: "missing.yml",
+				SourceVocab: "v.yml", TargetVocab: "v.yml", SourceSPM: abs, TargetSPM: abs}},
+		{"source vocab override", map[string]string{
+			"decoder.yml": "vocabs: [a.yml, b.yml]\n",
+		}, Files{SourceVocab: "s.yml", TargetSPM: "t.model"}, Layout{Native: true, Config: "decoder.yml",
+			SourceVocab: "s.yml", TargetVocab: "b.yml", SourceSPM: "source.spm", TargetSPM: "t.model"}},
+	}
+	for _, tt := range tests {
+		dir := modelDir(t, tt.files)
+		got, err := ResolveLayout(dir, tt.f)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := tt.want
+		want.Dir = dir
+		for _, p := range []*string{&want.Config, &want.SourceVocab, &want.TargetVocab, &want.SourceSPM, &want.TargetSPM} {
+			if !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+		if *got != want {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, *got, want)
+		}
+	}
+}
+
+func TestResolveLayoutNativeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		f     Files
+		want  string
+	}{
+		{"one vocab", map[string]string{"decoder.yml": "vocabs: [vocab.yml]\n"}, Files{},
+			"expected source and target in vocabs, got 1 entries"},
+		{"no vocabs", map[string]string{"decoder.yml": "beam-size: 6\n"}, Files{},
+			"got 0 entries"},
+		{"bad yaml", map[string]string{"decoder.yml": "vocabs: [a, b\n"}, Files{},
+			"decoder.yml: line 1: unterminated list"},
+		{"missing config", nil, Files{Config: "missing.yml"},
+			"missing.yml"},
+		{"several spms", map[string]string{"decoder.yml": "vocabs: [v.yml, v.yml]\n", "a.spm": "", "b.model": ""}, Files{},
+			"several SentencePiece models to share (a.spm, b.model)"},
+	}
+	for _, tt := range tests {
+		_, err := ResolveLayout(modelDir(t, tt.files), tt.f)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLoadModelNative(t *testing.T) {
+	dir := modelDir(t, map[string]string{
+		"decoder.yml":   "vocabs:\n  - vocab.src.yml\n  - vocab.trg.yml\nmax-length: 100\n",
+		"vocab.src.yml": "</s>: 0\n<unk>: 1\n▁a: 2\n",
+		"vocab.trg.yml": "</s>: 0\n<unk>: 1\n<pad>: 2\n▁b: 3\n▁c: 4\n",
+	})
+	m, err := LoadModel(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := m.Config
+	if cfg.VocabSize != 4 || cfg.DecoderVocabSize != 5 || cfg.EosTokenID != 0 || cfg.PadTokenID != 3 ||
+		cfg.DecoderStartTokenID != 2 || cfg.ModelMaxLength != 100 || cfg.MaxLength != 100 ||
+		!slices.EqualFunc(cfg.BadWordsIDs, [][]int{{2}}, slices.Equal) {
+		t.Errorf("Config = %+v", cfg)
+	}
+	if m.SourceVocab.Token(3) != "<pad>" || m.TargetVocab.Token(4) != "▁c" {
+		t.Errorf("vocabularies: %q, %q", m.SourceVocab.ID2Token, m.TargetVocab.ID2Token)
+	}
+
+	// A shared vocabulary is loaded once; max-length defaults to 512.
+	dir = modelDir(t, map[string]string{
+		"decoder.yml": "vocabs: [vocab.yml, vocab.yml]\n",
+		"vocab.yml":   "</s>: 0\n<unk>: 1\n",
+	})
+	if m, err = LoadModel(dir); err != nil {
+		t.Fatal(err)
+	}
+	if m.SourceVocab != m.TargetVocab || m.Config.ModelMaxLength != 512 || m.Config.PadTokenID != 2 {
+		t.Errorf("shared: %+v", m.Config)
+	}
+
+	for name, files := range map[string]map[string]string{
+		"max-length": {"decoder.yml": "vocabs: [v.yml, v.yml]\nmax-length: 0\n", "v.yml": "a: 0\n"},
+		"vocab":      {"decoder.yml": "vocabs: [v.yml, v.yml]\n", "v.yml": "a: 0\na: 1\n"},
+		"format":     {"decoder.yml": "vocabs: [v.txt, v.txt]\n", "v.txt": ""},
+	} {
+		if _, err := LoadModel(modelDir(t, files)); err == nil {
+			t.Errorf("%s: LoadModel succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// ErrNoRoute is returned (wrapped) by Catalog.Route when no chain of models
+// translates between the requested languages.
+var ErrNoRoute = errors.New("models: no route between languages")
+
+// Model describes a translation model directory: its languages and, for
+// models with several target languages, the >>lang<< tokens that select one.
+type Model struct {
+	Name   string   `json:"name"`
+	Dir    string   `json:"dir"`
+	Source []string `json:"source"`
+	Target []string `json:"target"`
+	// TargetTokens maps target languages to the vocabulary ids of their
+	// >>lang<< tokens; models without such tokens have one target language.
+	TargetTokens map[string]int64 `json:"target_tokens,omitempty"`
+}
+
+// opusLang matches a language of an OPUS-MT name: an ISO 639 code with an
+// optional region ("en", "deu", "pt_br") or a group name such as "ROMANCE".
+const opusLang = `(?:[a-z]{2,3}(?:_[a-zA-Z]{2,4})?|[A-Z]{2,})`
+
+// opusLangs captures "+"-joined languages, such as "en+fr".
+const opusLangs = `(` + opusLang + `(?:\+` + opusLang + `)*)`
+
+// opusName matches opus-mt-<src>-<tgt> directory names, including
+// variants such as opus-mt-tc-big-<src>-<tgt>. Both languages must be
+// language codes, so opus-mt-en-de-finetuned does not match.
+var opusName = regexp.MustCompile(`opus-mt-(?:.*-)?` + opusLangs + `-` + opusLangs + `$`)
+
+// langToken matches ">>lang<<": id entries of vocab.json (with < and >
+// possibly escaped) and vocab.yml.
+var langToken = regexp.MustCompile(`"?(?:>>|\\u003e\\u003e)([^"<>\\\s:]+)(?:<<|\\u003c\\u003c)"?\s*:\s*(\d+)`)
+
+// Describe reads the languages of the model in dir. They are taken from,
+// in order of preference:
+//
+//   - target languages: the >>lang<< tokens of the source vocabulary;
+//   - tokenizer_config.json's source_lang and target_lang;
+//   - README.md's "source language(s):" and "target language(s):" lines,
+//     as in OPUS-MT model cards;
+//   - a directory name of the form opus-mt-<src>-<tgt>, with languages
+//     joined by "+".
+//
+// It fails when the languages cannot be determined.
+func Describe(dir string) (Model, error) {
+	m := Model{Name: filepath.Base(dir), Dir: dir}
+
+	src, tgt := configLanguages(dir)
+	if src == nil || tgt == nil {
+		s, t := readmeLanguages(dir)
+		src, tgt = orElse(src, s), orElse(tgt, t)
+	}
+	if src == nil || tgt == nil {
+		if sub := opusName.FindStringSubmatch(m.Name); sub != nil {
+			src = orElse(src, strings.Split(sub[1], "+"))
+			tgt = orElse(tgt, strings.Split(sub[2], "+"))
+		}
+	}
+
+	tokens, err := targetTokens(dir)
+	if err != nil {
+		return Model{}, err
+	}
+	if len(tokens) > 0 {
+		tgt = nil
+		for lang := range tokens {
+			tgt = append(tgt, lang)
+		}
+		m.TargetTokens = tokens
+	}
+
+	if len(src) == 0 || len(tgt) == 0 {
+		return Model{}, fmt.Errorf("%s: cannot determine the model languages", dir)
+	}
+	m.Source = slices.Compact(slices.Sorted(slices.Values(src)))
+	m.Target = slices.Compact(slices.Sorted(slices.Values(tgt)))
+	return m, nil
+}
+
+func orElse(a, b []string) []string {
+	if a != nil {
+		return a
+	}
+	return b
+}
+
+// configLanguages reads source_lang and target_lang from
+// tokenizer_config.json.
+func configLanguages(dir string) (src, tgt []string) {
+	b, err := os.ReadFile(filepath.Join(dir, "tokenizer_config.json"))
+	if err != nil {
+		return nil, nil
+	}
+	var cfg struct {
+		SourceLang string `json:"source_lang"`
+		TargetLang string `json:"target_lang"`
+	}
+	if json.Unmarshal(b, &cfg) != nil {
+		return nil, nil
+	}
+	return splitLanguages(cfg.SourceLang), splitLanguages(cfg.TargetLang)
+}
+
+// readmeLanguages reads the language lines of an OPUS-MT README.md, such as
+// "* source languages: en" or "- target language(s): deu fra".
+func readmeLanguages(dir string) (src, tgt []string) {
+	f, err := os.Open(filepath.Join(dir, "README.md"))
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimLeft(sc.Text(), "*-# \t")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "source language", "source languages", "source language(s)":
+			src = orElse(src, splitLanguages(value))
+		case "target language", "target languages", "target language(s)":
+			tgt = orElse(tgt, splitLanguages(value))
+		}
+	}
+	return src, tgt
+}
+
+// splitLanguages splits a comma- or space-separated language list; it
+// returns nil for an empty one.
+func splitLanguages(s string) []string {
+	langs := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(langs) == 0 {
+		return nil
+	}
+	return langs
+}
+
+// targetTokens returns the >>lang<< tokens of the model's source vocabulary
+// (vocab.json or vocab.yml) by language.
+func targetTokens(dir string) (map[string]int64, error) {
+	l, err := marian.FindLayout(dir)
+	if err != nil || (!strings.HasSuffix(l.SourceVocab, ".json") && !strings.HasSuffix(l.SourceVocab, ".yml")) {
+		// tokenizer.json-only or SentencePiece vocabularies: no tokens.
+		return nil, nil
+	}
+	b, err := os.ReadFile(l.SourceVocab)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens map[string]int64
+	for _, sub := range langToken.FindAllSubmatch(b, -1) {
+		id, err := strconv.ParseInt(string(sub[2]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: token >>%s<<: %w", l.SourceVocab, sub[1], err)
+		}
+		if tokens == nil {
+			tokens = map[string]int64{}
+		}
+		tokens[string(sub[1])] = id
+	}
+	return tokens, nil
+}
+
+// Catalog is a set of translation models that language pairs are resolved
+// against.
+type Catalog struct {
+	models []Model // by name
+}
+
+// NewCatalog returns a catalog of models.
+func NewCatalog(models ...Model) *Catalog {
+	c := &Catalog{models: slices.Clone(models)}
+	sort.SliceStable(c.models, func(i, j int) bool { return c.models[i].Name < c.models[j].Name })
+	return c
+}
+
+// Scan returns a catalog of the model directories directly under root.
+// Directories whose languages cannot be determined (see Describe) are
+// skipped.
+func Scan(root string) (*Catalog, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var models []Model
+	for _, e := range entries {
+		dir := filepath.Join(root, e.Name())
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		if m, err := Describe(dir); err == nil {
+			models = append(models, m)
+		}
+	}
+	return NewCatalog(models...), nil
+}
+
+// Models returns the models of the catalog, sorted by name.
+func (c *Catalog) Models() []Model {
+	return slices.Clone(c.models)
+}
+
+// Resolve returns the directory of the named model. It can serve as
+// Options.Resolve, so a Manager loads models by catalog name.
+func (c *Catalog) Resolve(name string) (string, error) {
+	for _, m := range c.models {
+		if m.Name == name {
+			return m.Dir, nil
+		}
+	}
+	return "", fmt.Errorf("models: no model named %q in the catalog", name)
+}
+
+// Stage is one translation step of a Route: text in Source is encoded with
+// Model's tokenizer, translated, and decoded into Target.
+type Stage struct {
+	Model  Model  `json:"model"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// TargetToken is the >>lang<< token the model needs at the start of the
+	// input to produce Target, or "" when it has a single target language.
+	TargetToken   string `json:"target_token,omitempty"`
+	TargetTokenID int64  `json:"target_token_id,omitempty"`
+}
+
+// Middleware returns a middleware that starts every encoded input with the
+// stage's target token, the way the HuggingFace MarianTokenizer does for
+// ">>lang<< text"; the backends would split the token into pieces. Inputs
+// stay within model_max_length. Without a target token it changes nothing.
+func (s Stage) Middleware() marian.Middleware {
+	if s.TargetToken == "" {
+		return func(next marian.Tokenizer) marian.Tokenizer { return next }
+	}
+	return func(next marian.Tokenizer) marian.Tokenizer {
+		encode := func(text string, addEOS bool) ([]int64, error) {
+			cfg, err := next.Config()
+			if err != nil {
+				return nil, err
+			}
+			ids, err := next.Encode(text, addEOS)
+			if err != nil {
+				return nil, err
+			}
+			ids = slices.Insert(ids, 0, s.TargetTokenID)
+			// Truncate like the backends do.
+			if limit := cfg.ModelMaxLength; limit > 0 && len(ids) > limit {
+				ids = ids[:limit]
+			}
+			return ids, nil
+		}
+		return marian.Override(next, marian.Funcs{
+			Encode: encode,
+			EncodeBatch: func(texts []string) ([][]int64, [][]int64, error) {
+				cfg, err := next.Config()
+				if err != nil {
+					return nil, nil, err
+				}
+				rows := make([][]int64, len(texts))
+				for i, text := range texts {
+					if rows[i], err = encode(text, !cfg.NoBatchEOS); err != nil {
+						return nil, nil, err
+					}
+				}
+				ids, mask := marian.PadBatch(rows, cfg.PadTokenID)
+				return ids, mask, nil
+			},
+		})
+	}
+}
+
+// Route is a chain of stages, each translating the previous one's output.
+type Route []Stage
+
+// String formats the route as "ru -> en -> de".
+func (r Route) String() string {
+	if len(r) == 0 {
+		return ""
+	}
+	langs := []string{r[0].Source}
+	for _, s := range r {
+		langs = append(langs, s.Target)
+	}
+	return strings.Join(langs, " -> ")
+}
+
+// Route returns the shortest chain of models translating src into tgt: one
+// stage when a model covers the pair, otherwise a pivot route through
+// intermediate languages, such as ru -> en -> de. Among equally short
+// routes, models with fewer target languages are preferred, then names in
+// order. Route returns an empty route when src equals tgt, and an error
+// wrapping ErrNoRoute when the languages are not connected.
+func (c *Catalog) Route(src, tgt string) (Route, error) {
+	if src == tgt {
+		return Route{}, nil
+	}
+
+	// Models in order of preference for each step.
+	order := slices.Clone(c.models)
+	sort.SliceStable(order, func(i, j int) bool { return len(order[i].Target) < len(order[j].Target) })
+
+	// Breadth-first search over languages.
+	prev := map[string]Stage{}
+	queue := []string{src}
+	for len(queue) > 0 && !hasKey(prev, tgt) {
+		lang := queue[0]
+		queue = queue[1:]
+		for _, m := range order {
+			if !slices.Contains(m.Source, lang) {
+				continue
+			}
+			for _, next := range m.Target {
+				if next == src || hasKey(prev, next) {
+					continue
+				}
+				prev[next] = newStage(m, lang, next)
+				queue = append(queue, next)
+			}
+		}
+	}
+	if !hasKey(prev, tgt) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrNoRoute, src, tgt)
+	}
+
+	var route Route
+	for lang := tgt; lang != src; lang = prev[lang].Source {
+		route = append(route, prev[lang])
+	}
+	slices.Reverse(route)
+	return route, nil
+}
+
+func newStage(m Model, src, tgt string) Stage {
+	s := Stage{Model: m, Source: src, Target: tgt}
+	if id, ok := m.TargetTokens[tgt]; ok && len(m.Target) > 1 {
+		s.TargetToken = ">>" + tgt + "<<"
+		s.TargetTokenID = id
+	}
+	return s
+}
+
+func hasKey[V any](m map[string]V, key string) bool {
+	_, ok := m[key]
+	return ok
+}
@@ -0,0 +1,268 @@
+package models
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+func TestOpusName(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, tgt string // "" for no match
+	}{
+		{"opus-mt-en-de", "en", "de"},
+		{"opus-mt-ru-en", "ru", "en"},
+		{"opus-mt-tc-big-en-zle", "en", "zle"},
+		{"opus-mt-en-ROMANCE", "en", "ROMANCE"},
+		{"opus-mt-de+fr-en", "de+fr", "en"},
+		{"opus-mt-en-pt_br", "en", "pt_br"},
+		{"Helsinki-NLP_opus-mt-fi-en", "fi", "en"},
+		{"opus-mt-en-de-finetuned", "", ""},
+		{"opus-mt-en-de-v2", "", ""},
+		{"opus-mt-en", "", ""},
+		{"marian-en-de", "", ""},
+	}
+	for _, tt := range tests {
+		sub := opusName.FindStringSubmatch(tt.name)
+		var src, tgt string
+		if sub != nil {
+			src, tgt = sub[1], sub[2]
+		}
+		if src != tt.src || tgt != tt.tgt {
+			t.Errorf("%s: %q -> %q, want %q -> %q", tt.name, src, tgt, tt.src, tt.tgt)
+		}
+	}
+}
+
+// writeFiles creates dir/name under root with the given files.
+func writeFiles(t *testing.T, root, name string, files map[string]string) string {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDescribe(t *testing.T) {
+	readme := "# model\n\n* source language(s): ru\n* target language(s): en uk\n"
+	tests := []struct {
+		name   string
+		files  map[string]string
+		src    []string
+		tgt    []string
+		tokens map[string]int64
+	}{
+		{name: "opus-mt-en-de", src: []string{"en"}, tgt: []string{"de"}},
+		{name: "opus-mt-en-ROMANCE", files: map[string]string{
+			"vocab.json": `{"<pad>": 0, ">>fr<<": 5, ">>es<<": 6, "▁a": 7}`,
+		}, src: []string{"en"}, tgt: []string{"es", "fr"}, tokens: map[string]int64{"es": 6, "fr": 5}},
+		{name: "native", files: map[string]string{
+			"decoder.yml": "vocabs:\n  - vocab.yml\n  - vocab.yml\n",
+			"vocab.yml":   "\"</s>\": 0\n\">>deu<<\": 3\n>>nld<<: 4\n",
+			"README.md":   "source languages: en\n",
+		}, src: []string{"en"}, tgt: []string{"deu", "nld"}, tokens: map[string]int64{"deu": 3, "nld": 4}},
+		{name: "readme", files: map[string]string{"README.md": readme},
+			src: []string{"ru"}, tgt: []string{"en", "uk"}},
+		{name: "config", files: map[string]string{
+			"README.md":             readme,
+			"tokenizer_config.json": `{"source_lang": "de", "target_lang": "fr,it"}`,
+		}, src: []string{"de"}, tgt: []string{"fr", "it"}},
+		// Languages missing from the config come from the README, then the
+		// name.
+		{name: "opus-mt-en-fr", files: map[string]string{
+			"tokenizer_config.json": `{"source_lang": "de"}`,
+			"tokenizer.json":        `{}`,
+		}, src: []string{"de"}, tgt: []string{"fr"}},
+	}
+	root := t.TempDir()
+	for _, tt := range tests {
+		dir := writeFiles(t, root, tt.name, tt.files)
+		m, err := Describe(dir)
+		if err != nil {
+			t.Errorf("Describe(%s): %v", tt.name, err)
+			continue
+		}
+		if m.Name != tt.name || m.Dir != dir || !slices.Equal(m.Source, tt.src) || !slices.Equal(m.Target, tt.tgt) || !maps.Equal(m.TargetTokens, tt.tokens) {
+			t.Errorf("Describe(%s) = %+v, want %v -> %v with tokens %v", tt.name, m, tt.src, tt.tgt, tt.tokens)
+		}
+	}
+
+	for _, name := range []string{"unknown", "opus-mt-en-de-finetuned"} {
+		if m, err := Describe(writeFiles(t, root, name, nil)); err == nil {
+			t.Errorf("Describe(%s) = %+v, want an error", name, m)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "opus-mt-ru-en", nil)
+	writeFiles(t, root, "opus-mt-en-de", nil)
+	writeFiles(t, root, "weights", nil) // no languages: skipped
+	if err := os.WriteFile(filepath.Join(root, "opus-mt-en-fr"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range c.Models() {
+		names = append(names, m.Name)
+	}
+	if want := []string{"opus-mt-en-de", "opus-mt-ru-en"}; !slices.Equal(names, want) {
+		t.Errorf("Scan = %v, want %v", names, want)
+	}
+	if dir, err := c.Resolve("opus-mt-ru-en"); err != nil || dir != filepath.Join(root, "opus-mt-ru-en") {
+		t.Errorf("Resolve = %q, %v", dir, err)
+	}
+	if _, err := c.Resolve("opus-mt-en-fr"); err == nil {
+		t.Error("Resolve of an unknown model succeeded")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	model := func(name string, src string, tgt ...string) Model {
+		m := Model{Name: name, Source: []string{src}, Target: tgt}
+		if len(tgt) > 1 {
+			m.TargetTokens = map[string]int64{}
+			for i, lang := range tgt {
+				m.TargetTokens[lang] = int64(100 + i)
+			}
+		}
+		return m
+	}
+	c := NewCatalog(
+		model("opus-mt-ru-en", "ru", "en"),
+		model("opus-mt-en-de", "en", "de"),
+		model("opus-mt-en-ROMANCE", "en", "es", "fr", "it"),
+		model("opus-mt-en-fr", "en", "fr"),
+		model("opus-mt-fr-es", "fr", "es"),
+		model("b-en-pl", "en", "pl"),
+		model("a-en-pl", "en", "pl"),
+		model("opus-mt-uk-ru", "uk", "ru"),
+	)
+
+	tests := []struct {
+		src, tgt string
+		want     string // stages as model[>>token<<] joined by " "
+		str      string
+	}{
+		{"ru", "en", "opus-mt-ru-en", "ru -> en"},
+		{"ru", "de", "opus-mt-ru-en opus-mt-en-de", "ru -> en -> de"},
+		{"uk", "de", "opus-mt-uk-ru opus-mt-ru-en opus-mt-en-de", "uk -> ru -> en -> de"},
+		// A single-target model beats a multi-target one.
+		{"en", "fr", "opus-mt-en-fr", "en -> fr"},
+		{"en", "es", "opus-mt-en-ROMANCE>>es<<", "en -> es"},
+		// Shortest first: not through en-fr and fr-es.
+		{"ru", "es", "opus-mt-ru-en opus-mt-en-ROMANCE>>es<<", "ru -> en -> es"},
+		// Equal models: names in order.
+		{"en", "pl", "a-en-pl", "en -> pl"},
+		{"fr", "fr", "", ""},
+	}
+	for _, tt := range tests {
+		route, err := c.Route(tt.src, tt.tgt)
+		if err != nil {
+			t.Errorf("Route(%s, %s): %v", tt.src, tt.tgt, err)
+			continue
+		}
+		var stages []string
+		for _, s := range route {
+			stage := s.Model.Name + s.TargetToken
+			if s.TargetToken != "" && s.TargetTokenID != s.Model.TargetTokens[s.Target] {
+				t.Errorf("Route(%s, %s): %s has id %d", tt.src, tt.tgt, s.TargetToken, s.TargetTokenID)
+			}
+			stages = append(stages, stage)
+		}
+		if got := strings.Join(stages, " "); got != tt.want || route.String() != tt.str {
+			t.Errorf("Route(%s, %s) = %q (%s), want %q (%s)", tt.src, tt.tgt, got, route, tt.want, tt.str)
+		}
+	}
+
+	for _, pair := range [][2]string{{"de", "ru"}, {"en", "xx"}} {
+		if _, err := c.Route(pair[0], pair[1]); !errors.Is(err, ErrNoRoute) {
+			t.Errorf("Route(%s, %s) = %v, want ErrNoRoute", pair[0], pair[1], err)
+		}
+	}
+}
+
+// runeTokenizer encodes every rune as 1 and appends 0 as EOS.
+type runeTokenizer struct {
+	fakeTokenizer
+	noBatchEOS bool
+}
+
+func (r *runeTokenizer) Encode(text string, addEOS bool) ([]int64, error) {
+	var ids []int64
+	for range text {
+		ids = append(ids, 1)
+	}
+	if addEOS {
+		ids = append(ids, 0)
+	}
+	return ids, nil
+}
+
+func (r *runeTokenizer) Config() (*marian.Config, error) {
+	return &marian.Config{ModelMaxLength: 4, PadTokenID: 9, NoBatchEOS: r.noBatchEOS}, nil
+}
+
+func TestStageMiddleware(t *testing.T) {
+	stage := Stage{Target: "fr", TargetToken: ">>fr<<", TargetTokenID: 7}
+	tok := marian.Wrap(&runeTokenizer{}, stage.Middleware())
+
+	for _, tt := range []struct {
+		text   string
+		addEOS bool
+		want   []int64
+	}{
+		{"ab", true, []int64{7, 1, 1, 0}},
+		{"ab", false, []int64{7, 1, 1}},
+		{"", true, []int64{7, 0}},
+		// Truncated to model_max_length with the token.
+		{"abcdef", true, []int64{7, 1, 1, 1}},
+	} {
+		got, err := tok.Encode(tt.text, tt.addEOS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Encode(%q, %v) = %v, want %v", tt.text, tt.addEOS, got, tt.want)
+		}
+	}
+
+	for _, noBatchEOS := range []bool{false, true} {
+		tok := marian.Wrap(&runeTokenizer{noBatchEOS: noBatchEOS}, stage.Middleware())
+		ids, mask, err := tok.EncodeBatch([]string{"a", "abcdef"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantIDs, wantMask := [][]int64{{7, 1, 0, 9}, {7, 1, 1, 1}}, [][]int64{{1, 1, 1, 0}, {1, 1, 1, 1}}
+		if noBatchEOS {
+			wantIDs, wantMask = [][]int64{{7, 1, 9, 9}, {7, 1, 1, 1}}, [][]int64{{1, 1, 0, 0}, {1, 1, 1, 1}}
+		}
+		if !slices.EqualFunc(ids, wantIDs, slices.Equal) || !slices.EqualFunc(mask, wantMask, slices.Equal) {
+			t.Errorf("noBatchEOS=%v: EncodeBatch = %v %v, want %v %v", noBatchEOS, ids, mask, wantIDs, wantMask)
+		}
+	}
+
+	// Without a target token the middleware changes nothing.
+	next := &runeTokenizer{}
+	if got := marian.Wrap(next, Stage{Target: "en"}.Middleware()); got != marian.Tokenizer(next) {
+		t.Errorf("middleware without a target token wrapped the tokenizer: %T", got)
+	}
+}
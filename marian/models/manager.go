@@ -0,0 +1,463 @@
+// Package models serves many Marian models from one process.
+//
+// A Manager loads tokenizers lazily by model name or directory, shares one
+// tokenizer between all callers of the same model, and closes idle ones to
+// stay within a count or memory budget:
+//
+//	m := models.NewManager(models.Options{MaxLoaded: 8, IdleTimeout: 10 * time.Minute})
+//	defer m.Close()
+//
+//	tok, err := m.Get("./models/opus-mt-ru-en")
+//	if err != nil {
+//		return err
+//	}
+//	defer tok.Close() // releases this caller's reference
+//	ids, err := tok.Encode(text, true)
+//
+// A Catalog lists the models under a directory by language pair and
+// resolves a (source, target) pair to the models to chain, pivoting through
+// other languages when no model covers the pair directly:
+//
+//	catalog, err := models.Scan("./models")
+//	route, err := catalog.Route("ru", "de") // opus-mt-ru-en, then opus-mt-en-de
+package models
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Options configures a Manager.
+type Options struct {
+	// Open creates the tokenizers, marian.Open (the registry) when nil.
+	Open marian.OpenFunc
+	// Options are passed to Open for every model.
+	Options []marian.Option
+	// Resolve maps a model name to its directory. When nil, names are
+	// directories.
+	Resolve func(name string) (string, error)
+
+	// MaxLoaded is the maximum number of loaded tokenizers, 0 for no limit.
+	MaxLoaded int
+	// MaxBytes is the maximum estimated memory of the loaded tokenizers, 0
+	// for no limit. A tokenizer is estimated at the size of its model files
+	// (SentencePiece models, vocabularies and config, see Cost).
+	MaxBytes int64
+	// IdleTimeout closes tokenizers that have had no references for this
+	// long, 0 to keep them until the budget needs room.
+	IdleTimeout time.Duration
+}
+
+// Manager owns the tokenizers of many models, keyed by name. Get loads a
+// model on first use and returns a reference to its shared tokenizer; the
+// tokenizer stays loaded while any reference is open. Tokenizers without
+// references are idle: when loading a model exceeds MaxLoaded or MaxBytes,
+// the least recently used idle tokenizers are closed. Tokenizers in use are
+// never closed for the budget, so it is exceeded while they are all in use.
+//
+// A Manager is safe for concurrent use.
+type Manager struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	idle    *list.List // of *entry, least recently used first
+	bytes   int64
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type entry struct {
+	name  string
+	dir   string
+	bytes int64
+
+	ready chan struct{} // closed when loading is done
+	tok   marian.Tokenizer
+	err   error
+
+	refs     int
+	lastUsed time.Time
+	elem     *list.Element // in Manager.idle while refs == 0
+}
+
+// Stat describes a model known to a Manager.
+type Stat struct {
+	Name string
+	Dir  string
+	// Bytes is the estimated memory of the tokenizer.
+	Bytes int64
+	// Refs is the number of open references.
+	Refs int
+	// LastUsed is when the last reference was taken or released.
+	LastUsed time.Time
+}
+
+// NewManager returns a Manager with no models loaded.
+func NewManager(opts Options) *Manager {
+	if opts.Open == nil {
+		opts.Open = marian.Open
+	}
+	m := &Manager{
+		opts:    opts,
+		entries: map[string]*entry{},
+		idle:    list.New(),
+	}
+	if opts.IdleTimeout > 0 {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.expire()
+	}
+	return m
+}
+
+// Get returns a reference to the tokenizer for the named model, loading it
+// if needed. Concurrent Gets of a model that is loading wait for the same
+// load. The caller must Close the returned Handle when done; closing it
+// releases the reference, not the shared tokenizer. A failed load is not
+// cached, so the next Get tries again.
+func (m *Manager) Get(name string) (*Handle, error) {
+	if h, ok, err := m.shared(name); ok {
+		return h, err
+	}
+
+	// Stat the model files without holding the lock, then check again.
+	dir, bytes, err := m.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	if _, ok := m.entries[name]; ok || m.closed {
+		m.mu.Unlock()
+		return m.Get(name)
+	}
+	e := &entry{name: name, dir: dir, bytes: bytes, ready: make(chan struct{})}
+	m.entries[name] = e
+	m.bytes += bytes
+	m.ref(e)
+	evicted := m.evict()
+	m.mu.Unlock()
+	closeAll(evicted)
+
+	e.tok, e.err = m.opts.Open(dir, m.opts.Options...)
+	if e.err != nil {
+		e.err = fmt.Errorf("load model %s: %w", name, e.err)
+		m.mu.Lock()
+		delete(m.entries, name)
+		m.bytes -= bytes
+		m.mu.Unlock()
+	}
+	close(e.ready)
+
+	if e.err != nil {
+		m.release(e)
+		return nil, e.err
+	}
+	return &Handle{m: m, e: e}, nil
+}
+
+// shared returns a reference to the named model when it is loaded or
+// loading, after waiting for the load. ok is false when the model is not
+// known.
+func (m *Manager) shared(name string) (h *Handle, ok bool, err error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, true, marian.ErrClosed
+	}
+	e, ok := m.entries[name]
+	if !ok {
+		m.mu.Unlock()
+		return nil, false, nil
+	}
+	m.ref(e)
+	m.mu.Unlock()
+
+	<-e.ready
+	if e.err != nil {
+		m.release(e)
+		return nil, true, e.err
+	}
+	return &Handle{m: m, e: e}, true, nil
+}
+
+// resolve returns the directory of the named model and its estimated size.
+func (m *Manager) resolve(name string) (string, int64, error) {
+	dir := name
+	if m.opts.Resolve != nil {
+		var err error
+		if dir, err = m.opts.Resolve(name); err != nil {
+			return "", 0, err
+		}
+	}
+	bytes, err := Cost(dir, marian.ApplyOptions(m.opts.Options...).Files)
+	if err != nil {
+		return "", 0, fmt.Errorf("model %s: %w", name, err)
+	}
+	return dir, bytes, nil
+}
+
+// ref takes a reference to e. m.mu must be held.
+func (m *Manager) ref(e *entry) {
+	e.refs++
+	e.lastUsed = time.Now()
+	if e.elem != nil {
+		m.idle.Remove(e.elem)
+		e.elem = nil
+	}
+}
+
+// release drops a reference to e and closes what the budget no longer
+// allows.
+func (m *Manager) release(e *entry) {
+	m.mu.Lock()
+	e.refs--
+	e.lastUsed = time.Now()
+	var evicted []*entry
+	if e.refs == 0 {
+		switch {
+		case e.err != nil:
+			// Failed loads are already out of the map.
+		case m.closed:
+			evicted = append(evicted, e)
+		default:
+			e.elem = m.idle.PushBack(e)
+			evicted = m.evict()
+		}
+	}
+	m.mu.Unlock()
+	closeAll(evicted)
+}
+
+// evict removes idle entries, least recently used first, until the loaded
+// models fit the budget, and returns them for closing. m.mu must be held.
+func (m *Manager) evict() []*entry {
+	var evicted []*entry
+	for m.overBudget() && m.idle.Len() > 0 {
+		evicted = append(evicted, m.remove(m.idle.Front().Value.(*entry)))
+	}
+	return evicted
+}
+
+func (m *Manager) overBudget() bool {
+	return (m.opts.MaxLoaded > 0 && len(m.entries) > m.opts.MaxLoaded) ||
+		(m.opts.MaxBytes > 0 && m.bytes > m.opts.MaxBytes)
+}
+
+// remove takes an idle entry out of the manager. m.mu must be held.
+func (m *Manager) remove(e *entry) *entry {
+	m.idle.Remove(e.elem)
+	e.elem = nil
+	delete(m.entries, e.name)
+	m.bytes -= e.bytes
+	return e
+}
+
+func closeAll(entries []*entry) {
+	for _, e := range entries {
+		e.tok.Close()
+	}
+}
+
+// expire closes tokenizers idle for longer than IdleTimeout.
+func (m *Manager) expire() {
+	defer close(m.done)
+	ticker := time.NewTicker(max(m.opts.IdleTimeout/4, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			var evicted []*entry
+			for m.idle.Len() > 0 {
+				e := m.idle.Front().Value.(*entry)
+				if now.Sub(e.lastUsed) < m.opts.IdleTimeout {
+					break
+				}
+				evicted = append(evicted, m.remove(e))
+			}
+			m.mu.Unlock()
+			closeAll(evicted)
+		}
+	}
+}
+
+// Evict closes the named model's tokenizer if it is idle, and reports
+// whether it did.
+func (m *Manager) Evict(name string) bool {
+	m.mu.Lock()
+	e, ok := m.entries[name]
+	if !ok || e.elem == nil {
+		m.mu.Unlock()
+		return false
+	}
+	m.remove(e)
+	m.mu.Unlock()
+	e.tok.Close()
+	return true
+}
+
+// Stats returns the models currently loaded or loading, sorted by name.
+func (m *Manager) Stats() []Stat {
+	m.mu.Lock()
+	stats := make([]Stat, 0, len(m.entries))
+	for _, e := range m.entries {
+		stats = append(stats, Stat{Name: e.name, Dir: e.dir, Bytes: e.bytes, Refs: e.refs, LastUsed: e.lastUsed})
+	}
+	m.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Close closes the idle tokenizers at once and the others when their last
+// Handle is closed. Get fails with marian.ErrClosed afterwards.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	var evicted []*entry
+	for m.idle.Len() > 0 {
+		evicted = append(evicted, m.remove(m.idle.Front().Value.(*entry)))
+	}
+	m.mu.Unlock()
+
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+	}
+	closeAll(evicted)
+}
+
+// Cost estimates the memory a tokenizer for dir takes by the size of the
+// files it loads (see marian.ModelFiles): the SentencePiece models,
+// vocabularies and config of its layout, or tokenizer.json. Shared files
+// count once.
+func Cost(dir string, files marian.Files) (int64, error) {
+	paths, err := marian.ModelFiles(dir, files)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += fi.Size()
+	}
+	return total, nil
+}
+
+// Handle is a reference to a tokenizer shared through a Manager. Calls are
+// forwarded to the shared tokenizer; Close releases the reference once the
+// calls made through this Handle have returned, after which they fail with
+// marian.ErrClosed.
+type Handle struct {
+	m    *Manager
+	e    *entry
+	life marian.Lifecycle
+}
+
+// Ensure Handle satisfies the common interface and the optional ones.
+var (
+	_ marian.Tokenizer        = (*Handle)(nil)
+	_ marian.BytesEncoder     = (*Handle)(nil)
+	_ marian.IntoEncoder      = (*Handle)(nil)
+	_ marian.BatchIntoEncoder = (*Handle)(nil)
+)
+
+// Name returns the model name the Handle was obtained with.
+func (h *Handle) Name() string { return h.e.name }
+
+// Dir returns the model directory.
+func (h *Handle) Dir() string { return h.e.dir }
+
+// Encode encodes text with the shared tokenizer.
+func (h *Handle) Encode(text string, addEOS bool) ([]int64, error) {
+	if err := h.life.Acquire(); err != nil {
+		return nil, err
+	}
+	defer h.life.Release()
+	return h.e.tok.Encode(text, addEOS)
+}
+
+// EncodeBytes encodes text with the shared tokenizer, see marian.EncodeBytes.
+func (h *Handle) EncodeBytes(text []byte, addEOS bool) ([]int64, error) {
+	if err := h.life.Acquire(); err != nil {
+		return nil, err
+	}
+	defer h.life.Release()
+	return marian.EncodeBytes(h.e.tok, text, addEOS)
+}
+
+// EncodeInto encodes text with the shared tokenizer, see marian.EncodeInto.
+func (h *Handle) EncodeInto(dst []int64, text string, addEOS bool) ([]int64, error) {
+	if err := h.life.Acquire(); err != nil {
+		return dst, err
+	}
+	defer h.life.Release()
+	return marian.EncodeInto(h.e.tok, dst, text, addEOS)
+}
+
+// EncodeBatch encodes texts with the shared tokenizer.
+func (h *Handle) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	if err := h.life.Acquire(); err != nil {
+		return nil, nil, err
+	}
+	defer h.life.Release()
+	return h.e.tok.EncodeBatch(texts)
+}
+
+// EncodeBatchInto encodes texts with the shared tokenizer, see
+// marian.EncodeBatchInto.
+func (h *Handle) EncodeBatchInto(ids, mask []int64, texts []string) (int, error) {
+	if err := h.life.Acquire(); err != nil {
+		return 0, err
+	}
+	defer h.life.Release()
+	return marian.EncodeBatchInto(h.e.tok, ids, mask, texts)
+}
+
+// Decode decodes ids with the shared tokenizer.
+func (h *Handle) Decode(ids []int64, skipSpecial bool) (string, error) {
+	if err := h.life.Acquire(); err != nil {
+		return "", err
+	}
+	defer h.life.Release()
+	return h.e.tok.Decode(ids, skipSpecial)
+}
+
+// Config returns the shared tokenizer's configuration. It must not be
+// modified by the caller.
+func (h *Handle) Config() (*marian.Config, error) {
+	if err := h.life.Acquire(); err != nil {
+		return nil, err
+	}
+	defer h.life.Release()
+	return h.e.tok.Config()
+}
+
+// Close releases the reference. A second Close does nothing.
+func (h *Handle) Close() {
+	if h.life.Close() {
+		h.m.release(h.e)
+	}
+}
@@ -0,0 +1,276 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// fakeTokenizer is the tokenizer of a fakeOpener; Encode returns nothing.
+type fakeTokenizer struct {
+	dir    string
+	opener *fakeOpener
+}
+
+func (f *fakeTokenizer) Encode(string, bool) ([]int64, error)               { return nil, nil }
+func (f *fakeTokenizer) EncodeBatch([]string) ([][]int64, [][]int64, error) { return nil, nil, nil }
+func (f *fakeTokenizer) Decode([]int64, bool) (string, error)               { return "", nil }
+func (f *fakeTokenizer) Config() (*marian.Config, error)                    { return &marian.Config{}, nil }
+func (f *fakeTokenizer) Close()                                             { f.opener.count(f.dir, &f.opener.closes) }
+
+// fakeOpener is an OpenFunc that counts opens and closes per model name
+// (the base name of the directory). Opening a model listed in fail fails,
+// and while gate is set, opens wait for it to be closed.
+type fakeOpener struct {
+	mu     sync.Mutex
+	opens  map[string]int
+	closes map[string]int
+	fail   map[string]bool
+	gate   chan struct{}
+}
+
+func newFakeOpener() *fakeOpener {
+	return &fakeOpener{opens: map[string]int{}, closes: map[string]int{}, fail: map[string]bool{}}
+}
+
+func (o *fakeOpener) open(dir string, _ ...marian.Option) (marian.Tokenizer, error) {
+	if o.gate != nil {
+		<-o.gate
+	}
+	o.count(dir, &o.opens)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.fail[filepath.Base(dir)] {
+		return nil, errors.New("broken model")
+	}
+	return &fakeTokenizer{dir: dir, opener: o}, nil
+}
+
+func (o *fakeOpener) count(dir string, m *map[string]int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	(*m)[filepath.Base(dir)]++
+}
+
+// counts returns the opens and closes of the named model.
+func (o *fakeOpener) counts(name string) (opens, closes int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.opens[name], o.closes[name]
+}
+
+// modelsDir creates a tokenizer.json of size bytes for each name and
+// returns the directory holding the models.
+func modelsDir(t *testing.T, size int, names ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, name := range names {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "tokenizer.json"), []byte(strings.Repeat(" ", size)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func newTestManager(t *testing.T, opts Options, names ...string) (*Manager, *fakeOpener) {
+	t.Helper()
+	root := modelsDir(t, 100, names...)
+	o := newFakeOpener()
+	opts.Open = o.open
+	opts.Resolve = func(name string) (string, error) { return filepath.Join(root, name), nil }
+	m := NewManager(opts)
+	t.Cleanup(m.Close)
+	return m, o
+}
+
+func mustGet(t *testing.T, m *Manager, name string) *Handle {
+	t.Helper()
+	h, err := m.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func checkCounts(t *testing.T, o *fakeOpener, name string, opens, closes int) {
+	t.Helper()
+	if gotOpens, gotCloses := o.counts(name); gotOpens != opens || gotCloses != closes {
+		t.Errorf("%s: opened %d, closed %d times; want %d and %d", name, gotOpens, gotCloses, opens, closes)
+	}
+}
+
+func TestManagerRefcounts(t *testing.T) {
+	m, o := newTestManager(t, Options{}, "a")
+	h1 := mustGet(t, m, "a")
+	h2 := mustGet(t, m, "a")
+	checkCounts(t, o, "a", 1, 0)
+	if s := m.Stats(); len(s) != 1 || s[0].Refs != 2 || s[0].Bytes != 100 {
+		t.Fatalf("Stats = %+v, want a with 2 refs and 100 bytes", s)
+	}
+
+	h1.Close()
+	h1.Close() // a second Close releases nothing
+	if s := m.Stats(); s[0].Refs != 1 {
+		t.Errorf("Refs after closing one handle = %d, want 1", s[0].Refs)
+	}
+	if _, err := h1.Encode("x", true); !errors.Is(err, marian.ErrClosed) {
+		t.Errorf("Encode on a closed handle = %v, want ErrClosed", err)
+	}
+	if _, err := h2.Encode("x", true); err != nil {
+		t.Errorf("Encode on the open handle: %v", err)
+	}
+
+	// Without a budget an idle tokenizer stays loaded until Close.
+	h2.Close()
+	checkCounts(t, o, "a", 1, 0)
+	m.Close()
+	checkCounts(t, o, "a", 1, 1)
+	if _, err := m.Get("a"); !errors.Is(err, marian.ErrClosed) {
+		t.Errorf("Get after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestManagerCloseWithHandles(t *testing.T) {
+	m, o := newTestManager(t, Options{}, "a")
+	h := mustGet(t, m, "a")
+	m.Close()
+	checkCounts(t, o, "a", 1, 0)
+	h.Close()
+	checkCounts(t, o, "a", 1, 1)
+}
+
+func TestManagerMaxLoaded(t *testing.T) {
+	m, o := newTestManager(t, Options{MaxLoaded: 2}, "a", "b", "c")
+	mustGet(t, m, "a").Close()
+	mustGet(t, m, "b").Close()
+	mustGet(t, m, "a").Close() // b is now the least recently used
+	mustGet(t, m, "c").Close()
+	checkCounts(t, o, "a", 1, 0)
+	checkCounts(t, o, "b", 1, 1)
+	checkCounts(t, o, "c", 1, 0)
+
+	mustGet(t, m, "b").Close()
+	checkCounts(t, o, "a", 1, 1)
+	checkCounts(t, o, "b", 2, 1)
+}
+
+func TestManagerInUseNotEvicted(t *testing.T) {
+	m, o := newTestManager(t, Options{MaxLoaded: 1}, "a", "b")
+	a := mustGet(t, m, "a")
+	b := mustGet(t, m, "b")
+	checkCounts(t, o, "a", 1, 0)
+	checkCounts(t, o, "b", 1, 0)
+	if n := len(m.Stats()); n != 2 {
+		t.Errorf("%d models loaded, want 2 while both are in use", n)
+	}
+
+	a.Close()
+	checkCounts(t, o, "a", 1, 1)
+	b.Close()
+	checkCounts(t, o, "b", 1, 0)
+}
+
+func TestManagerMaxBytes(t *testing.T) {
+	m, o := newTestManager(t, Options{MaxBytes: 250}, "a", "b", "c")
+	mustGet(t, m, "a").Close()
+	mustGet(t, m, "b").Close()
+	checkCounts(t, o, "a", 1, 0)
+	mustGet(t, m, "c").Close()
+	checkCounts(t, o, "a", 1, 1)
+	checkCounts(t, o, "b", 1, 0)
+
+	var total int64
+	for _, s := range m.Stats() {
+		total += s.Bytes
+	}
+	if total != 200 {
+		t.Errorf("loaded %d bytes, want 200", total)
+	}
+}
+
+func TestManagerIdleTimeout(t *testing.T) {
+	m, o := newTestManager(t, Options{IdleTimeout: 20 * time.Millisecond}, "a", "b")
+	mustGet(t, m, "a").Close()
+	b := mustGet(t, m, "b")
+	defer b.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, closes := o.counts("a"); closes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle tokenizer was not closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s := m.Stats(); len(s) != 1 || s[0].Name != "b" {
+		t.Errorf("Stats = %+v, want only b, which is in use", s)
+	}
+	checkCounts(t, o, "b", 1, 0)
+}
+
+func TestManagerConcurrentGets(t *testing.T) {
+	m, o := newTestManager(t, Options{}, "a")
+	o.gate = make(chan struct{})
+
+	const n = 8
+	handles := make(chan *Handle, n)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Go(func() {
+			h, err := m.Get("a")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			handles <- h
+		})
+	}
+	for len(m.Stats()) == 0 || m.Stats()[0].Refs < n {
+		time.Sleep(time.Millisecond)
+	}
+	close(o.gate)
+	wg.Wait()
+	close(handles)
+
+	var tok marian.Tokenizer
+	for h := range handles {
+		if tok != nil && h.e.tok != tok {
+			t.Error("handles do not share one tokenizer")
+		}
+		tok = h.e.tok
+		h.Close()
+	}
+	checkCounts(t, o, "a", 1, 0)
+}
+
+func TestManagerFailedLoadNotCached(t *testing.T) {
+	m, o := newTestManager(t, Options{}, "a")
+	o.fail["a"] = true
+	for i := 1; i <= 2; i++ {
+		if _, err := m.Get("a"); err == nil || !strings.Contains(err.Error(), "broken model") {
+			t.Fatalf("Get %d = %v, want the load error", i, err)
+		}
+		checkCounts(t, o, "a", i, 0)
+		if s := m.Stats(); len(s) != 0 {
+			t.Errorf("Stats after a failed load = %+v, want none", s)
+		}
+	}
+
+	o.mu.Lock()
+	o.fail["a"] = false
+	o.mu.Unlock()
+	mustGet(t, m, "a").Close()
+	checkCounts(t, o, "a", 3, 0)
+}
@@ -0,0 +1,92 @@
+package moses
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	multiSpace = regexp.MustCompile(`[ \t\x{a0}]{2,}`)
+	// Space before closing punctuation: "word ." -> "word."
+	spaceBeforePunct = regexp.MustCompile(`\s+([.,;:!?%)\]}…])`)
+	// French keeps a space before these, so it only loses it before the rest.
+	spaceBeforePunctFr = regexp.MustCompile(`\s+([.,%)\]}…])`)
+	// Space after opening brackets: "( word" -> "(word"
+	spaceAfterOpen = regexp.MustCompile(`([(\[{])\s+`)
+	// Split English contractions: "don ' t" -> "don't"
+	spacedApostrophe = regexp.MustCompile(`(\p{L}) ?' ?(s|t|re|ve|ll|d|m)\b`)
+)
+
+// Detokenizer cleans up decoded text: it removes the spaces that
+// SentencePiece output sometimes leaves around punctuation, brackets and
+// straight quotes, and collapses repeated spaces. It is safe for concurrent
+// use and implements marian.Postprocessor.
+type Detokenizer struct {
+	lang string
+}
+
+// NewDetokenizer creates a Detokenizer for decoded text in lang (the target
+// language of the model).
+func NewDetokenizer(lang string) *Detokenizer {
+	return &Detokenizer{lang: lang}
+}
+
+// Detokenize returns the cleaned-up text. Line breaks are kept.
+func (d *Detokenizer) Detokenize(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = d.line(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Postprocess implements marian.Postprocessor.
+func (d *Detokenizer) Postprocess(text string) string {
+	return d.Detokenize(text)
+}
+
+func (d *Detokenizer) line(s string) string {
+	s = multiSpace.ReplaceAllString(s, " ")
+	if d.lang == "fr" {
+		s = spaceBeforePunctFr.ReplaceAllString(s, "${1}")
+	} else {
+		s = spaceBeforePunct.ReplaceAllString(s, "${1}")
+	}
+	s = spaceAfterOpen.ReplaceAllString(s, "${1}")
+	if d.lang == "en" {
+		s = spacedApostrophe.ReplaceAllString(s, "${1}'${2}")
+	}
+	s = attachQuotes(s)
+	return strings.TrimSpace(s)
+}
+
+// attachQuotes pairs straight double quotes left to right and removes the
+// space inside each pair: `say " hi " now` -> `say "hi" now`. Lines with an
+// odd number of quotes are left alone, since the pairing would be a guess.
+func attachQuotes(s string) string {
+	if strings.Count(s, `"`)%2 != 0 {
+		return s
+	}
+
+	out := make([]byte, 0, len(s))
+	open := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			if open {
+				// Closing quote: drop the spaces before it.
+				for len(out) > 0 && out[len(out)-1] == ' ' {
+					out = out[:len(out)-1]
+				}
+			} else {
+				// Opening quote: skip the spaces after it.
+				for i+1 < len(s) && s[i+1] == ' ' {
+					i++
+				}
+			}
+			open = !open
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
@@ -0,0 +1,219 @@
+// Package moses ports the Moses punctuation normalizer
+// (scripts/tokenizer/normalize-punctuation.perl) that opus-mt training data
+// was run through, plus a light cleanup for decoded text.
+//
+// Feeding a Marian model text normalized the same way as its training data
+// avoids <unk> pieces for typographic quotes, dashes and pseudo-spaces:
+//
+//	norm := moses.NewNormalizer(moses.Options{Lang: "ru"})
+//	tok = marian.Wrap(tok, marian.Process(norm, moses.NewDetokenizer("en")))
+package moses
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Options configures a Normalizer.
+type Options struct {
+	// Lang is the language code of the input, as passed to the script's -l
+	// flag (default "en"). It selects the quote/comma and number rules.
+	Lang string
+	// Penn keeps ` and '' as they are, like the script's -penn flag. Set it
+	// for Penn Treebank style input where they are quote tokens.
+	Penn bool
+	// Sacremoses follows the sacremoses port (MosesPunctNormalizer) used by
+	// transformers' MarianTokenizer instead of the Perl script. It differs
+	// in a few rules: ’ becomes ' instead of ", spaced « and » lose the
+	// surrounding space, the quote/comma swap only applies to de, es and fr,
+	// every line is normalized and the result is trimmed.
+	Sacremoses bool
+}
+
+type rule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// r compiles a rule. repl uses regexp.Expand syntax (${1}).
+func r(pattern, repl string) rule {
+	return rule{regexp.MustCompile(pattern), repl}
+}
+
+// lit is a rule that replaces a literal string.
+func lit(old, repl string) rule {
+	return rule{regexp.MustCompile(regexp.QuoteMeta(old)), strings.ReplaceAll(repl, "$", "$$")}
+}
+
+// The script runs with `use utf8`, so \d and \s match Unicode digits and
+// spaces there; Go's are ASCII-only.
+const (
+	digit = `(\p{Nd})`
+	space = `[\s\x{85}\p{Z}]`
+	nbsp  = "\u00a0"
+)
+
+var (
+	extraWhitespace = []rule{
+		lit("\r", ""),
+		lit("(", " ("),
+		lit(")", ") "),
+		r(` +`, " "),
+		r(`\) ([.!:?;,])`, ")${1}"),
+		lit("( ", "("),
+		lit(" )", ")"),
+		r(digit+` %`, "${1}%"),
+		lit(" :", ":"),
+		lit(" ;", ";"),
+	}
+
+	notPenn = []rule{
+		lit("`", "'"),
+		lit("''", ` " `),
+	}
+
+	pseudoSpaces = []rule{
+		lit(nbsp+"%", "%"),
+		lit("nº"+nbsp, "nº "),
+		lit(nbsp+":", ":"),
+		lit(nbsp+"ºC", " ºC"),
+		lit(nbsp+"cm", " cm"),
+		lit(nbsp+"?", "?"),
+		lit(nbsp+"!", "!"),
+		lit(nbsp+";", ";"),
+		lit(","+nbsp, ", "),
+		r(` +`, " "),
+	}
+
+	// English "quotation," followed by comma, style.
+	enQuoteComma = []rule{
+		r(`"([,.]+)`, `${1}"`),
+	}
+
+	// German/Spanish/French "quotation", followed by comma, style.
+	deQuoteComma = []rule{
+		lit(`,"`, `",`),
+		r(`(\.+)"(`+space+`*[^<])`, `"${1}${2}`), // don't fix period at end of sentence
+	}
+
+	commaNumbers = []rule{r(digit+nbsp+digit, "${1},${2}")}
+	dotNumbers   = []rule{r(digit+nbsp+digit, "${1}.${2}")}
+
+	// Lines the script passes through untouched: markup and blank lines.
+	skipLine = regexp.MustCompile(`^<.+>$|^` + space + `*$`)
+)
+
+// unicodePunct returns the Unicode punctuation rules. The script turns a
+// lone ’ into ", sacremoses into '.
+func unicodePunct(rsquo string) []rule {
+	return []rule{
+		lit("„", `"`),
+		lit("“", `"`),
+		lit("”", `"`),
+		lit("–", "-"),
+		lit("—", " - "),
+		r(` +`, " "),
+		lit("´", "'"),
+		r(`(?i)([a-z])‘([a-z])`, "${1}'${2}"),
+		r(`(?i)([a-z])’([a-z])`, "${1}'${2}"),
+		lit("‘", "'"),
+		lit("‚", "'"),
+		lit("’", rsquo),
+		lit("''", `"`),
+		lit("´´", `"`),
+		lit("…", "..."),
+	}
+}
+
+// frenchQuotes returns the rules for « and ». The script keeps the outer
+// space of a spaced quote, sacremoses drops it.
+func frenchQuotes(open, close string) []rule {
+	return []rule{
+		lit(nbsp+"«"+nbsp, open),
+		lit("«"+nbsp, `"`),
+		lit("«", `"`),
+		lit(nbsp+"»"+nbsp, close),
+		lit(nbsp+"»", `"`),
+		lit("»", `"`),
+	}
+}
+
+// Normalizer normalizes punctuation like normalize-punctuation.perl. It is
+// safe for concurrent use and implements marian.Preprocessor.
+type Normalizer struct {
+	rules      []rule
+	sacremoses bool
+}
+
+// NewNormalizer creates a Normalizer for opts.
+func NewNormalizer(opts Options) *Normalizer {
+	lang := opts.Lang
+	if lang == "" {
+		lang = "en"
+	}
+
+	var rules []rule
+	rules = append(rules, extraWhitespace...)
+	if !opts.Penn {
+		rules = append(rules, notPenn...)
+	}
+	if opts.Sacremoses {
+		rules = append(rules, unicodePunct("'")...)
+		rules = append(rules, frenchQuotes(`"`, `"`)...)
+	} else {
+		rules = append(rules, unicodePunct(`"`)...)
+		rules = append(rules, frenchQuotes(` "`, `" `)...)
+	}
+	rules = append(rules, pseudoSpaces...)
+
+	switch {
+	case lang == "en":
+		rules = append(rules, enQuoteComma...)
+	case opts.Sacremoses:
+		if lang == "de" || lang == "es" || lang == "fr" {
+			rules = append(rules, deQuoteComma...)
+		}
+	case lang == "cs" || lang == "cz":
+		// Czech is confused.
+	default:
+		rules = append(rules, deQuoteComma...)
+	}
+
+	switch lang {
+	case "de", "es", "cz", "cs", "fr":
+		rules = append(rules, commaNumbers...)
+	default:
+		rules = append(rules, dotNumbers...)
+	}
+
+	return &Normalizer{rules: rules, sacremoses: opts.Sacremoses}
+}
+
+// Normalize returns text with its punctuation normalized. Like the script it
+// works line by line and keeps the line breaks.
+func (n *Normalizer) Normalize(text string) string {
+	if n.sacremoses {
+		return strings.TrimSpace(n.apply(text))
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if skipLine.MatchString(line) {
+			continue
+		}
+		lines[i] = n.apply(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Preprocess implements marian.Preprocessor.
+func (n *Normalizer) Preprocess(text string) string {
+	return n.Normalize(text)
+}
+
+func (n *Normalizer) apply(s string) string {
+	for _, ru := range n.rules {
+		s = ru.re.ReplaceAllString(s, ru.repl)
+	}
+	return s
+}
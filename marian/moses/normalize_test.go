@@ -0,0 +1,95 @@
+package moses
+
+import "testing"
+
+// The expected outputs are those of normalize-punctuation.perl run with the
+// same -l (and -penn) flags.
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		penn bool
+		in   string
+		want string
+	}{
+		{"en low-high quotes", "en", false, "He said „hello“ to me.", "He said \"hello\" to me."},
+		{"en curly quotes before comma", "en", false, "“Quoted”, he said.", "\"Quoted,\" he said."},
+		{"en period inside quotes", "en", false, "“Quoted.” Next sentence.", "\"Quoted.\" Next sentence."},
+		{"en spaced percent and brackets", "en", false, "It costs 5 % (or  less ) .", "It costs 5% (or less)."},
+		{"en spaced colon and semicolon", "en", false, "Wait : what ; now ?", "Wait: what; now ?"},
+		{"en nbsp number", "en", false, "1\u00a0000 people", "1.000 people"},
+		{"en nbsp punctuation", "en", false, "Stop\u00a0! Really\u00a0? 50\u00a0%, time\u00a0: now", "Stop! Really? 50%, time: now"},
+		{"en dashes", "en", false, "a – b — c", "a - b - c"},
+		{"en apostrophes", "en", false, "it’s John’s ’quote’", "it's John's \"quote\""},
+		{"en penn quotes", "en", false, "``Penn'' style", " \" Penn \" style"},
+		{"en penn quotes kept", "en", true, "``Penn'' style", "``Penn\" style"},
+		{"en ellipsis", "en", false, "Done…", "Done..."},
+		{"en guillemets", "en", false, "«\u00a0Bonjour\u00a0»", "\"Bonjour\""},
+		{"fr guillemets", "fr", false, "Il a dit «\u00a0bonjour\u00a0».", "Il a dit \"bonjour\"."},
+		{"fr spaced guillemets", "fr", false, "Il dit\u00a0«\u00a0oui\u00a0»\u00a0et part.", "Il dit \"oui\" et part."},
+		{"fr quote before comma", "fr", false, "“Oui,” dit-il.", "\"Oui\", dit-il."},
+		{"fr nbsp number", "fr", false, "Il a 1\u00a0000 euros.", "Il a 1,000 euros."},
+		{"fr nbsp punctuation", "fr", false, "Quoi\u00a0? Non\u00a0!", "Quoi? Non!"},
+		{"de low-high quotes", "de", false, "Er sagte: „Hallo“.", "Er sagte: \"Hallo\"."},
+		{"de quotes before comma and period", "de", false, "„Ja,“ sagte er. „Gut.“ Ende", "\"Ja\", sagte er. \"Gut\". Ende"},
+		{"de nbsp number", "de", false, "Das kostet 3\u00a0500 Euro.", "Das kostet 3,500 Euro."},
+		{"cs quotes untouched", "cs", false, "„Ano,“ řekl.", "\"Ano,\" řekl."},
+		{"ru nbsp number", "ru", false, "Это стоит 3\u00a0500 рублей.", "Это стоит 3.500 рублей."},
+		{"markup and blank lines", "en", false, "<p>“a”</p>\n\n“b”", "<p>“a”</p>\n\n\"b\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNormalizer(Options{Lang: tt.lang, Penn: tt.penn})
+			if got := n.Normalize(tt.in); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// The sacremoses variant differs from the script in the rules listed on
+// Options.Sacremoses.
+func TestNormalizeSacremoses(t *testing.T) {
+	tests := []struct {
+		lang string
+		in   string
+		want string
+	}{
+		{"en", "it’s ’quote’", `it's 'quote'`},
+		{"fr", "Il dit\u00a0«\u00a0oui\u00a0»\u00a0et part.", `Il dit"oui"et part.`},
+		{"ru", "“Да,” сказал он.", `"Да," сказал он.`},
+		{"fr", "“Oui,” dit-il.", `"Oui", dit-il.`},
+		{"en", "  “a”\n“b”  ", "\"a\"\n\"b\""},
+	}
+	for _, tt := range tests {
+		n := NewNormalizer(Options{Lang: tt.lang, Sacremoses: true})
+		if got := n.Normalize(tt.in); got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", tt.lang, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDetokenize(t *testing.T) {
+	tests := []struct {
+		lang string
+		in   string
+		want string
+	}{
+		{"en", "Hello , world !", "Hello, world!"},
+		{"en", "It costs 5 % ( or less ) .", "It costs 5% (or less)."},
+		{"en", "don ' t and it 's", "don't and it's"},
+		{"en", `He said " hello " and " bye ".`, `He said "hello" and "bye".`},
+		{"en", `An odd " quote .`, `An odd " quote.`},
+		{"en", "a \t\u00a0 b", "a b"},
+		{"en", " one .\ntwo ! ", "one.\ntwo!"},
+		{"de", "don ' t", "don ' t"},
+		{"fr", "Quoi ? Non ! Fin .", "Quoi ? Non ! Fin."},
+		{"ru", "Что ? Да !", "Что? Да!"},
+	}
+	for _, tt := range tests {
+		d := NewDetokenizer(tt.lang)
+		if got := d.Detokenize(tt.in); got != tt.want {
+			t.Errorf("%s: Detokenize(%q) = %q, want %q", tt.lang, tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package marian
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Options holds the settings collected from Option values. Open uses
+// Backends and UTF8; the backends apply the rest when loading a model, and
+// report the result in Config.
+type Options struct {
+	// Backends lists the acceptable backends in order of preference; empty
+	// means DefaultBackend.
+	Backends []string
+
+	// ModelMaxLength overrides model_max_length when positive.
+	ModelMaxLength int
+	// UnkToken is the vocabulary token unknown pieces map to; empty means
+	// "<unk>".
+	UnkToken string
+	// NoBatchEOS makes EncodeBatch encode without EOS.
+	NoBatchEOS bool
+	// Files names model files that don't follow the default layout.
+	Files Files
+	// SkipIDs, when not nil, replaces the ids Decode skips with skipSpecial
+	// (by default EOS, pad and unk).
+	SkipIDs []int64
+	// UTF8, when not nil, is the policy Open wraps the tokenizer with, see
+	// WithUTF8Policy.
+	UTF8 *UTF8Policy
+}
+
+// Option configures Open and the backends' NewTokenizer.
+type Option func(*Options)
+
+// WithBackend selects the backend by name. With several names, the first
+// one linked into the binary is used, so a program can prefer a native
+// backend and fall back to another when it is compiled out:
+//
+//	tok, err := marian.Open(dir, marian.WithBackend("v3", "v2", "v4"))
+//
+// Empty names are ignored, which lets a flag or configuration value be
+// passed through unchanged.
+func WithBackend(names ...string) Option {
+	return func(o *Options) {
+		for _, name := range names {
+			if name != "" {
+				o.Backends = append(o.Backends, name)
+			}
+		}
+	}
+}
+
+// WithModelMaxLength overrides the model_max_length of the model's
+// configuration, the length inputs are truncated to. n must be positive.
+func WithModelMaxLength(n int) Option {
+	return func(o *Options) { o.ModelMaxLength = n }
+}
+
+// WithUnkToken sets the vocabulary token that source pieces missing from the
+// vocabulary are mapped to, instead of "<unk>". The token must exist in the
+// vocabulary.
+func WithUnkToken(token string) Option {
+	return func(o *Options) { o.UnkToken = token }
+}
+
+// WithBatchEOS sets whether EncodeBatch appends EOS to every row (the
+// default).
+func WithBatchEOS(addEOS bool) Option {
+	return func(o *Options) { o.NoBatchEOS = !addEOS }
+}
+
+// WithSkipIDs sets the ids Decode removes when skipSpecial is true, instead
+// of EOS, pad and unk. With no ids, Decode skips nothing. The ids must be in
+// the target vocabulary.
+func WithSkipIDs(ids ...int64) Option {
+	return func(o *Options) { o.SkipIDs = append([]int64{}, ids...) }
+}
+
+// WithFiles names model files explicitly, for directories that don't use
+// the default file names. See Files and ResolveLayout.
+func WithFiles(f Files) Option {
+	return func(o *Options) { o.Files = f }
+}
+
+// WithUTF8Policy makes Open wrap the tokenizer with ValidUTF8(p), so
+// invalid UTF-8 in Encode and EncodeBatch input and in Decode output is
+// handled the same way by every backend. Without it the backend decides:
+// the SentencePiece backends and v4 map invalid bytes to <unk>, or to byte
+// pieces for byte-fallback models, and Decode returns byte pieces that
+// don't form valid UTF-8 as they are.
+func WithUTF8Policy(p UTF8Policy) Option {
+	return func(o *Options) { o.UTF8 = &p }
+}
+
+// ApplyOptions returns the Options set by opts.
+func ApplyOptions(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return o
+}
+
+// Validate reports options that no model can satisfy.
+func (o Options) Validate() error {
+	if o.ModelMaxLength < 0 {
+		return fmt.Errorf("marian: model max length %d is negative", o.ModelMaxLength)
+	}
+	for _, id := range o.SkipIDs {
+		if id < 0 {
+			return fmt.Errorf("marian: skip id %d is negative", id)
+		}
+	}
+	return nil
+}
+
+// UnkTokenOrDefault returns UnkToken, or "<unk>" when it is empty.
+func (o Options) UnkTokenOrDefault() string {
+	if o.UnkToken == "" {
+		return "<unk>"
+	}
+	return o.UnkToken
+}
+
+// ApplyTo sets the load-time fields of cfg from o: ModelMaxLength, NoBatchEOS
+// and SkipTokenIDs (EOS, pad and cfg.UnkTokenID unless overridden). The
+// backend resolves UnkTokenID before, -1 when the model has no unknown
+// token. It fails when a skip id is outside the target vocabulary.
+func (o Options) ApplyTo(cfg *Config) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if o.ModelMaxLength > 0 {
+		cfg.ModelMaxLength = o.ModelMaxLength
+	}
+	cfg.NoBatchEOS = o.NoBatchEOS
+
+	if o.SkipIDs == nil {
+		cfg.SkipTokenIDs = []int64{cfg.EosTokenID, cfg.PadTokenID}
+		if cfg.UnkTokenID >= 0 {
+			cfg.SkipTokenIDs = append(cfg.SkipTokenIDs, cfg.UnkTokenID)
+		}
+	} else {
+		for _, id := range o.SkipIDs {
+			if cfg.DecoderVocabSize > 0 && id >= int64(cfg.DecoderVocabSize) {
+				return fmt.Errorf("marian: skip id %d is outside the target vocabulary (size %d)", id, cfg.DecoderVocabSize)
+			}
+		}
+		cfg.SkipTokenIDs = slices.Clone(o.SkipIDs)
+	}
+	slices.Sort(cfg.SkipTokenIDs)
+	cfg.SkipTokenIDs = slices.Compact(cfg.SkipTokenIDs)
+	return nil
+}
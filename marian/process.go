@@ -0,0 +1,78 @@
+package marian
+
+// Preprocessor rewrites text before it is encoded.
+type Preprocessor interface {
+	Preprocess(text string) string
+}
+
+// Postprocessor rewrites text after it has been decoded.
+type Postprocessor interface {
+	Postprocess(text string) string
+}
+
+// PreprocessFunc adapts a function to a Preprocessor.
+type PreprocessFunc func(text string) string
+
+func (f PreprocessFunc) Preprocess(text string) string { return f(text) }
+
+// PostprocessFunc adapts a function to a Postprocessor.
+type PostprocessFunc func(text string) string
+
+func (f PostprocessFunc) Postprocess(text string) string { return f(text) }
+
+// Preprocessors chains ps into one Preprocessor that runs them in order.
+// Nil entries are skipped.
+func Preprocessors(ps ...Preprocessor) Preprocessor {
+	return PreprocessFunc(func(text string) string {
+		for _, p := range ps {
+			if p != nil {
+				text = p.Preprocess(text)
+			}
+		}
+		return text
+	})
+}
+
+// Postprocessors chains ps into one Postprocessor that runs them in order.
+// Nil entries are skipped.
+func Postprocessors(ps ...Postprocessor) Postprocessor {
+	return PostprocessFunc(func(text string) string {
+		for _, p := range ps {
+			if p != nil {
+				text = p.Postprocess(text)
+			}
+		}
+		return text
+	})
+}
+
+// Process returns a middleware that runs pre on every text passed to Encode
+// and EncodeBatch, and post on every text returned by Decode. Either may be
+// nil. Built-in processors live in package marian/textproc.
+func Process(pre Preprocessor, post Postprocessor) Middleware {
+	return func(next Tokenizer) Tokenizer {
+		var f Funcs
+		if pre != nil {
+			f.Encode = func(text string, addEOS bool) ([]int64, error) {
+				return next.Encode(pre.Preprocess(text), addEOS)
+			}
+			f.EncodeBatch = func(texts []string) ([][]int64, [][]int64, error) {
+				processed := make([]string, len(texts))
+				for i, text := range texts {
+					processed[i] = pre.Preprocess(text)
+				}
+				return next.EncodeBatch(processed)
+			}
+		}
+		if post != nil {
+			f.Decode = func(ids []int64, skipSpecial bool) (string, error) {
+				text, err := next.Decode(ids, skipSpecial)
+				if err != nil {
+					return "", err
+				}
+				return post.Postprocess(text), nil
+			}
+		}
+		return Override(next, f)
+	}
+}
@@ -0,0 +1,128 @@
+package marian
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrNoBackend is returned (wrapped) by Open when none of the requested
+// backends, or no backend at all, is linked into the binary.
+var ErrNoBackend = errors.New("marian: no tokenizer backend available")
+
+// ErrUnsupportedLayout is returned (wrapped) by backends for model
+// directories whose layout they cannot read, such as Marian-NMT models for
+// the v2 and v3 backends. Open then tries the next backend.
+var ErrUnsupportedLayout = errors.New("marian: model layout not supported by the backend")
+
+// OpenFunc creates a tokenizer for a model directory.
+type OpenFunc func(modelDir string, opts ...Option) (Tokenizer, error)
+
+type backend struct {
+	name     string
+	priority int
+	open     OpenFunc
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]backend{}
+)
+
+// Register makes a backend available to Open under name. Backends call it
+// from an init function in the files that are only built when the backend
+// works (cgo, platform, tags), so importing a backend package that is
+// compiled out registers nothing. When no backend is requested, Open uses
+// the one with the highest priority.
+//
+// Register panics if open is nil or name is already registered.
+func Register(name string, priority int, open OpenFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if open == nil {
+		panic("marian: Register of nil OpenFunc for backend " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic("marian: Register called twice for backend " + name)
+	}
+	registry[name] = backend{name: name, priority: priority, open: open}
+}
+
+// Backends returns the names of the registered backends, best first.
+func Backends() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	list := make([]backend, 0, len(registry))
+	for _, b := range registry {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].priority != list[j].priority {
+			return list[i].priority > list[j].priority
+		}
+		return list[i].name < list[j].name
+	})
+	names := make([]string, len(list))
+	for i, b := range list {
+		names[i] = b.name
+	}
+	return names
+}
+
+// DefaultBackend returns the backend Open uses when none is requested, or
+// "" when no backend is registered.
+func DefaultBackend() string {
+	if names := Backends(); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// Open creates a tokenizer for modelDir with the backend selected by
+// WithBackend, or with DefaultBackend. Without WithBackend, a backend that
+// fails with ErrUnsupportedLayout is followed by the next one in Backends
+// order. All options are passed on to the backend, and WithUTF8Policy wraps
+// the result in ValidUTF8. Backends are linked in by importing their
+// packages, for example
+//
+//	import _ "github.com/techwithsergiu/marian_tokenizer_go/marian_v2"
+func Open(modelDir string, opts ...Option) (Tokenizer, error) {
+	o := ApplyOptions(opts...)
+	names := o.Backends
+	if len(names) == 0 {
+		names = Backends()
+	}
+
+	registryMu.RLock()
+	var linked []backend
+	for _, name := range names {
+		if b, ok := registry[name]; ok {
+			linked = append(linked, b)
+		}
+	}
+	registryMu.RUnlock()
+
+	if len(linked) == 0 {
+		if len(o.Backends) == 0 {
+			return nil, ErrNoBackend
+		}
+		return nil, fmt.Errorf("%w: requested %v, linked %v", ErrNoBackend, o.Backends, Backends())
+	}
+	if len(o.Backends) > 0 {
+		linked = linked[:1]
+	}
+
+	var errs []error
+	for _, b := range linked {
+		tok, err := b.open(modelDir, opts...)
+		if err == nil && o.UTF8 != nil {
+			tok = Wrap(tok, ValidUTF8(*o.UTF8))
+		}
+		if len(o.Backends) > 0 || !errors.Is(err, ErrUnsupportedLayout) {
+			return tok, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,62 @@
+package marian
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type fakeTokenizer struct{ backend string }
+
+func (f *fakeTokenizer) Encode(string, bool) ([]int64, error)               { return nil, nil }
+func (f *fakeTokenizer) EncodeBatch([]string) ([][]int64, [][]int64, error) { return nil, nil, nil }
+func (f *fakeTokenizer) Decode([]int64, bool) (string, error)               { return "", nil }
+func (f *fakeTokenizer) Config() (*Config, error)                           { return &Config{}, nil }
+func (f *fakeTokenizer) Close()                                             {}
+
+func init() {
+	// "native" only reads dirs named "native", "broken" fails on "broken";
+	// "fallback" reads everything.
+	Register("test-native", 1000, func(dir string, _ ...Option) (Tokenizer, error) {
+		switch dir {
+		case "native":
+			return &fakeTokenizer{"test-native"}, nil
+		case "broken":
+			return nil, errors.New("broken model")
+		}
+		return nil, fmt.Errorf("%s: %w", dir, ErrUnsupportedLayout)
+	})
+	Register("test-fallback", 999, func(dir string, _ ...Option) (Tokenizer, error) {
+		return &fakeTokenizer{"test-fallback"}, nil
+	})
+}
+
+func TestOpenFallsBackOnUnsupportedLayout(t *testing.T) {
+	tests := []struct {
+		dir     string
+		opts    []Option
+		want    string
+		wantErr error
+	}{
+		{dir: "native", want: "test-native"},
+		{dir: "hf", want: "test-fallback"},
+		// Other errors are returned, not skipped.
+		{dir: "broken", wantErr: errors.New("broken model")},
+		// A named backend is not replaced by another one.
+		{dir: "hf", opts: []Option{WithBackend("test-native")}, wantErr: ErrUnsupportedLayout},
+		{dir: "hf", opts: []Option{WithBackend("test-fallback")}, want: "test-fallback"},
+	}
+	for _, tt := range tests {
+		tok, err := Open(tt.dir, tt.opts...)
+		switch {
+		case tt.wantErr != nil:
+			if err == nil || !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error() {
+				t.Errorf("Open(%q, %d options) = %v, want error %v", tt.dir, len(tt.opts), err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("Open(%q, %d options): %v", tt.dir, len(tt.opts), err)
+		case tok.(*fakeTokenizer).backend != tt.want:
+			t.Errorf("Open(%q, %d options) used %s, want %s", tt.dir, len(tt.opts), tok.(*fakeTokenizer).backend, tt.want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package marian
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReloadOptions configures a Reloadable.
+type ReloadOptions struct {
+	// Open creates the tokenizers, Open (the registry) when nil.
+	Open OpenFunc
+	// Options are passed to Open for every load.
+	Options []Option
+	// Interval is how often the model directory is polled for changes.
+	// Zero disables polling; the tokenizer is then only reloaded by Reload.
+	Interval time.Duration
+	// Validate checks a newly loaded tokenizer before it is swapped in.
+	// By default its config is read and a short sentence is encoded and
+	// decoded.
+	Validate func(Tokenizer) error
+	// OnReload, if set, is called after every reload started by polling,
+	// with nil on success. It runs on the polling goroutine.
+	OnReload func(err error)
+}
+
+// Reloadable is a Tokenizer for a model directory that can be reloaded
+// while it is in use, so updated vocabularies and SentencePiece models are
+// picked up without restarting the process.
+//
+// A reload loads and validates a new tokenizer next to the current one and
+// swaps it in atomically; calls in progress finish on the old tokenizer,
+// which is closed once they have returned. If loading or validation fails,
+// the current tokenizer stays in use. Config returns the current
+// tokenizer's configuration, which may change with a reload.
+//
+// With ReloadOptions.Interval set, the files the tokenizer loads (see
+// ModelFiles) are polled: a change of their sizes or modification times that persists for
+// one interval (so files still being copied are not loaded) triggers a
+// reload, unless the checksum of their contents is unchanged.
+type Reloadable struct {
+	dir  string
+	opts ReloadOptions
+
+	cur atomic.Pointer[loaded]
+
+	mu     sync.Mutex // serializes reloads and Close
+	stat   [sha256.Size]byte
+	sum    [sha256.Size]byte
+	closed bool
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// loaded is one tokenizer generation with its own in-flight calls.
+type loaded struct {
+	tok  Tokenizer
+	life Lifecycle
+	gen  uint64
+}
+
+// Ensure Reloadable satisfies the common interface and the optional ones.
+var (
+	_ Tokenizer        = (*Reloadable)(nil)
+	_ BytesEncoder     = (*Reloadable)(nil)
+	_ IntoEncoder      = (*Reloadable)(nil)
+	_ BatchIntoEncoder = (*Reloadable)(nil)
+)
+
+// NewReloadable loads the tokenizer for modelDir and, with opts.Interval
+// set, starts polling the directory for changes. Close stops polling and
+// closes the current tokenizer.
+func NewReloadable(modelDir string, opts ReloadOptions) (*Reloadable, error) {
+	if opts.Open == nil {
+		opts.Open = Open
+	}
+	if opts.Validate == nil {
+		opts.Validate = validateTokenizer
+	}
+	if opts.Interval < 0 {
+		return nil, fmt.Errorf("marian: negative reload interval %v", opts.Interval)
+	}
+
+	r := &Reloadable{dir: modelDir, opts: opts}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if opts.Interval > 0 {
+		r.stop = make(chan struct{})
+		r.done = make(chan struct{})
+		go r.poll()
+	}
+	return r, nil
+}
+
+// Reload loads the model directory again and swaps the new tokenizer in,
+// whether or not the files changed. It returns after the old tokenizer has
+// been closed, or with an error and the old tokenizer still in use.
+func (r *Reloadable) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+
+	// Fingerprint before loading, so changes made meanwhile are polled again.
+	files := r.files()
+	stat := statFiles(files)
+	sum, err := sumFiles(files)
+	if err != nil {
+		return err
+	}
+
+	tok, err := r.opts.Open(r.dir, r.opts.Options...)
+	if err != nil {
+		return fmt.Errorf("reload %s: %w", r.dir, err)
+	}
+	if err := r.opts.Validate(tok); err != nil {
+		tok.Close()
+		return fmt.Errorf("reload %s: validate: %w", r.dir, err)
+	}
+
+	gen := uint64(1)
+	if cur := r.cur.Load(); cur != nil {
+		gen = cur.gen + 1
+	}
+	old := r.cur.Swap(&loaded{tok: tok, gen: gen})
+	r.stat, r.sum = stat, sum
+	if old != nil && old.life.Close() {
+		old.tok.Close()
+	}
+	return nil
+}
+
+// Generation numbers the current tokenizer: 1 for the first one, and one
+// more for every tokenizer a reload swapped in. Once it returns a number,
+// calls no longer reach tokenizers of earlier generations, so caches of
+// results, such as Cached, use it to tell them apart.
+func (r *Reloadable) Generation() uint64 {
+	return r.cur.Load().gen
+}
+
+// poll reloads the tokenizer when the model files change.
+func (r *Reloadable) poll() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	var pending [sha256.Size]byte
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		files := r.files()
+		stat := statFiles(files)
+		r.mu.Lock()
+		changed := stat != r.stat
+		r.mu.Unlock()
+		if !changed || stat != pending {
+			// Unchanged, or still changing: wait for the next tick.
+			pending = stat
+			continue
+		}
+
+		sum, err := sumFiles(files)
+		if err == nil {
+			r.mu.Lock()
+			if sum == r.sum {
+				// Touched, not modified.
+				r.stat = stat
+				r.mu.Unlock()
+				continue
+			}
+			r.mu.Unlock()
+			err = r.Reload()
+		}
+		if err != nil {
+			// Do not retry until the files change again.
+			r.mu.Lock()
+			r.stat = stat
+			r.mu.Unlock()
+		}
+		if r.opts.OnReload != nil {
+			r.opts.OnReload(err)
+		}
+	}
+}
+
+// files returns the files a reload reads, see ModelFiles. Other files in
+// the directory, such as the model weights, are not watched. It returns
+// nil when the layout cannot be resolved; Open reports why.
+func (r *Reloadable) files() []string {
+	files, _ := ModelFiles(r.dir, ApplyOptions(r.opts.Options...).Files)
+	return files
+}
+
+// statFiles hashes the names, sizes and modification times of files.
+// Missing files hash differently from present ones.
+func statFiles(files []string) [sha256.Size]byte {
+	h := sha256.New()
+	var buf [8]byte
+	for _, name := range files {
+		io.WriteString(h, name)
+		h.Write([]byte{0})
+		if fi, err := os.Stat(name); err == nil {
+			binary.LittleEndian.PutUint64(buf[:], uint64(fi.Size()))
+			h.Write(buf[:])
+			binary.LittleEndian.PutUint64(buf[:], uint64(fi.ModTime().UnixNano()))
+			h.Write(buf[:])
+		}
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// sumFiles hashes the names and contents of files. Missing files are
+// skipped; Open reports them if they are needed.
+func sumFiles(files []string) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	for _, name := range files {
+		io.WriteString(h, name)
+		h.Write([]byte{0})
+		f, err := os.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// validateTokenizer is the default ReloadOptions.Validate.
+func validateTokenizer(tok Tokenizer) error {
+	if _, err := tok.Config(); err != nil {
+		return err
+	}
+	ids, err := tok.Encode("Hello, world!", true)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return errors.New("encode returned no ids")
+	}
+	_, err = tok.Decode(ids, true)
+	return err
+}
+
+// acquire returns the current tokenizer with a call registered on it. A
+// tokenizer being swapped out refuses new calls, so acquire moves on to its
+// replacement; only the last tokenizer, closed by Close, yields ErrClosed.
+func (r *Reloadable) acquire() (*loaded, error) {
+	for {
+		l := r.cur.Load()
+		if l.life.Acquire() == nil {
+			return l, nil
+		}
+		if r.cur.Load() == l {
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Encode encodes text with the current tokenizer.
+func (r *Reloadable) Encode(text string, addEOS bool) ([]int64, error) {
+	l, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer l.life.Release()
+	return l.tok.Encode(text, addEOS)
+}
+
+// EncodeBytes encodes text with the current tokenizer, see EncodeBytes.
+func (r *Reloadable) EncodeBytes(text []byte, addEOS bool) ([]int64, error) {
+	l, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer l.life.Release()
+	return EncodeBytes(l.tok, text, addEOS)
+}
+
+// EncodeInto encodes text with the current tokenizer, see EncodeInto.
+func (r *Reloadable) EncodeInto(dst []int64, text string, addEOS bool) ([]int64, error) {
+	l, err := r.acquire()
+	if err != nil {
+		return dst, err
+	}
+	defer l.life.Release()
+	return EncodeInto(l.tok, dst, text, addEOS)
+}
+
+// EncodeBatch encodes texts with the current tokenizer.
+func (r *Reloadable) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	l, err := r.acquire()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer l.life.Release()
+	return l.tok.EncodeBatch(texts)
+}
+
+// EncodeBatchInto encodes texts with the current tokenizer, see
+// EncodeBatchInto.
+func (r *Reloadable) EncodeBatchInto(ids, mask []int64, texts []string) (int, error) {
+	l, err := r.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer l.life.Release()
+	return EncodeBatchInto(l.tok, ids, mask, texts)
+}
+
+// Decode decodes ids with the current tokenizer.
+func (r *Reloadable) Decode(ids []int64, skipSpecial bool) (string, error) {
+	l, err := r.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer l.life.Release()
+	return l.tok.Decode(ids, skipSpecial)
+}
+
+// Config returns the configuration of the current tokenizer. It must not
+// be modified by the caller.
+func (r *Reloadable) Config() (*Config, error) {
+	l, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer l.life.Release()
+	return l.tok.Config()
+}
+
+// Close stops polling and closes the current tokenizer once the calls in
+// progress have returned. Later calls and Reload fail with ErrClosed.
+func (r *Reloadable) Close() {
+	r.stopOnce.Do(func() {
+		if r.stop != nil {
+			close(r.stop)
+			<-r.done
+		}
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if l := r.cur.Load(); l.life.Close() {
+		l.tok.Close()
+	}
+}
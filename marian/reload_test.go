@@ -0,0 +1,243 @@
+package marian
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// genTokenizer encodes every text as its generation. While block is set,
+// Encode signals started and waits for block to be closed.
+type genTokenizer struct {
+	fakeTokenizer
+	gen     int64
+	closed  atomic.Int32
+	started chan struct{}
+	block   chan struct{}
+}
+
+func (g *genTokenizer) Encode(string, bool) ([]int64, error) {
+	if g.closed.Load() != 0 {
+		return nil, ErrClosed
+	}
+	if g.block != nil {
+		g.started <- struct{}{}
+		<-g.block
+	}
+	return []int64{g.gen}, nil
+}
+
+func (g *genTokenizer) Close() { g.closed.Add(1) }
+
+// genOpener is an OpenFunc that counts the tokenizers it opens.
+type genOpener struct {
+	mu   sync.Mutex
+	toks []*genTokenizer
+	// next, if set, is returned by the next open.
+	next *genTokenizer
+}
+
+func (o *genOpener) open(string, ...Option) (Tokenizer, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	tok := o.next
+	if tok == nil {
+		tok = &genTokenizer{}
+	}
+	o.next = nil
+	tok.gen = int64(len(o.toks) + 1)
+	o.toks = append(o.toks, tok)
+	return tok, nil
+}
+
+func (o *genOpener) opened() []*genTokenizer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*genTokenizer(nil), o.toks...)
+}
+
+func encodeGen(t *testing.T, tok Tokenizer) int64 {
+	t.Helper()
+	ids, err := tok.Encode("a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids[0]
+}
+
+func TestReloadableSwapInFlight(t *testing.T) {
+	o := &genOpener{next: &genTokenizer{started: make(chan struct{}), block: make(chan struct{})}}
+	r, err := NewReloadable(t.TempDir(), ReloadOptions{
+		Open:     o.open,
+		Validate: func(Tokenizer) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	first := o.opened()[0]
+
+	inflight := make(chan int64)
+	go func() {
+		ids, _ := r.Encode("a", true)
+		inflight <- ids[0]
+	}()
+	<-first.started
+
+	reloaded := make(chan error)
+	go func() { reloaded <- r.Reload() }()
+	for r.Generation() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// New calls reach the new tokenizer; the old one stays open for the
+	// call in progress, and Reload waits for it.
+	if got := encodeGen(t, r); got != 2 {
+		t.Errorf("Encode during the swap went to generation %d, want 2", got)
+	}
+	select {
+	case err := <-reloaded:
+		t.Fatalf("Reload returned (%v) before the call in progress", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if first.closed.Load() != 0 {
+		t.Fatal("old tokenizer closed during a call")
+	}
+
+	close(first.block)
+	if got := <-inflight; got != 1 {
+		t.Errorf("call in progress finished on generation %d, want 1", got)
+	}
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+	if n := first.closed.Load(); n != 1 {
+		t.Errorf("old tokenizer closed %d times, want 1", n)
+	}
+}
+
+func TestReloadableValidationFailure(t *testing.T) {
+	o := &genOpener{}
+	fail := errors.New("bad model")
+	r, err := NewReloadable(t.TempDir(), ReloadOptions{
+		Open: o.open,
+		Validate: func(tok Tokenizer) error {
+			if tok.(*genTokenizer).gen == 2 {
+				return fail
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.Reload(); !errors.Is(err, fail) {
+		t.Fatalf("Reload = %v, want the validation error", err)
+	}
+	if got := encodeGen(t, r); got != 1 {
+		t.Errorf("Encode after a failed reload went to generation %d, want 1", got)
+	}
+	toks := o.opened()
+	if toks[0].closed.Load() != 0 || toks[1].closed.Load() != 1 {
+		t.Errorf("closed: old %d, rejected %d times; want 0 and 1", toks[0].closed.Load(), toks[1].closed.Load())
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := encodeGen(t, r); got != 3 {
+		t.Errorf("Encode after the next reload went to generation %d, want 3", got)
+	}
+}
+
+func TestReloadablePolling(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokenizer.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &genOpener{}
+	reloads := make(chan error, 10)
+	r, err := NewReloadable(dir, ReloadOptions{
+		Open:     o.open,
+		Validate: func(Tokenizer) error { return nil },
+		Interval: 5 * time.Millisecond,
+		OnReload: func(err error) { reloads <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// A touch changes the modification time but not the contents.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reloads:
+		t.Fatalf("touch reloaded the tokenizer (%v)", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(path, []byte(`{"changed": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("changed file was not reloaded")
+	}
+	if got := encodeGen(t, r); got != 2 {
+		t.Errorf("Encode after the change went to generation %d, want 2", got)
+	}
+}
+
+func TestReloadableCloseRacesReload(t *testing.T) {
+	for range 20 {
+		o := &genOpener{}
+		r, err := NewReloadable(t.TempDir(), ReloadOptions{
+			Open:     o.open,
+			Validate: func(Tokenizer) error { return nil },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Go(func() {
+				for range 10 {
+					if err := r.Reload(); err != nil && !errors.Is(err, ErrClosed) {
+						t.Error(err)
+					}
+					r.Encode("a", true)
+				}
+			})
+		}
+		wg.Go(r.Close)
+		wg.Wait()
+
+		// Every tokenizer opened was closed exactly once.
+		for _, tok := range o.opened() {
+			if n := tok.closed.Load(); n != 1 {
+				t.Fatalf("generation %d closed %d times, want 1", tok.gen, n)
+			}
+		}
+		if err := r.Reload(); !errors.Is(err, ErrClosed) {
+			t.Errorf("Reload after Close = %v, want ErrClosed", err)
+		}
+		if _, err := r.Encode("a", true); !errors.Is(err, ErrClosed) {
+			t.Errorf("Encode after Close = %v, want ErrClosed", err)
+		}
+	}
+}
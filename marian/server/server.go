@@ -0,0 +1,292 @@
+// Package server exposes a marian.Tokenizer as a JSON-over-HTTP service.
+//
+// Endpoints:
+//
+//	POST /encode        {"text": "...", "add_eos": true}        -> {"ids": [...]}
+//	POST /encode_batch  {"texts": ["...", ...]}                  -> {"input_ids": [[...]], "attention_mask": [[...]]}
+//	POST /decode        {"ids": [...], "skip_special": true}     -> {"text": "..."}
+//	POST /decode_batch  {"ids": [[...], ...], "skip_special": true} -> {"texts": ["...", ...]}
+//	GET  /config                                                -> marian.Config
+//	GET  /healthz                                               -> 200 while the process is alive
+//	GET  /readyz                                                -> 200 when ready, 503 otherwise
+//
+// Errors are returned as {"error": "..."}: 400 for a malformed request, 413
+// for one over the size limits, 422 for input the tokenizer rejects (ids
+// outside the vocabulary, invalid UTF-8 under the error policy), 503 once
+// the tokenizer is closed and 500 for any other failure.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Options limits the size of accepted requests. Zero values use the defaults.
+type Options struct {
+	// MaxBodyBytes is the maximum request body size (default 1 MiB).
+	MaxBodyBytes int64
+	// MaxBatchSize is the maximum number of texts or id sequences in a
+	// batch request (default 256).
+	MaxBatchSize int
+}
+
+const (
+	defaultMaxBodyBytes = 1 << 20
+	defaultMaxBatchSize = 256
+)
+
+// Server is an http.Handler serving tokenization requests.
+type Server struct {
+	tok   marian.Tokenizer
+	opts  Options
+	mux   *http.ServeMux
+	ready atomic.Bool
+}
+
+// New creates a Server for tok. The server starts out ready.
+func New(tok marian.Tokenizer, opts Options) *Server {
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if opts.MaxBatchSize <= 0 {
+		opts.MaxBatchSize = defaultMaxBatchSize
+	}
+
+	s := &Server{tok: tok, opts: opts, mux: http.NewServeMux()}
+	s.ready.Store(true)
+
+	s.mux.HandleFunc("POST /encode", s.handleEncode)
+	s.mux.HandleFunc("POST /encode_batch", s.handleEncodeBatch)
+	s.mux.HandleFunc("POST /decode", s.handleDecode)
+	s.mux.HandleFunc("POST /decode_batch", s.handleDecodeBatch)
+	s.mux.HandleFunc("GET /config", s.handleConfig)
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	s.mux.HandleFunc("GET /readyz", s.handleReady)
+	return s
+}
+
+// SetReady changes what /readyz reports. Call SetReady(false) before a
+// graceful shutdown so that load balancers stop routing new requests.
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type encodeRequest struct {
+	Text   string `json:"text"`
+	AddEOS *bool  `json:"add_eos"`
+}
+
+type encodeResponse struct {
+	IDs []int64 `json:"ids"`
+}
+
+type encodeBatchRequest struct {
+	Texts []string `json:"texts"`
+}
+
+type encodeBatchResponse struct {
+	InputIDs      [][]int64 `json:"input_ids"`
+	AttentionMask [][]int64 `json:"attention_mask"`
+}
+
+type decodeRequest struct {
+	IDs         []int64 `json:"ids"`
+	SkipSpecial *bool   `json:"skip_special"`
+}
+
+type decodeResponse struct {
+	Text string `json:"text"`
+}
+
+type decodeBatchRequest struct {
+	IDs         [][]int64 `json:"ids"`
+	SkipSpecial *bool     `json:"skip_special"`
+}
+
+type decodeBatchResponse struct {
+	Texts []string `json:"texts"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// boolOr returns *p, or def when the field was omitted.
+func boolOr(p *bool, def bool) bool {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+func (s *Server) handleEncode(w http.ResponseWriter, r *http.Request) {
+	var req encodeRequest
+	if !s.decodeBody(w, r, &req) {
+		return
+	}
+	ids, err := s.tok.Encode(req.Text, boolOr(req.AddEOS, true))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, encodeResponse{IDs: ids})
+}
+
+func (s *Server) handleEncodeBatch(w http.ResponseWriter, r *http.Request) {
+	var req encodeBatchRequest
+	if !s.decodeBody(w, r, &req) || !s.checkBatch(w, len(req.Texts)) {
+		return
+	}
+	ids, mask, err := s.tok.EncodeBatch(req.Texts)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, encodeBatchResponse{InputIDs: ids, AttentionMask: mask})
+}
+
+func (s *Server) handleDecode(w http.ResponseWriter, r *http.Request) {
+	var req decodeRequest
+	if !s.decodeBody(w, r, &req) || !s.checkIDs(w, "ids", req.IDs) {
+		return
+	}
+	text, err := s.tok.Decode(req.IDs, boolOr(req.SkipSpecial, true))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, decodeResponse{Text: text})
+}
+
+func (s *Server) handleDecodeBatch(w http.ResponseWriter, r *http.Request) {
+	var req decodeBatchRequest
+	if !s.decodeBody(w, r, &req) || !s.checkBatch(w, len(req.IDs)) {
+		return
+	}
+	for i, ids := range req.IDs {
+		if !s.checkIDs(w, fmt.Sprintf("ids[%d]", i), ids) {
+			return
+		}
+	}
+	skip := boolOr(req.SkipSpecial, true)
+	texts := make([]string, len(req.IDs))
+	for i, ids := range req.IDs {
+		text, err := s.tok.Decode(ids, skip)
+		if err != nil {
+			writeError(w, errorStatus(err), fmt.Errorf("ids[%d]: %w", i, err))
+			return
+		}
+		texts[i] = text
+	}
+	writeJSON(w, http.StatusOK, decodeBatchResponse{Texts: texts})
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.tok.Config()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cfg)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// decodeBody parses the JSON request body into v, enforcing MaxBodyBytes.
+// On failure it writes the error response and returns false.
+func (s *Server) decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, s.opts.MaxBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge,
+				fmt.Errorf("request body exceeds %d bytes", tooLarge.Limit))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	if dec.More() {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request body: trailing data"))
+		return false
+	}
+	return true
+}
+
+// checkBatch enforces MaxBatchSize.
+func (s *Server) checkBatch(w http.ResponseWriter, n int) bool {
+	if n > s.opts.MaxBatchSize {
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("batch of %d exceeds the limit of %d", n, s.opts.MaxBatchSize))
+		return false
+	}
+	return true
+}
+
+// checkIDs rejects ids outside the decoder vocabulary, which the backends
+// would decode as <unk>. name is the request field in the error message.
+func (s *Server) checkIDs(w http.ResponseWriter, name string, ids []int64) bool {
+	cfg, err := s.tok.Config()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return false
+	}
+	for i, id := range ids {
+		if id < 0 || (cfg.DecoderVocabSize > 0 && id >= int64(cfg.DecoderVocabSize)) {
+			writeError(w, http.StatusUnprocessableEntity,
+				fmt.Errorf("%s[%d]: id %d is outside the vocabulary of %d", name, i, id, cfg.DecoderVocabSize))
+			return false
+		}
+	}
+	return true
+}
+
+// errorStatus maps a tokenizer error to the status it is reported with:
+// rejected input is the client's error, a closed tokenizer is unavailable
+// and anything else is an internal failure.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, marian.ErrInvalidUTF8):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, marian.ErrInputTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, marian.ErrClosed):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
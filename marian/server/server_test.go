@@ -0,0 +1,178 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/server"
+)
+
+// vocab is the vocabulary of fakeTokenizer. "\xff" decodes to invalid
+// UTF-8.
+var vocab = []string{"</s>", "<unk>", "<pad>", "hello", "world", "\xff"}
+
+// fakeTokenizer encodes one id per space-separated word.
+type fakeTokenizer struct {
+	closed atomic.Bool
+}
+
+func (f *fakeTokenizer) Encode(text string, addEOS bool) ([]int64, error) {
+	if f.closed.Load() {
+		return nil, marian.ErrClosed
+	}
+	var ids []int64
+	for _, word := range strings.Fields(text) {
+		id := slices.Index(vocab, word)
+		if id < 0 {
+			id = 1
+		}
+		ids = append(ids, int64(id))
+	}
+	if addEOS {
+		ids = append(ids, 0)
+	}
+	return ids, nil
+}
+
+func (f *fakeTokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	rows := make([][]int64, len(texts))
+	for i, text := range texts {
+		ids, err := f.Encode(text, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows[i] = ids
+	}
+	ids, mask := marian.PadBatch(rows, 2)
+	return ids, mask, nil
+}
+
+func (f *fakeTokenizer) Decode(ids []int64, skipSpecial bool) (string, error) {
+	if f.closed.Load() {
+		return "", marian.ErrClosed
+	}
+	var words []string
+	for _, id := range ids {
+		if skipSpecial && id < 3 {
+			continue
+		}
+		words = append(words, vocab[id])
+	}
+	return strings.Join(words, " "), nil
+}
+
+func (f *fakeTokenizer) Config() (*marian.Config, error) {
+	return &marian.Config{DecoderVocabSize: len(vocab)}, nil
+}
+
+func (f *fakeTokenizer) Close() { f.closed.Store(true) }
+
+func newServer(opts server.Options) (*server.Server, *fakeTokenizer) {
+	tok := &fakeTokenizer{}
+	return server.New(marian.Wrap(tok, marian.ValidUTF8(marian.UTF8Error)), opts), tok
+}
+
+func post(t *testing.T, h http.Handler, path, body string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("POST %s %s: response %q: %v", path, body, rec.Body, err)
+	}
+	return rec.Code, resp
+}
+
+func TestServer(t *testing.T) {
+	s, _ := newServer(server.Options{MaxBodyBytes: 256, MaxBatchSize: 2})
+	tests := []struct {
+		path, body string
+		status     int
+		want       string // JSON of the response without "error"
+	}{
+		{"/encode", `{"text": "hello world"}`, 200, `{"ids":[3,4,0]}`},
+		{"/encode", `{"text": "hello", "add_eos": false}`, 200, `{"ids":[3]}`},
+		{"/encode_batch", `{"texts": ["hello world", "x"]}`, 200, `{"attention_mask":[[1,1,1],[1,1,0]],"input_ids":[[3,4,0],[1,0,2]]}`},
+		{"/decode", `{"ids": [3, 4, 0]}`, 200, `{"text":"hello world"}`},
+		{"/decode", `{"ids": [3, 0], "skip_special": false}`, 200, `{"text":"hello </s>"}`},
+		{"/decode_batch", `{"ids": [[3], [4, 0]]}`, 200, `{"texts":["hello","world"]}`},
+
+		// Malformed requests.
+		{"/encode", `{"text": `, 400, `{}`},
+		{"/encode", `{"txt": "hello"}`, 400, `{}`},
+		{"/encode", `{"text": "a"} {}`, 400, `{}`},
+		{"/decode", `{"ids": "3"}`, 400, `{}`},
+
+		// Over the limits.
+		{"/encode", `{"text": "` + strings.Repeat("hello ", 50) + `"}`, 413, `{}`},
+		{"/encode_batch", `{"texts": ["a", "b", "c"]}`, 413, `{}`},
+
+		// Input the tokenizer rejects.
+		{"/decode", `{"ids": [3, 6]}`, 422, `{}`},
+		{"/decode", `{"ids": [-1]}`, 422, `{}`},
+		{"/decode_batch", `{"ids": [[3], [99]]}`, 422, `{}`},
+		{"/decode", `{"ids": [5]}`, 422, `{}`},
+	}
+	for _, tt := range tests {
+		status, resp := post(t, s, tt.path, tt.body)
+		if status != tt.status {
+			t.Errorf("POST %s %.40s: status %d (%v), want %d", tt.path, tt.body, status, resp["error"], tt.status)
+		}
+		if tt.status != 200 {
+			if resp["error"] == nil {
+				t.Errorf("POST %s %.40s: no error message", tt.path, tt.body)
+			}
+			delete(resp, "error")
+		}
+		var got strings.Builder
+		enc := json.NewEncoder(&got)
+		enc.SetEscapeHTML(false)
+		enc.Encode(resp)
+		if strings.TrimSpace(got.String()) != tt.want {
+			t.Errorf("POST %s %.40s = %s, want %s", tt.path, tt.body, got.String(), tt.want)
+		}
+	}
+}
+
+func TestServerClosed(t *testing.T) {
+	s, tok := newServer(server.Options{})
+	tok.Close()
+	for path, body := range map[string]string{
+		"/encode":       `{"text": "hello"}`,
+		"/encode_batch": `{"texts": ["hello"]}`,
+		"/decode":       `{"ids": [3]}`,
+		"/decode_batch": `{"ids": [[3]]}`,
+	} {
+		if status, resp := post(t, s, path, body); status != http.StatusServiceUnavailable {
+			t.Errorf("POST %s after Close: status %d (%v), want 503", path, status, resp["error"])
+		}
+	}
+}
+
+func TestServerReady(t *testing.T) {
+	s, _ := newServer(server.Options{})
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	if got := get("/readyz"); got != 200 {
+		t.Errorf("/readyz = %d, want 200", got)
+	}
+	s.SetReady(false)
+	if got := get("/readyz"); got != 503 {
+		t.Errorf("/readyz after SetReady(false) = %d, want 503", got)
+	}
+	if got := get("/healthz"); got != 200 {
+		t.Errorf("/healthz = %d, want 200", got)
+	}
+	if got := get("/config"); got != 200 {
+		t.Errorf("/config = %d, want 200", got)
+	}
+}
@@ -0,0 +1,263 @@
+// Package stdio serves a marian.Tokenizer over a line-delimited JSON-RPC
+// protocol, so that a parent process in any language can spawn the
+// tokenizer once and stream requests to it over stdin/stdout.
+//
+// Every input line holds one request object, or a JSON array of request
+// objects (a batch). Every input line produces exactly one output line: a
+// response object, or an array of responses in request order.
+//
+//	-> {"id": 1, "method": "encode", "params": {"text": "Привет", "add_eos": true}}
+//	<- {"jsonrpc": "2.0", "id": 1, "result": {"ids": [...]}}
+//
+//	-> [{"id": 2, "method": "decode", "params": {"ids": [...]}}, {"id": 3, "method": "config"}]
+//	<- [{"jsonrpc": "2.0", "id": 2, "result": {"text": "..."}}, {"jsonrpc": "2.0", "id": 3, "result": {...}}]
+//
+// Methods mirror marian.Tokenizer:
+//
+//	encode        {"text": "...", "add_eos": true}          -> {"ids": [...]}
+//	encode_batch  {"texts": ["...", ...]}                    -> {"input_ids": [[...]], "attention_mask": [[...]]}
+//	decode        {"ids": [...], "skip_special": true}       -> {"text": "..."}
+//	decode_batch  {"ids": [[...], ...], "skip_special": true} -> {"texts": ["...", ...]}
+//	config        {}                                         -> marian.Config
+//
+// add_eos and skip_special default to true. The "jsonrpc" member of requests
+// is optional; the id is echoed back as-is (null when missing). Failures are
+// reported as {"error": {"code": ..., "message": "..."}} using the JSON-RPC
+// 2.0 error codes below.
+package stdio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Error codes, as defined by JSON-RPC 2.0.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	// CodeTokenizerError reports an error returned by the tokenizer.
+	CodeTokenizerError = -32000
+)
+
+// maxLineBytes bounds a single request line.
+const maxLineBytes = 64 * 1024 * 1024
+
+// Request is a single protocol request.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a single protocol response. Exactly one of Result and Error
+// is set.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a protocol error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+type encodeParams struct {
+	Text   string `json:"text"`
+	AddEOS *bool  `json:"add_eos"`
+}
+
+type encodeResult struct {
+	IDs []int64 `json:"ids"`
+}
+
+type encodeBatchParams struct {
+	Texts []string `json:"texts"`
+}
+
+type encodeBatchResult struct {
+	InputIDs      [][]int64 `json:"input_ids"`
+	AttentionMask [][]int64 `json:"attention_mask"`
+}
+
+type decodeParams struct {
+	IDs         []int64 `json:"ids"`
+	SkipSpecial *bool   `json:"skip_special"`
+}
+
+type decodeResult struct {
+	Text string `json:"text"`
+}
+
+type decodeBatchParams struct {
+	IDs         [][]int64 `json:"ids"`
+	SkipSpecial *bool     `json:"skip_special"`
+}
+
+type decodeBatchResult struct {
+	Texts []string `json:"texts"`
+}
+
+// Serve reads requests from r and writes responses to w until r reaches
+// EOF. It returns nil on EOF and the first read or write error otherwise.
+// Requests are handled one at a time, in order.
+func Serve(tok marian.Tokenizer, r io.Reader, w io.Writer) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxLineBytes)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := enc.Encode(HandleLine(tok, line)); err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// HandleLine handles one protocol line (a request or a batch of requests)
+// and returns the value to write back: a *Response or a []*Response.
+func HandleLine(tok marian.Tokenizer, line []byte) any {
+	if line[0] != '[' {
+		var req Request
+		if err := json.Unmarshal(line, &req); err != nil {
+			return errorResponse(nil, CodeParseError, err.Error())
+		}
+		return Handle(tok, &req)
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(line, &batch); err != nil {
+		return errorResponse(nil, CodeParseError, err.Error())
+	}
+	if len(batch) == 0 {
+		return errorResponse(nil, CodeInvalidRequest, "empty batch")
+	}
+	out := make([]*Response, len(batch))
+	for i, raw := range batch {
+		var req Request
+		if err := json.Unmarshal(raw, &req); err != nil {
+			out[i] = errorResponse(nil, CodeInvalidRequest, err.Error())
+			continue
+		}
+		out[i] = Handle(tok, &req)
+	}
+	return out
+}
+
+// Handle executes a single request.
+func Handle(tok marian.Tokenizer, req *Request) *Response {
+	if req.Method == "" {
+		return errorResponse(req.ID, CodeInvalidRequest, "missing method")
+	}
+
+	var (
+		result any
+		err    error
+	)
+	switch req.Method {
+	case "encode":
+		var p encodeParams
+		if perr := parseParams(req.Params, &p); perr != nil {
+			return errorResponse(req.ID, CodeInvalidParams, perr.Error())
+		}
+		var ids []int64
+		ids, err = tok.Encode(p.Text, boolOr(p.AddEOS, true))
+		result = encodeResult{IDs: ids}
+
+	case "encode_batch":
+		var p encodeBatchParams
+		if perr := parseParams(req.Params, &p); perr != nil {
+			return errorResponse(req.ID, CodeInvalidParams, perr.Error())
+		}
+		var ids, mask [][]int64
+		ids, mask, err = tok.EncodeBatch(p.Texts)
+		result = encodeBatchResult{InputIDs: ids, AttentionMask: mask}
+
+	case "decode":
+		var p decodeParams
+		if perr := parseParams(req.Params, &p); perr != nil {
+			return errorResponse(req.ID, CodeInvalidParams, perr.Error())
+		}
+		var text string
+		text, err = tok.Decode(p.IDs, boolOr(p.SkipSpecial, true))
+		result = decodeResult{Text: text}
+
+	case "decode_batch":
+		var p decodeBatchParams
+		if perr := parseParams(req.Params, &p); perr != nil {
+			return errorResponse(req.ID, CodeInvalidParams, perr.Error())
+		}
+		skip := boolOr(p.SkipSpecial, true)
+		texts := make([]string, len(p.IDs))
+		for i, ids := range p.IDs {
+			if texts[i], err = tok.Decode(ids, skip); err != nil {
+				err = fmt.Errorf("ids[%d]: %w", i, err)
+				break
+			}
+		}
+		result = decodeBatchResult{Texts: texts}
+
+	case "config":
+		result, err = tok.Config()
+
+	default:
+		return errorResponse(req.ID, CodeMethodNotFound, fmt.Sprintf("unknown method %q", req.Method))
+	}
+
+	if err != nil {
+		return errorResponse(req.ID, CodeTokenizerError, err.Error())
+	}
+	return &Response{JSONRPC: "2.0", ID: idOrNull(req.ID), Result: result}
+}
+
+// parseParams decodes params into v. Missing params leave v at its zero value.
+func parseParams(params json.RawMessage, v any) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func boolOr(p *bool, def bool) bool {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *Response {
+	return &Response{JSONRPC: "2.0", ID: idOrNull(id), Error: &Error{Code: code, Message: msg}}
+}
@@ -0,0 +1,177 @@
+package stdio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// fakeTokenizer encodes every rune as its code point and EOS as 0; texts
+// and ids containing "!" (33) fail.
+type fakeTokenizer struct{}
+
+func (fakeTokenizer) Encode(text string, addEOS bool) ([]int64, error) {
+	var ids []int64
+	for _, r := range text {
+		if r == '!' {
+			return nil, errors.New("bad text")
+		}
+		ids = append(ids, int64(r))
+	}
+	if addEOS {
+		ids = append(ids, 0)
+	}
+	return ids, nil
+}
+
+func (f fakeTokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	rows := make([][]int64, len(texts))
+	for i, text := range texts {
+		var err error
+		if rows[i], err = f.Encode(text, true); err != nil {
+			return nil, nil, err
+		}
+	}
+	ids, mask := marian.PadBatch(rows, 9)
+	return ids, mask, nil
+}
+
+func (fakeTokenizer) Decode(ids []int64, skipSpecial bool) (string, error) {
+	var b strings.Builder
+	for _, id := range ids {
+		switch {
+		case id == '!':
+			return "", errors.New("bad id")
+		case id == 0 && skipSpecial:
+		case id == 0:
+			b.WriteString("</s>")
+		default:
+			b.WriteRune(rune(id))
+		}
+	}
+	return b.String(), nil
+}
+
+func (fakeTokenizer) Config() (*marian.Config, error) {
+	return &marian.Config{PadTokenID: 9, ModelMaxLength: 512}, nil
+}
+
+func (fakeTokenizer) Close() {}
+
+func TestHandleLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"encode", `{"jsonrpc": "2.0", "id": 1, "method": "encode", "params": {"text": "ab"}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"ids":[97,98,0]}}`},
+		{"encode without eos", `{"id": 1, "method": "encode", "params": {"text": "ab", "add_eos": false}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"ids":[97,98]}}`},
+		{"string id", `{"id": "req-7", "method": "encode", "params": {"text": "a"}}`,
+			`{"jsonrpc":"2.0","id":"req-7","result":{"ids":[97,0]}}`},
+		{"object id", `{"id": {"n": [1, 2]}, "method": "encode", "params": {"text": "a"}}`,
+			`{"jsonrpc":"2.0","id":{"n":[1,2]},"result":{"ids":[97,0]}}`},
+		{"missing id", `{"method": "encode", "params": {"text": "a"}}`,
+			`{"jsonrpc":"2.0","id":null,"result":{"ids":[97,0]}}`},
+		{"null params", `{"id": 1, "method": "encode", "params": null}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"ids":[0]}}`},
+		{"encode_batch", `{"id": 2, "method": "encode_batch", "params": {"texts": ["a", "bc"]}}`,
+			`{"jsonrpc":"2.0","id":2,"result":{"input_ids":[[97,0,9],[98,99,0]],"attention_mask":[[1,1,0],[1,1,1]]}}`},
+		{"decode", `{"id": 3, "method": "decode", "params": {"ids": [104, 105, 0]}}`,
+			`{"jsonrpc":"2.0","id":3,"result":{"text":"hi"}}`},
+		{"decode with specials", `{"id": 3, "method": "decode", "params": {"ids": [104, 0], "skip_special": false}}`,
+			`{"jsonrpc":"2.0","id":3,"result":{"text":"h</s>"}}`},
+		{"decode_batch", `{"id": 4, "method": "decode_batch", "params": {"ids": [[104], [105, 0]]}}`,
+			`{"jsonrpc":"2.0","id":4,"result":{"texts":["h","i"]}}`},
+		{"config", `{"id": 5, "method": "config"}`,
+			`{"jsonrpc":"2.0","id":5,"result":` + configJSON(t) + `}`},
+
+		{"batch", `[{"id": 1, "method": "encode", "params": {"text": "a"}}, {"id": 2, "method": "nope"}, 7]`,
+			`[{"jsonrpc":"2.0","id":1,"result":{"ids":[97,0]}},` +
+				`{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"unknown method \"nope\""}},` +
+				`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"json: cannot unmarshal number into Go value of type stdio.Request"}}]`},
+		{"empty batch", `[]`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"empty batch"}}`},
+
+		{"parse error", `{"id": 1, "method":`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"unexpected end of JSON input"}}`},
+		{"batch parse error", `[{"id": 1}`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"unexpected end of JSON input"}}`},
+		{"missing method", `{"id": 1}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"missing method"}}`},
+		{"unknown method", `{"id": 1, "method": "translate"}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"unknown method \"translate\""}}`},
+		{"unknown params", `{"id": 1, "method": "encode", "params": {"txt": "a"}}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"json: unknown field \"txt\""}}`},
+		{"wrong params", `{"id": 1, "method": "decode", "params": {"ids": "1 2"}}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"json: cannot unmarshal string into Go struct field decodeParams.ids of type []int64"}}`},
+		{"tokenizer error", `{"id": 1, "method": "encode", "params": {"text": "a!"}}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"bad text"}}`},
+		{"decode_batch error", `{"id": 1, "method": "decode_batch", "params": {"ids": [[104], [33]]}}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"ids[1]: bad id"}}`},
+	}
+	for _, tt := range tests {
+		got := marshal(t, HandleLine(fakeTokenizer{}, []byte(tt.line)))
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// marshal encodes v like Serve, without escaping HTML characters.
+func marshal(t *testing.T, v any) string {
+	t.Helper()
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func configJSON(t *testing.T) string {
+	cfg, _ := fakeTokenizer{}.Config()
+	return marshal(t, cfg)
+}
+
+func TestServe(t *testing.T) {
+	in := strings.Join([]string{
+		`{"id": 1, "method": "encode", "params": {"text": "<a>"}}`,
+		``,
+		`   `,
+		`[{"id": 2, "method": "decode", "params": {"ids": [104]}}]`,
+		`oops`,
+	}, "\n")
+	var out strings.Builder
+	if err := Serve(fakeTokenizer{}, strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"jsonrpc":"2.0","id":1,"result":{"ids":[60,97,62,0]}}` + "\n" +
+		`[{"jsonrpc":"2.0","id":2,"result":{"text":"h"}}]` + "\n" +
+		`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid character 'o' looking for beginning of value"}}` + "\n"
+	if out.String() != want {
+		t.Errorf("Serve wrote:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestServeErrors(t *testing.T) {
+	in := `{"id": 1, "method": "config"}` + "\n"
+	if err := Serve(fakeTokenizer{}, strings.NewReader(in), failingWriter{}); err == nil || err.Error() != "broken pipe" {
+		t.Errorf("Serve to a failing writer: %v", err)
+	}
+
+	long := fmt.Sprintf(`{"id": 1, "method": "encode", "params": {"text": "%s"}}`, strings.Repeat("a", maxLineBytes))
+	if err := Serve(fakeTokenizer{}, strings.NewReader(long), &strings.Builder{}); err == nil {
+		t.Error("Serve of a line over the limit succeeded")
+	}
+}
@@ -0,0 +1,307 @@
+// Package testmodel generates small but valid Marian model directories, so
+// that tests can run without downloading a real opus-mt model.
+//
+// A generated directory holds the same four files as a HuggingFace Marian
+// conversion:
+//
+//   - source.spm, target.spm: serialized SentencePiece unigram models whose
+//     pieces are the alphabet (with and without the "▁" word prefix) plus
+//     a few whole words; they are identical unless a target alphabet is set
+//   - vocab.json: a shared Marian vocabulary covering the pieces of both,
+//     with the special tokens at the requested ids
+//   - config.json: vocab size, special ids and max length
+//
+// The pieces and scores are deterministic, so token ids are stable across
+// runs and machines.
+package testmodel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/internal/spm"
+)
+
+// DefaultAlphabet covers lower- and uppercase Latin and Cyrillic letters,
+// digits and common punctuation.
+const DefaultAlphabet = "abcdefghijklmnopqrstuvwxyz" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"абвгдеёжзийклмнопрстуфхцчшщъыьэюя" +
+	"АБВГДЕЁЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯ" +
+	"0123456789" +
+	".,!?-:;'\"()"
+
+// LatinAlphabet covers Latin letters, digits and common punctuation: a
+// target alphabet for ru-en style models, whose target.spm has no Cyrillic.
+const LatinAlphabet = "abcdefghijklmnopqrstuvwxyz" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"0123456789" +
+	".,!?-:;'\"()"
+
+// DefaultWords are added as whole-word pieces by default.
+var DefaultWords = []string{
+	"привет", "как", "у", "тебя", "дела", "это", "тестовая", "строка", "для", "проверки",
+	"hello", "world", "how", "are", "you", "this", "is", "a", "test",
+}
+
+// Options configures the generated model. Zero values use the defaults.
+type Options struct {
+	// Alphabet lists the characters the model can encode without <unk>
+	// (default DefaultAlphabet).
+	Alphabet string
+	// Words are extra whole-word pieces (default DefaultWords). Every word
+	// must only use characters from Alphabet.
+	Words []string
+	// NoWords disables the default words, leaving only single characters.
+	NoWords bool
+
+	// TargetAlphabet lists the characters of target.spm (default Alphabet).
+	// Its pieces that source.spm lacks are added to vocab.json after the
+	// source ones, as in a Marian vocabulary built from both sides.
+	TargetAlphabet string
+	// TargetWords are the whole-word pieces of target.spm. By default they
+	// are the Words that only use characters from TargetAlphabet.
+	TargetWords []string
+
+	// EosID, UnkID and PadID are the Marian ids of </s>, <unk> and <pad> in
+	// vocab.json. Defaults follow opus-mt: </s>=0, <unk>=1, <pad>=last id.
+	EosID *int64
+	UnkID *int64
+	PadID *int64
+
+	// MaxLength is written as max_length and model_max_length (default 512).
+	MaxLength int
+}
+
+// ID returns a pointer to id, for the special id fields of Options.
+func ID(id int64) *int64 {
+	return &id
+}
+
+// Model describes a generated model.
+type Model struct {
+	Dir          string
+	Vocab        map[string]int64
+	Pieces       []string // source.spm pieces in spm id order
+	TargetPieces []string // target.spm pieces in spm id order
+	EosID        int64
+	UnkID        int64
+	PadID        int64
+	Size         int
+}
+
+// Write generates a model into dir, creating it if needed.
+func Write(dir string, opts Options) (*Model, error) {
+	alphabet := opts.Alphabet
+	if alphabet == "" {
+		alphabet = DefaultAlphabet
+	}
+	words := opts.Words
+	if words == nil && !opts.NoWords {
+		words = DefaultWords
+	}
+	maxLen := opts.MaxLength
+	if maxLen == 0 {
+		maxLen = 512
+	}
+	if maxLen < 0 {
+		return nil, fmt.Errorf("testmodel: max length %d is negative", maxLen)
+	}
+
+	model, err := newSPM(alphabet, words)
+	if err != nil {
+		return nil, err
+	}
+	target := model
+	if opts.TargetAlphabet != "" || opts.TargetWords != nil {
+		targetAlphabet := opts.TargetAlphabet
+		if targetAlphabet == "" {
+			targetAlphabet = alphabet
+		}
+		targetWords := opts.TargetWords
+		if targetWords == nil {
+			for _, w := range words {
+				if onlyChars(w, targetAlphabet) {
+					targetWords = append(targetWords, w)
+				}
+			}
+		}
+		if target, err = newSPM(targetAlphabet, targetWords); err != nil {
+			return nil, err
+		}
+	}
+
+	// Normal pieces of the vocabulary: the source ones, then those only
+	// target.spm has.
+	normal := piecesOf(model)[3:]
+	seen := map[string]bool{}
+	for _, p := range normal {
+		seen[p] = true
+	}
+	for _, p := range piecesOf(target)[3:] {
+		if !seen[p] {
+			seen[p] = true
+			normal = append(normal, p)
+		}
+	}
+
+	// Marian vocabulary: the normal pieces plus </s>, <unk> and <pad>, so
+	// with a single spm it has as many entries as the spm has pieces.
+	size := len(normal) + 3
+	eos := idOr(opts.EosID, 0)
+	unk := idOr(opts.UnkID, 1)
+	pad := idOr(opts.PadID, int64(size-1))
+	special := map[int64]string{eos: "</s>", unk: "<unk>", pad: "<pad>"}
+	if len(special) != 3 {
+		return nil, fmt.Errorf("testmodel: special ids must differ (eos=%d unk=%d pad=%d)", eos, unk, pad)
+	}
+	for id := range special {
+		if id < 0 || id >= int64(size) {
+			return nil, fmt.Errorf("testmodel: special id %d outside [0, %d)", id, size)
+		}
+	}
+
+	vocab := make(map[string]int64, size)
+	for id, tok := range special {
+		vocab[tok] = id
+	}
+	next := int64(0)
+	for _, p := range normal {
+		for special[next] != "" {
+			next++
+		}
+		vocab[p] = next
+		next++
+	}
+
+	cfg := map[string]any{
+		"vocab_size":             size,
+		"decoder_vocab_size":     size,
+		"eos_token_id":           eos,
+		"bos_token_id":           0,
+		"pad_token_id":           pad,
+		"decoder_start_token_id": pad,
+		"max_length":             maxLen,
+		"model_max_length":       maxLen,
+		"bad_words_ids":          [][]int64{{pad}},
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "source.spm"), model.Marshal(), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "target.spm"), target.Marshal(), 0o644); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(filepath.Join(dir, "vocab.json"), vocab); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(filepath.Join(dir, "config.json"), cfg); err != nil {
+		return nil, err
+	}
+
+	return &Model{
+		Dir:          dir,
+		Vocab:        vocab,
+		Pieces:       piecesOf(model),
+		TargetPieces: piecesOf(target),
+		EosID:        eos,
+		UnkID:        unk,
+		PadID:        pad,
+		Size:         size,
+	}, nil
+}
+
+// newSPM builds a SentencePiece model over alphabet and words.
+func newSPM(alphabet string, words []string) (*spm.Model, error) {
+	// SentencePiece pieces: <unk>, <s>, </s>, then the normal pieces.
+	model := &spm.Model{
+		Trainer:    spm.DefaultTrainerSpec(),
+		Normalizer: spm.DefaultNormalizerSpec(),
+	}
+	model.Normalizer.Name = "identity"
+	model.Pieces = []spm.Piece{
+		{Piece: "<unk>", Type: spm.Unknown},
+		{Piece: "<s>", Type: spm.Control},
+		{Piece: "</s>", Type: spm.Control},
+	}
+
+	seen := map[string]bool{}
+	addPiece := func(p string, score float32) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		model.Pieces = append(model.Pieces, spm.Piece{Piece: p, Score: score, Type: spm.Normal})
+	}
+
+	// Whole words score best, then word-initial characters, then
+	// characters; among equals earlier pieces win.
+	for i, w := range words {
+		for _, r := range w {
+			if !strings.ContainsRune(alphabet, r) {
+				return nil, fmt.Errorf("testmodel: word %q uses %q, which is not in the alphabet", w, r)
+			}
+		}
+		addPiece("▁"+w, -1-float32(i)*0.001)
+	}
+	addPiece("▁", -4)
+	i := 0
+	for _, r := range alphabet {
+		addPiece("▁"+string(r), -5-float32(i)*0.001)
+		addPiece(string(r), -6-float32(i)*0.001)
+		i++
+	}
+	model.Trainer.VocabSize = int32(len(model.Pieces))
+	return model, nil
+}
+
+// onlyChars reports whether every character of s is in alphabet.
+func onlyChars(s, alphabet string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune(alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func piecesOf(m *spm.Model) []string {
+	pieces := make([]string, len(m.Pieces))
+	for i, p := range m.Pieces {
+		pieces[i] = p.Piece
+	}
+	return pieces
+}
+
+// TempDir generates a model into a temporary directory that is removed when
+// the test ends, and fails the test on error.
+func TempDir(tb testing.TB, opts Options) *Model {
+	tb.Helper()
+	m, err := Write(tb.TempDir(), opts)
+	if err != nil {
+		tb.Fatalf("testmodel: %v", err)
+	}
+	return m
+}
+
+func idOr(p *int64, def int64) int64 {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+func writeJSON(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
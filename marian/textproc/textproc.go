@@ -0,0 +1,267 @@
+// Package textproc provides built-in text processors for the marian.Process
+// middleware: Unicode normalization, whitespace and control-character
+// cleanup, quote normalization and language-specific rules.
+//
+// Every processor is a Func, which is both a marian.Preprocessor and a
+// marian.Postprocessor:
+//
+//	clean := textproc.Chain(textproc.NFC(), textproc.StripControl(), textproc.CollapseWhitespace())
+//	tok = marian.Wrap(tok, marian.Process(clean, textproc.CollapseWhitespace()))
+//
+// Services that must clean text identically can share a spec string such as
+// "nfc,control,whitespace" and build the chain with Parse.
+package textproc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Func is a text processor.
+type Func func(text string) string
+
+// ensure interface implementation
+var (
+	_ marian.Preprocessor  = Func(nil)
+	_ marian.Postprocessor = Func(nil)
+)
+
+func (f Func) Preprocess(text string) string { return f(text) }
+
+func (f Func) Postprocess(text string) string { return f(text) }
+
+// Chain returns a Func that runs fs in order.
+func Chain(fs ...Func) Func {
+	return func(text string) string {
+		for _, f := range fs {
+			text = f(text)
+		}
+		return text
+	}
+}
+
+// NFC composes text to Unicode Normalization Form C, so that for example
+// "е" + U+0308 and "ё" encode to the same ids.
+func NFC() Func {
+	return norm.NFC.String
+}
+
+// NFKC applies Unicode compatibility composition: besides NFC it folds
+// full-width forms, ligatures, superscripts and similar variants.
+func NFKC() Func {
+	return norm.NFKC.String
+}
+
+// CollapseWhitespace replaces every run of Unicode white space (including
+// newlines, tabs and non-breaking spaces) with a single ASCII space and
+// trims leading and trailing space.
+func CollapseWhitespace() Func {
+	return func(text string) string {
+		return strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")
+	}
+}
+
+// StripControl removes control characters other than white space, and the
+// invisible format characters that commonly leak in from copy-paste: soft
+// hyphen, zero-width space, word joiner and the byte order mark.
+func StripControl() Func {
+	return func(text string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r == '\t' || r == '\n' || r == '\r':
+				return r
+			case unicode.IsControl(r):
+				return -1
+			case r == '\u00ad' || r == '\u200b' || r == '\u2060' || r == '\ufeff':
+				return -1
+			}
+			return r
+		}, text)
+	}
+}
+
+var quoteReplacer = strings.NewReplacer(
+	"\u201c", `"`, // “
+	"\u201d", `"`, // ”
+	"\u201e", `"`, // „
+	"\u201f", `"`, // ‟
+	"\u00ab", `"`, // «
+	"\u00bb", `"`, // »
+	"\u2033", `"`, // ″
+	"\u2018", "'", // ‘
+	"\u2019", "'", // ’
+	"\u201a", "'", // ‚
+	"\u201b", "'", // ‛
+	"\u2039", "'", // ‹
+	"\u203a", "'", // ›
+	"\u2032", "'", // ′
+	"`", "'",
+	"\u00b4", "'", // ´
+)
+
+// NormalizeQuotes maps typographic double quotes (including « and ») to '"'
+// and typographic single quotes and apostrophes to a plain apostrophe.
+func NormalizeQuotes() Func {
+	return quoteReplacer.Replace
+}
+
+var yoReplacer = strings.NewReplacer("ё", "е", "Ё", "Е")
+
+// RussianYo replaces ё with е (and Ё with Е). Russian text uses both
+// spellings interchangeably, so folding them makes "ещё" and "еще" encode
+// the same way.
+func RussianYo() Func {
+	return yoReplacer.Replace
+}
+
+var commaBelowReplacer = strings.NewReplacer("ş", "ș", "Ş", "Ș", "ţ", "ț", "Ţ", "Ț")
+
+// RomanianCommaBelow replaces s and t with cedilla (ş, ţ), which legacy
+// encodings used for Romanian, with the correct comma-below letters (ș, ț).
+func RomanianCommaBelow() Func {
+	return commaBelowReplacer.Replace
+}
+
+// UkrainianApostrophe replaces the characters typed as the Ukrainian and
+// Belarusian apostrophe (’, ʼ, ` and ´) with a plain apostrophe when they
+// stand between two letters, as in "м’ясо". Elsewhere, such as ’ closing a
+// quotation, they are kept.
+func UkrainianApostrophe() Func {
+	return func(text string) string {
+		runes := []rune(text)
+		changed := false
+		for i := 1; i+1 < len(runes); i++ {
+			switch runes[i] {
+			case '\u2019', '\u02bc', '`', '\u00b4':
+				if unicode.IsLetter(runes[i-1]) && unicode.IsLetter(runes[i+1]) {
+					runes[i] = '\''
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			return text
+		}
+		return string(runes)
+	}
+}
+
+var persianReplacer = strings.NewReplacer(
+	"\u064a", "\u06cc", // Arabic yeh -> Farsi yeh
+	"\u0649", "\u06cc", // alef maksura -> Farsi yeh
+	"\u0643", "\u06a9", // Arabic kaf -> keheh
+)
+
+// PersianLetters replaces the Arabic forms of yeh and kaf, which Arabic
+// keyboards produce, with the Persian letters ی and ک.
+func PersianLetters() Func {
+	return persianReplacer.Replace
+}
+
+// ArabicTatweel removes the tatweel (ـ, U+0640), which only stretches
+// words for justification.
+func ArabicTatweel() Func {
+	return func(text string) string {
+		return strings.ReplaceAll(text, "\u0640", "")
+	}
+}
+
+// Language returns the language-specific rules for a language code, or a
+// no-op Func when there are none:
+//
+//	ru      RussianYo
+//	ro      RomanianCommaBelow
+//	uk, be  UkrainianApostrophe
+//	fa      PersianLetters, ArabicTatweel
+//	ar      ArabicTatweel
+//
+// Only the primary subtag is used, so "ru" and "ru-RU" are equivalent.
+func Language(lang string) Func {
+	lang, _, _ = strings.Cut(strings.ToLower(lang), "-")
+	switch lang {
+	case "ru":
+		return RussianYo()
+	case "ro":
+		return RomanianCommaBelow()
+	case "uk", "be":
+		return UkrainianApostrophe()
+	case "fa":
+		return Chain(PersianLetters(), ArabicTatweel())
+	case "ar":
+		return ArabicTatweel()
+	}
+	return func(text string) string { return text }
+}
+
+var named = map[string]func() Func{
+	"nfc":        NFC,
+	"nfkc":       NFKC,
+	"whitespace": CollapseWhitespace,
+	"control":    StripControl,
+	"quotes":     NormalizeQuotes,
+	"ru-yo":      RussianYo,
+	"ro-comma":   RomanianCommaBelow,
+	"uk-apos":    UkrainianApostrophe,
+	"fa-letters": PersianLetters,
+	"ar-tatweel": ArabicTatweel,
+}
+
+// Names returns the processor names accepted by Parse, sorted.
+func Names() []string {
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse builds a chain from a comma-separated list of processor names, for
+// example "nfc,control,whitespace". An empty spec returns a nil Func, which
+// callers should treat as "no processing".
+func Parse(spec string) (Func, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	var fs []Func
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		mk, ok := named[name]
+		if !ok {
+			return nil, fmt.Errorf("textproc: unknown processor %q (known: %s)", name, strings.Join(Names(), ", "))
+		}
+		fs = append(fs, mk())
+	}
+	return Chain(fs...), nil
+}
+
+// Middleware returns a marian.Process middleware built from two Parse specs,
+// one for the text passed to Encode and EncodeBatch and one for the text
+// returned by Decode. Empty specs leave that side untouched.
+func Middleware(preSpec, postSpec string) (marian.Middleware, error) {
+	pre, err := Parse(preSpec)
+	if err != nil {
+		return nil, err
+	}
+	post, err := Parse(postSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var p marian.Preprocessor
+	var q marian.Postprocessor
+	if pre != nil {
+		p = pre
+	}
+	if post != nil {
+		q = post
+	}
+	return marian.Process(p, q), nil
+}
@@ -0,0 +1,151 @@
+package textproc
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+func TestProcessors(t *testing.T) {
+	tests := []struct {
+		name string
+		f    Func
+		in   string
+		want string
+	}{
+		{"nfc", NFC(), "е\u0308ж", "ёж"},
+		{"nfc keeps compatibility forms", NFC(), "ﬁ２", "ﬁ２"},
+		{"nfkc", NFKC(), "ﬁ２ x²", "fi2 x2"},
+		{"whitespace", CollapseWhitespace(), " \ta  b\n\nc\r\n ", "a b c"},
+		{"whitespace empty", CollapseWhitespace(), " \n ", ""},
+		{"control", StripControl(), "a\x00b\x1b[0m\tc\r\n\u00add\u200be\u2060f\ufeff", "ab[0m\tc\r\ndef"},
+		{"quotes", NormalizeQuotes(), "«Hi» “there” „x‟ ‘a’ ‚b‛ ‹c› `d´ 5′ 6″", `"Hi" "there" "x" 'a' 'b' 'c' 'd' 5' 6"`},
+		{"ru-yo", RussianYo(), "Ёлка ещё", "Елка еще"},
+		{"ro-comma", RomanianCommaBelow(), "Şcoală, ţară, ŞŢ", "Școală, țară, ȘȚ"},
+		{"ro-comma keeps comma below", RomanianCommaBelow(), "ș ț", "ș ț"},
+		{"uk-apos", UkrainianApostrophe(), "м’ясо, пʼять, об`єм, з´їзд", "м'ясо, п'ять, об'єм, з'їзд"},
+		{"uk-apos quotes", UkrainianApostrophe(), "‘слово’ ’", "‘слово’ ’"},
+		{"fa-letters", PersianLetters(), "كتاب علي على", "کتاب علی علی"},
+		{"ar-tatweel", ArabicTatweel(), "كـــتاب", "كتاب"},
+	}
+	for _, tt := range tests {
+		if got := tt.f(tt.in); got != tt.want {
+			t.Errorf("%s(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+		if got := tt.f.Preprocess(tt.in); got != tt.want {
+			t.Errorf("%s.Preprocess(%q) = %q", tt.name, tt.in, got)
+		}
+		if got := tt.f.Postprocess(tt.in); got != tt.want {
+			t.Errorf("%s.Postprocess(%q) = %q", tt.name, tt.in, got)
+		}
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		lang string
+		in   string
+		want string
+	}{
+		{"ru", "ещё", "еще"},
+		{"RU-ru", "ещё", "еще"},
+		{"ro", "ţară", "țară"},
+		{"uk", "м’ясо", "м'ясо"},
+		{"be", "сям’я", "сям'я"},
+		{"fa", "كـتاب", "کتاب"},
+		{"ar", "كـتاب", "كتاب"},
+		{"en", "ещё ţară м’ясо", "ещё ţară м’ясо"},
+		{"", "ещё", "ещё"},
+	}
+	for _, tt := range tests {
+		if got := Language(tt.lang)(tt.in); got != tt.want {
+			t.Errorf("Language(%q)(%q) = %q, want %q", tt.lang, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse(" nfc, control ,quotes,whitespace ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f("  «ёж»\x00  \n x "), `"ёж" x`; got != want {
+		t.Errorf("chain = %q, want %q", got, want)
+	}
+
+	if f, err := Parse("  "); f != nil || err != nil {
+		t.Errorf("Parse of an empty spec = %v, %v, want nil, nil", f, err)
+	}
+	for _, spec := range []string{"nfd", "nfc,,whitespace", "nfc,"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+
+	names := Names()
+	if !slices.IsSorted(names) || len(names) != len(named) {
+		t.Errorf("Names() = %v", names)
+	}
+	for _, name := range names {
+		if _, err := Parse(name); err != nil {
+			t.Errorf("Parse(%q): %v", name, err)
+		}
+	}
+}
+
+// echoTokenizer records the texts it encodes and decodes to decoded.
+type echoTokenizer struct {
+	texts   []string
+	decoded string
+}
+
+func (e *echoTokenizer) Encode(text string, _ bool) ([]int64, error) {
+	e.texts = append(e.texts, text)
+	return nil, nil
+}
+
+func (e *echoTokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	e.texts = append(e.texts, texts...)
+	return nil, nil, nil
+}
+
+func (e *echoTokenizer) Decode([]int64, bool) (string, error) { return e.decoded, nil }
+func (e *echoTokenizer) Config() (*marian.Config, error)      { return &marian.Config{}, nil }
+func (e *echoTokenizer) Close()                               {}
+
+func TestMiddleware(t *testing.T) {
+	next := &echoTokenizer{decoded: " «a»  b "}
+	mw, err := Middleware("quotes,ru-yo", "whitespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := marian.Wrap(next, mw)
+
+	tok.Encode("«ёж»", true)
+	tok.EncodeBatch([]string{"ещё", " x "})
+	if want := []string{`"еж"`, "еще", " x "}; !slices.Equal(next.texts, want) {
+		t.Errorf("encoded %q, want %q", next.texts, want)
+	}
+	if got, _ := tok.Decode(nil, true); got != "«a» b" {
+		t.Errorf("Decode = %q, want only whitespace collapsed", got)
+	}
+
+	// Empty specs leave both sides alone.
+	next = &echoTokenizer{decoded: " « » "}
+	mw, err = Middleware("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok = marian.Wrap(next, mw)
+	tok.Encode(" «ё» ", true)
+	if got, _ := tok.Decode(nil, true); got != " « » " || next.texts[0] != " «ё» " {
+		t.Errorf("empty specs changed the text: %q, %q", next.texts, got)
+	}
+
+	for _, specs := range [][2]string{{"bogus", ""}, {"", "bogus"}} {
+		if _, err := Middleware(specs[0], specs[1]); err == nil {
+			t.Errorf("Middleware(%q, %q) succeeded", specs[0], specs[1])
+		}
+	}
+}
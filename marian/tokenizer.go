@@ -3,15 +3,18 @@ package marian
 type Tokenizer interface {
 	// Encode encodes a single source sentence into token IDs.
 	// If addEOS is true, EOS token is appended.
+	// The whole text is encoded, including any NUL bytes.
 	Encode(text string, addEOS bool) ([]int64, error)
 
 	// EncodeBatch encodes a batch of sentences and returns:
 	//  - inputIDs: shape (batch, maxLen)
 	//  - attentionMask: shape (batch, maxLen) with 1 for tokens and 0 for padding.
+	// Every row ends with EOS unless Config().NoBatchEOS is set.
 	EncodeBatch(texts []string) (inputIDs [][]int64, attentionMask [][]int64, err error)
 
 	// Decode converts token IDs back to a target sentence.
-	// If skipSpecial is true, EOS / PAD / UNK are removed before decoding.
+	// If skipSpecial is true, Config().SkipTokenIDs (by default EOS / PAD / UNK)
+	// are removed before decoding.
 	Decode(ids []int64, skipSpecial bool) (string, error)
 
 	// Config returns the tokenizer configuration.
@@ -27,3 +30,21 @@ type Tokenizer interface {
 	// Close releases any underlying native resources (SentencePiece, Marian, etc.).
 	Close()
 }
+
+// BytesEncoder is implemented by tokenizers that can encode a byte slice
+// without first copying it into a string.
+type BytesEncoder interface {
+	// EncodeBytes is Encode for text held in a byte slice. The slice is
+	// only read during the call.
+	EncodeBytes(text []byte, addEOS bool) ([]int64, error)
+}
+
+// EncodeBytes encodes text with tok, without copying it when tok implements
+// BytesEncoder. Otherwise, for example when tok is wrapped in middleware,
+// it calls Encode(string(text), addEOS).
+func EncodeBytes(tok Tokenizer, text []byte, addEOS bool) ([]int64, error) {
+	if be, ok := tok.(BytesEncoder); ok {
+		return be.EncodeBytes(text, addEOS)
+	}
+	return tok.Encode(string(text), addEOS)
+}
@@ -0,0 +1,123 @@
+package marian
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned (wrapped) by the ValidUTF8 middleware under
+// the UTF8Error policy.
+var ErrInvalidUTF8 = errors.New("marian: invalid UTF-8")
+
+// UTF8Policy says what to do with bytes that are not valid UTF-8.
+type UTF8Policy int
+
+const (
+	// UTF8Replace replaces every invalid byte with U+FFFD.
+	UTF8Replace UTF8Policy = iota
+	// UTF8Strip removes invalid bytes.
+	UTF8Strip
+	// UTF8Error fails with an error wrapping ErrInvalidUTF8.
+	UTF8Error
+)
+
+var utf8PolicyNames = map[UTF8Policy]string{
+	UTF8Replace: "replace",
+	UTF8Strip:   "strip",
+	UTF8Error:   "error",
+}
+
+func (p UTF8Policy) String() string {
+	if name, ok := utf8PolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("UTF8Policy(%d)", int(p))
+}
+
+// ParseUTF8Policy returns the policy named "replace", "strip" or "error".
+func ParseUTF8Policy(name string) (UTF8Policy, error) {
+	for p, n := range utf8PolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown UTF-8 policy %q (want replace, strip or error)", name)
+}
+
+// Apply returns text with its invalid bytes handled according to p. Valid
+// text, including any U+FFFD it already contains, is returned unchanged.
+func (p UTF8Policy) Apply(text string) (string, error) {
+	if utf8.ValidString(text) {
+		return text, nil
+	}
+	if p == UTF8Error {
+		for i := 0; i < len(text); {
+			r, size := utf8.DecodeRuneInString(text[i:])
+			if r == utf8.RuneError && size == 1 {
+				return "", fmt.Errorf("%w at byte %d", ErrInvalidUTF8, i)
+			}
+			i += size
+		}
+	}
+
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r != utf8.RuneError || size > 1:
+			b.WriteString(text[i : i+size])
+		case p == UTF8Replace:
+			b.WriteRune(utf8.RuneError)
+		}
+		i += size
+	}
+	return b.String(), nil
+}
+
+// ValidUTF8 returns a middleware that applies policy to every text passed
+// to Encode and EncodeBatch and to every text returned by Decode, so the
+// next Tokenizer only sees, and callers only get, valid UTF-8. Without it,
+// how invalid bytes are tokenized and whether decoded byte-fallback pieces
+// form valid UTF-8 depends on the backend. WithUTF8Policy has Open apply it.
+func ValidUTF8(policy UTF8Policy) Middleware {
+	return func(next Tokenizer) Tokenizer {
+		return Override(next, Funcs{
+			Encode: func(text string, addEOS bool) ([]int64, error) {
+				text, err := policy.Apply(text)
+				if err != nil {
+					return nil, err
+				}
+				return next.Encode(text, addEOS)
+			},
+			EncodeBatch: func(texts []string) ([][]int64, [][]int64, error) {
+				var fixed []string // copy of texts, made on the first change
+				for i, text := range texts {
+					valid, err := policy.Apply(text)
+					if err != nil {
+						return nil, nil, fmt.Errorf("texts[%d]: %w", i, err)
+					}
+					if fixed == nil && valid != text {
+						fixed = append([]string(nil), texts...)
+					}
+					if fixed != nil {
+						fixed[i] = valid
+					}
+				}
+				if fixed == nil {
+					fixed = texts
+				}
+				return next.EncodeBatch(fixed)
+			},
+			Decode: func(ids []int64, skipSpecial bool) (string, error) {
+				text, err := next.Decode(ids, skipSpecial)
+				if err != nil {
+					return "", err
+				}
+				return policy.Apply(text)
+			},
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package marian
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestUTF8PolicyApply(t *testing.T) {
+	tests := []struct {
+		text    string
+		replace string
+		strip   string
+	}{
+		{"hello", "hello", "hello"},
+		{"", "", ""},
+		// Valid U+FFFD is text, not an error.
+		{"a�b", "a�b", "a�b"},
+		{"a\xffb", "a�b", "ab"},
+		{"\xff\xfe", "��", ""},
+		// A truncated sequence is one bad byte per byte.
+		{"é\xc3", "é�", "é"},
+		{"\xe2\x82x", "��x", "x"},
+	}
+	for _, tt := range tests {
+		for _, c := range []struct {
+			policy UTF8Policy
+			want   string
+		}{{UTF8Replace, tt.replace}, {UTF8Strip, tt.strip}} {
+			got, err := c.policy.Apply(tt.text)
+			if err != nil || got != c.want {
+				t.Errorf("%v.Apply(%q) = %q, %v, want %q", c.policy, tt.text, got, err, c.want)
+			}
+		}
+
+		got, err := UTF8Error.Apply(tt.text)
+		if valid := tt.replace == tt.text; valid {
+			if err != nil || got != tt.text {
+				t.Errorf("error.Apply(%q) = %q, %v", tt.text, got, err)
+			}
+		} else if !errors.Is(err, ErrInvalidUTF8) {
+			t.Errorf("error.Apply(%q) = %q, %v, want ErrInvalidUTF8", tt.text, got, err)
+		}
+	}
+
+	if _, err := UTF8Error.Apply("ab\xff"); err == nil || err.Error() != "marian: invalid UTF-8 at byte 2" {
+		t.Errorf("error.Apply: %v, want the byte offset", err)
+	}
+}
+
+func TestParseUTF8Policy(t *testing.T) {
+	for _, p := range []UTF8Policy{UTF8Replace, UTF8Strip, UTF8Error} {
+		if got, err := ParseUTF8Policy(p.String()); err != nil || got != p {
+			t.Errorf("ParseUTF8Policy(%q) = %v, %v", p.String(), got, err)
+		}
+	}
+	if _, err := ParseUTF8Policy("ignore"); err == nil {
+		t.Error("ParseUTF8Policy(ignore) succeeded")
+	}
+}
+
+// textTokenizer records the texts it encodes and decodes to decoded.
+type textTokenizer struct {
+	fakeTokenizer
+	texts   []string
+	decoded string
+}
+
+func (f *textTokenizer) Encode(text string, _ bool) ([]int64, error) {
+	f.texts = append(f.texts, text)
+	return nil, nil
+}
+
+func (f *textTokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	f.texts = append(f.texts, texts...)
+	return nil, nil, nil
+}
+
+func (f *textTokenizer) Decode([]int64, bool) (string, error) { return f.decoded, nil }
+
+func TestValidUTF8(t *testing.T) {
+	next := &textTokenizer{decoded: "ok\xff"}
+	tok := Wrap(next, ValidUTF8(UTF8Replace))
+
+	if _, err := tok.Encode("a\xff", true); err != nil {
+		t.Fatal(err)
+	}
+	batch := []string{"ok", "b\xff"}
+	if _, _, err := tok.EncodeBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a�", "ok", "b�"}; !slices.Equal(next.texts, want) {
+		t.Errorf("next got %q, want %q", next.texts, want)
+	}
+	if batch[1] != "b\xff" {
+		t.Errorf("EncodeBatch modified the caller's slice: %q", batch)
+	}
+	if got, err := tok.Decode(nil, true); err != nil || got != "ok�" {
+		t.Errorf("Decode = %q, %v, want the output filtered", got, err)
+	}
+
+	next = &textTokenizer{decoded: "\xff"}
+	tok = Wrap(next, ValidUTF8(UTF8Error))
+	if _, err := tok.Encode("a\xff", true); !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("Encode: %v, want ErrInvalidUTF8", err)
+	}
+	if _, _, err := tok.EncodeBatch([]string{"ok", "\xff"}); !errors.Is(err, ErrInvalidUTF8) || err.Error() != "texts[1]: marian: invalid UTF-8 at byte 0" {
+		t.Errorf("EncodeBatch: %v, want ErrInvalidUTF8 for texts[1]", err)
+	}
+	if len(next.texts) != 0 {
+		t.Errorf("next encoded %q after an error", next.texts)
+	}
+	if _, err := tok.Decode(nil, true); !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("Decode: %v, want ErrInvalidUTF8", err)
+	}
+}
+
+func TestOpenWithUTF8Policy(t *testing.T) {
+	tok, err := Open("hf", WithUTF8Policy(UTF8Error))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner, ok := Unwrap(tok).(*fakeTokenizer); !ok || inner.backend != "test-fallback" {
+		t.Fatalf("Open returned %T wrapping %T, want ValidUTF8 around the backend", tok, Unwrap(tok))
+	}
+	if _, err := tok.Encode("\xff", true); !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("Encode: %v, want ErrInvalidUTF8", err)
+	}
+
+	tok, err = Open("native", WithBackend("test-native"), WithUTF8Policy(UTF8Strip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Unwrap(tok).(*fakeTokenizer); !ok {
+		t.Errorf("Open with a named backend returned %T, want it wrapped", tok)
+	}
+
+	// Without the option the backend's tokenizer is returned as is.
+	if tok, err := Open("hf"); err != nil || Unwrap(tok) != nil {
+		t.Errorf("Open without a policy = %T, %v", tok, err)
+	}
+}
@@ -0,0 +1,108 @@
+package marian
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/internal/spm"
+)
+
+// Vocab is the Marian token <-> id mapping loaded from vocab.json, a
+// Marian-NMT vocab.yml or a SentencePiece model used as vocabulary.
+type Vocab struct {
+	Token2ID map[string]int64
+	ID2Token []string
+}
+
+// LoadVocab loads vocab.json from disk and builds both lookup directions.
+func LoadVocab(path string) (*Vocab, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]int64{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	return NewVocab(raw)
+}
+
+// LoadVocabYAML loads a Marian-NMT vocab.yml ("token: id" per line).
+func LoadVocabYAML(path string) (*Vocab, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := parseVocabYAML(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewVocab(raw)
+}
+
+// LoadVocabSPM loads the pieces of a SentencePiece model as a vocabulary,
+// the way Marian-NMT does when an .spm file is given as vocab: ids are the
+// SentencePiece ids.
+func LoadVocabSPM(path string) (*Vocab, error) {
+	m, err := spm.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]int64, len(m.Pieces))
+	for id, p := range m.Pieces {
+		if _, dup := raw[p.Piece]; !dup {
+			raw[p.Piece] = int64(id)
+		}
+	}
+	return NewVocab(raw)
+}
+
+// NewVocab builds a Vocab from a token -> id map.
+func NewVocab(token2id map[string]int64) (*Vocab, error) {
+	var maxID int64 = -1
+	for tok, id := range token2id {
+		if id < 0 {
+			return nil, fmt.Errorf("marian: negative id %d for token %q", id, tok)
+		}
+		if id > maxID {
+			maxID = id
+		}
+	}
+	id2token := make([]string, maxID+1)
+	for tok, id := range token2id {
+		id2token[id] = tok
+	}
+	return &Vocab{Token2ID: token2id, ID2Token: id2token}, nil
+}
+
+// Size returns the number of id slots (max id + 1).
+func (v *Vocab) Size() int {
+	return len(v.ID2Token)
+}
+
+// Token returns the token for id, or "" if the id is out of range or unused.
+func (v *Vocab) Token(id int64) string {
+	if id < 0 || int(id) >= len(v.ID2Token) {
+		return ""
+	}
+	return v.ID2Token[id]
+}
+
+// add appends token with the next free id, unless it is already present,
+// and returns its id.
+func (v *Vocab) add(token string) int64 {
+	if id, ok := v.Token2ID[token]; ok {
+		return id
+	}
+	id := int64(len(v.ID2Token))
+	v.Token2ID[token] = id
+	v.ID2Token = append(v.ID2Token, token)
+	return id
+}
+
+// ID returns the id for token and whether it is present in the vocabulary.
+func (v *Vocab) ID(token string) (int64, bool) {
+	id, ok := v.Token2ID[token]
+	return id, ok
+}
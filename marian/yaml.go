@@ -0,0 +1,232 @@
+package marian
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file holds the small YAML subset written by Marian-NMT: vocab.yml
+// ("token: id" lines) and decoder.yml (top-level scalars and lists). It is
+// not a general YAML parser.
+
+// parseVocabYAML parses a Marian vocab.yml mapping of tokens to ids.
+func parseVocabYAML(b []byte) (map[string]int64, error) {
+	vocab := map[string]int64{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(nil, 1<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimRight(sc.Text(), " \t\r")
+		if skipYAMLLine(text) {
+			continue
+		}
+
+		key, rest, err := yamlScalar(strings.TrimLeft(text, " "), ':')
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, ":") {
+			return nil, fmt.Errorf("line %d: expected \"token: id\"", line)
+		}
+		value := strings.TrimSpace(stripYAMLComment(rest[1:]))
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid id %q", line, value)
+		}
+		if _, dup := vocab[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate token %q", line, key)
+		}
+		vocab[key] = id
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return vocab, nil
+}
+
+// parseDecoderYAML parses the top-level keys of a decoder.yml. Scalars
+// become one-element slices, block ("- item") and flow ("[a, b]") lists
+// become their items. Nested mappings are ignored.
+func parseDecoderYAML(b []byte) (map[string][]string, error) {
+	doc := map[string][]string{}
+	var listKey string // key whose block list is being read
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(nil, 1<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimRight(sc.Text(), " \t\r")
+		if skipYAMLLine(text) {
+			continue
+		}
+		indented := text[0] == ' ' || text[0] == '\t'
+		trimmed := strings.TrimLeft(text, " \t")
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				continue
+			}
+			item, _, err := yamlScalar(strings.TrimLeft(trimmed[1:], " "), 0)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			doc[listKey] = append(doc[listKey], item)
+			continue
+		}
+		if indented {
+			// Part of a nested mapping, lists in it included.
+			listKey = ""
+			continue
+		}
+
+		key, rest, err := yamlScalar(trimmed, ':')
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, ":") {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line)
+		}
+		value := strings.TrimSpace(stripYAMLComment(rest[1:]))
+
+		listKey = ""
+		switch {
+		case value == "":
+			listKey = key
+			doc[key] = nil
+		case strings.HasPrefix(value, "["):
+			items, err := yamlFlowList(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			doc[key] = items
+		default:
+			v, _, err := yamlScalar(value, 0)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			doc[key] = []string{v}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func skipYAMLLine(text string) bool {
+	t := strings.TrimSpace(text)
+	return t == "" || t[0] == '#' || t == "---" || t == "..." || strings.HasPrefix(t, "%")
+}
+
+// stripYAMLComment removes a trailing " # comment" from an unquoted value.
+func stripYAMLComment(s string) string {
+	if s == "" || s[0] == '"' || s[0] == '\'' {
+		return s
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// yamlFlowList parses "[a, b, c]".
+func yamlFlowList(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated list %q", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	var items []string
+	for s != "" {
+		item, rest, err := yamlScalar(s, ',')
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		rest = strings.TrimSpace(rest)
+		s = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return items, nil
+}
+
+// yamlScalar reads a double-quoted, single-quoted or plain scalar from the
+// start of s and returns it with the unread rest of s. A plain scalar ends
+// at stop followed by a space or the end of s (stop 0 reads the whole
+// string).
+func yamlScalar(s string, stop byte) (string, string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return yamlDoubleQuoted(s)
+	case strings.HasPrefix(s, "'"):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\'' {
+				if i+1 < len(s) && s[i+1] == '\'' {
+					b.WriteByte('\'')
+					i++
+					continue
+				}
+				return b.String(), s[i+1:], nil
+			}
+			b.WriteByte(s[i])
+		}
+		return "", "", fmt.Errorf("unterminated single-quoted string %q", s)
+	}
+
+	if stop == 0 {
+		return strings.TrimSpace(s), "", nil
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == stop && (i+1 == len(s) || s[i+1] == ' ' || s[i+1] == '\t') {
+			return strings.TrimSpace(s[:i]), s[i:], nil
+		}
+	}
+	return strings.TrimSpace(s), "", nil
+}
+
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n",
+	'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': `"`,
+	'/': "/", '\\': `\`, 'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+}
+
+func yamlDoubleQuoted(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", "", fmt.Errorf("unterminated escape in %q", s)
+			}
+			i++
+			if esc, ok := yamlEscapes[s[i]]; ok {
+				b.WriteString(esc)
+				continue
+			}
+			width := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[i]]
+			if width == 0 || i+width >= len(s) {
+				return "", "", fmt.Errorf("invalid escape \\%c in %q", s[i], s)
+			}
+			code, err := strconv.ParseUint(s[i+1:i+1+width], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", "", fmt.Errorf("invalid escape \\%s in %q", s[i:i+1+width], s)
+			}
+			b.WriteRune(rune(code))
+			i += width
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated double-quoted string %q", s)
+}
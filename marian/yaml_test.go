@@ -0,0 +1,115 @@
+package marian
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseVocabYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]int64
+	}{
+		{"plain", "</s>: 0\n<unk>: 1\n▁a: 2\n", map[string]int64{"</s>": 0, "<unk>": 1, "▁a": 2}},
+		{"double quoted", `"a b": 0` + "\n" + `"\"": 1` + "\n" + `"▁x\x41\t": 2` + "\n" + `"\\": 3` + "\n" + `"": 4`,
+			map[string]int64{"a b": 0, `"`: 1, "▁xA\t": 2, `\`: 3, "": 4}},
+		{"single quoted", "'it''s': 0\n'#': 1\n'a: b': 2\n", map[string]int64{"it's": 0, "#": 1, "a: b": 2}},
+		{"colons", "a:b: 0\n::: 1\nhttp://x: 2\n", map[string]int64{"a:b": 0, "::": 1, "http://x": 2}},
+		{"comments", "# vocab\n---\na: 0 # first\n\n  # indented comment\nb: 1\n...\n", map[string]int64{"a": 0, "b": 1}},
+		{"crlf and indent", "a: 0\r\n  b: 1\r\n", map[string]int64{"a": 0, "b": 1}},
+		{"empty", "", map[string]int64{}},
+	}
+	for _, tt := range tests {
+		got, err := parseVocabYAML([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseVocabYAMLErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"a: 0\nb\n", "line 2: expected \"token: id\""},
+		{"a: x\n", `line 1: invalid id "x"`},
+		{"a: 1.5\n", `line 1: invalid id "1.5"`},
+		{"a: 0\na: 1\n", `line 2: duplicate token "a"`},
+		{"'a: 0\n", "line 1: unterminated single-quoted string"},
+		{`"a: 0`, "line 1: unterminated double-quoted string"},
+		{`"\q": 0`, `line 1: invalid escape \q`},
+		{`"\u12": 0`, `line 1: invalid escape \u`},
+		{`"\ud800": 0`, `line 1: invalid escape \ud800`},
+		{`"a" 0`, "line 1: expected \"token: id\""},
+	}
+	for _, tt := range tests {
+		_, err := parseVocabYAML([]byte(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseVocabYAML(%q): %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestParseDecoderYAML(t *testing.T) {
+	in := `# decoder.yml
+models:
+  - model.npz
+vocabs:
+  - "source 1.spm"
+  - 'source2.spm' # second source
+  -   target.spm
+beam-size: 6 # comment
+normalize: 0.6
+word-penalty: "# not a comment"
+mini-batch: [8, "1 6", 'x']
+empty: []
+nested:
+  key: value
+  list:
+    - ignored
+relative-paths: true
+- stray
+devices:
+- 0
+- 1
+`
+	got, err := parseDecoderYAML([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"models":         {"model.npz"},
+		"vocabs":         {"source 1.spm", "source2.spm", "target.spm"},
+		"beam-size":      {"6"},
+		"normalize":      {"0.6"},
+		"word-penalty":   {"# not a comment"},
+		"mini-batch":     {"8", "1 6", "x"},
+		"empty":          nil,
+		"nested":         nil,
+		"relative-paths": {"true"},
+		"devices":        {"0", "1"},
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	for _, tt := range []struct{ in, want string }{
+		{"vocabs: [a, b\n", "line 1: unterminated list"},
+		{"vocabs: ['a, b]\n", "line 1: unterminated single-quoted string"},
+		{"vocabs:\n  - \"a\n", "line 2: unterminated double-quoted string"},
+		{"beam-size 6\n", "line 1: expected \"key: value\""},
+		{"key: 'x\n", "line 1: unterminated single-quoted string"},
+	} {
+		if _, err := parseDecoderYAML([]byte(tt.in)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseDecoderYAML(%q): %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
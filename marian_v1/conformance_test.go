@@ -0,0 +1,32 @@
+//go:build linux && amd64 && cgo
+
+package marian_v1_test
+
+import (
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/conformance"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/testmodel"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v1"
+)
+
+func open(dir string) conformance.Factory {
+	return func(t *testing.T) marian.Tokenizer {
+		tok, err := marian_v1.NewTokenizer(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+}
+
+func TestConformance(t *testing.T) {
+	m := testmodel.TempDir(t, testmodel.Options{MaxLength: 64})
+	conformance.Run(t, open(m.Dir))
+}
+
+func TestConformanceTargetAlphabet(t *testing.T) {
+	m := testmodel.TempDir(t, testmodel.Options{MaxLength: 64, TargetAlphabet: testmodel.LatinAlphabet})
+	conformance.Run(t, open(m.Dir))
+}
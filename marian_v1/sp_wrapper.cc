@@ -29,6 +29,8 @@ void sp_free(sp_handle_t handle) {
 }
 
 // Encode UTF-8 text into SentencePiece internal ids.
+// If the text produces more than max_ids ids, only the first max_ids are
+// written (the caller truncates to model_max_length).
 // Returns:
 //   >= 0: number of ids written to out_ids
 //   < 0: error code
@@ -36,20 +38,40 @@ int sp_encode_as_ids(sp_handle_t handle,
         const char* text,
         int* out_ids,
         int max_ids) {
-    if (!handle || !text || !out_ids || max_ids <= 0) return -1;
+    if (!text) return -1;
+    return sp_encode_as_ids_n(handle, text, std::strlen(text), out_ids, max_ids);
+}
+
+// Like sp_encode_as_ids, but encodes text_len bytes of text, which does not
+// need to be NUL-terminated and may contain NUL bytes. text may be NULL when
+// text_len is 0.
+int sp_encode_as_ids_n(sp_handle_t handle,
+        const char* text,
+        size_t text_len,
+        int* out_ids,
+        int max_ids) {
+    if (!handle || (!text && text_len > 0) || !out_ids || max_ids <= 0) return -1;
 
     auto* sp = reinterpret_cast<SentencePieceProcessor*>(handle);
 
     std::vector<int> ids;
-    auto status = sp->Encode(std::string(text), &ids);
+    auto status = sp->Encode(text ? std::string(text, text_len) : std::string(), &ids);
     if (!status.ok()) return -2;
 
-    if ((int)ids.size() > max_ids) return -3;
+    int n = (int)ids.size();
+    if (n > max_ids) n = max_ids;
 
-    for (int i = 0; i < (int)ids.size(); ++i) {
+    for (int i = 0; i < n; ++i) {
         out_ids[i] = ids[i];
     }
-    return (int)ids.size();
+    return n;
+}
+
+// Return the number of pieces in the model, or < 0 on error.
+int sp_get_piece_size(sp_handle_t handle) {
+    if (!handle) return -1;
+    auto* sp = reinterpret_cast<SentencePieceProcessor*>(handle);
+    return sp->GetPieceSize();
 }
 
 // Convert a SentencePiece id to its piece string.
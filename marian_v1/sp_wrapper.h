@@ -1,6 +1,8 @@
 // marian_v1/sp_wrapper.h
 #pragma once
 
+#include <stddef.h>  // size_t
+
 #ifdef __cplusplus
 extern "C" {
 #endif
@@ -15,6 +17,8 @@ sp_handle_t sp_new(const char* model_path);
 void sp_free(sp_handle_t handle);
 
 // Encode UTF-8 text into SentencePiece internal ids.
+// If the text produces more than max_ids ids, only the first max_ids are
+// written (the caller truncates to model_max_length).
 // Returns:
 //   >= 0: number of ids written to out_ids
 //   < 0: error code
@@ -24,6 +28,19 @@ int sp_encode_as_ids(
         int* out_ids,
         int max_ids);
 
+// Like sp_encode_as_ids, but encodes text_len bytes of text, which does not
+// need to be NUL-terminated and may contain NUL bytes. text may be NULL when
+// text_len is 0.
+int sp_encode_as_ids_n(
+        sp_handle_t handle,
+        const char* text,
+        size_t text_len,
+        int* out_ids,
+        int max_ids);
+
+// Return the number of pieces in the model, or < 0 on error.
+int sp_get_piece_size(sp_handle_t handle);
+
 // Convert a SentencePiece id to its piece string.
 // Copies a null-terminated string into out_buf.
 // Returns:
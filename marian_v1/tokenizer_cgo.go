@@ -11,10 +11,9 @@ package marian_v1
 import "C"
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"slices"
+	"sync"
 	"unsafe"
 
 	"github.com/techwithsergiu/marian_tokenizer_go/marian"
@@ -29,108 +28,144 @@ type Tokenizer struct {
 	id2token []string
 
 	unkID int64
-}
-
-// ensure interface implementation
-var _ marian.Tokenizer = (*Tokenizer)(nil)
-
-// loadConfig loads config.json from disk, unmarshals it into Config,
-// and normalizes the result to apply default values.
-func loadConfig(path string) (marian.Config, error) {
-	var cfg marian.Config
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return cfg, err
-	}
-	if err := json.Unmarshal(b, &cfg); err != nil {
-		return cfg, err
-	}
-
-	cfg.NormalizeConfig()
 
-	return cfg, nil
+	// spmToMarian maps source SentencePiece ids to Marian ids, so Encode
+	// needs no piece strings.
+	spmToMarian []int64
+	// scratch holds *[]C.int buffers of model_max_length SentencePiece ids.
+	scratch sync.Pool
+	// life lets Close wait for calls still using the SentencePiece handles.
+	life marian.Lifecycle
 }
 
-func loadVocab(path string) (map[string]int64, []string, error) {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return nil, nil, err
-	}
-	raw := map[string]int64{}
-	if err := json.Unmarshal(b, &raw); err != nil {
-		return nil, nil, err
-	}
+// ensure interface implementation
+var (
+	_ marian.Tokenizer    = (*Tokenizer)(nil)
+	_ marian.BytesEncoder = (*Tokenizer)(nil)
+	_ marian.IntoEncoder  = (*Tokenizer)(nil)
+)
 
-	var maxID int64
-	for _, id := range raw {
-		if id > maxID {
-			maxID = id
-		}
-	}
-	id2token := make([]string, maxID+1)
-	for tok, id := range raw {
-		id2token[id] = tok
-	}
-	return raw, id2token, nil
+// Register as "v1" for marian.Open, below the Marian core backends.
+func init() {
+	marian.Register("v1", 10, NewTokenizer)
 }
 
 // NewTokenizer creates a SentencePiece-based Marian tokenizer from a model directory
 // containing: config.json, source.spm, target.spm, vocab.json.
-func NewTokenizer(modelDir string) (marian.Tokenizer, error) {
-	modelDir = filepath.Clean(modelDir)
-
-	cfg, err := loadConfig(filepath.Join(modelDir, "config.json"))
+// Marian-NMT model directories (decoder.yml + vocab.yml or .spm vocabularies)
+// are supported as well, and so are a single shared SentencePiece model and
+// other file names, see marian.Layout and marian.Files.
+func NewTokenizer(modelDir string, opts ...marian.Option) (marian.Tokenizer, error) {
+	l, err := marian.ResolveLayout(modelDir, marian.ApplyOptions(opts...).Files)
 	if err != nil {
-		return nil, fmt.Errorf("load config: %w", err)
+		return nil, err
 	}
-
-	token2id, id2token, err := loadVocab(filepath.Join(modelDir, "vocab.json"))
+	if l.TokenizerJSONOnly() {
+		return nil, fmt.Errorf("%s: %w: tokenizer.json without SentencePiece models needs the v4 backend", modelDir, marian.ErrUnsupportedLayout)
+	}
+	model, err := marian.LoadModel(modelDir, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("load vocab: %w", err)
+		return nil, err
+	}
+	cfg := model.Config
+	token2id := model.SourceVocab.Token2ID
+	id2token := model.TargetVocab.ID2Token
+
+	o := marian.ApplyOptions(opts...)
+	unkID, ok := token2id[o.UnkTokenOrDefault()]
+	switch {
+	case !ok && o.UnkToken != "":
+		return nil, fmt.Errorf("%s: unk token %q is not in the vocabulary", modelDir, o.UnkToken)
+	case !ok:
+		unkID = 1
+	}
+	cfg.UnkTokenID = unkID
+	if err := o.ApplyTo(&cfg); err != nil {
+		return nil, err
 	}
 
 	// source.spm
-	cSrc := C.CString(filepath.Join(modelDir, "source.spm"))
+	cSrc := C.CString(model.Layout.SourceSPM)
 	defer C.free(unsafe.Pointer(cSrc))
 	spSrc := C.sp_new(cSrc)
 	if spSrc == nil {
 		return nil, fmt.Errorf("sp_new(source.spm) failed")
 	}
 
-	// target.spm
-	cTgt := C.CString(filepath.Join(modelDir, "target.spm"))
-	defer C.free(unsafe.Pointer(cTgt))
-	spTgt := C.sp_new(cTgt)
-	if spTgt == nil {
+	// target.spm, unless both sides share one model
+	spTgt := spSrc
+	if model.Layout.TargetSPM != model.Layout.SourceSPM {
+		cTgt := C.CString(model.Layout.TargetSPM)
+		defer C.free(unsafe.Pointer(cTgt))
+		if spTgt = C.sp_new(cTgt); spTgt == nil {
+			C.sp_free(spSrc)
+			return nil, fmt.Errorf("sp_new(target.spm) failed")
+		}
+	}
+
+	spmToMarian, err := mapPieces(spSrc, token2id, unkID)
+	if err != nil {
 		C.sp_free(spSrc)
-		return nil, fmt.Errorf("sp_new(target.spm) failed")
+		if spTgt != spSrc {
+			C.sp_free(spTgt)
+		}
+		return nil, err
 	}
 
-	unkID, ok := token2id["<unk>"]
-	if !ok {
-		unkID = 1
+	tok := &Tokenizer{
+		spSource:    spSrc,
+		spTarget:    spTgt,
+		config:      cfg,
+		token2id:    token2id,
+		id2token:    id2token,
+		unkID:       unkID,
+		spmToMarian: spmToMarian,
 	}
+	marian.Track(tok, &tok.life, "v1", modelDir)
+	return tok, nil
+}
 
-	return &Tokenizer{
-		spSource: spSrc,
-		spTarget: spTgt,
-		config:   cfg,
-		token2id: token2id,
-		id2token: id2token,
-		unkID:    unkID,
-	}, nil
+// mapPieces returns the Marian id of every piece of sp, or unkID for pieces
+// missing from the vocabulary.
+func mapPieces(sp C.sp_handle_t, token2id map[string]int64, unkID int64) ([]int64, error) {
+	size := int(C.sp_get_piece_size(sp))
+	if size < 0 {
+		return nil, fmt.Errorf("sp_get_piece_size failed: %d", size)
+	}
+
+	ids := make([]int64, size)
+	tmp := make([]C.char, 256)
+	for i := range ids {
+		res := C.sp_id_to_piece(sp, C.int(i), &tmp[0], C.int(len(tmp)))
+		for res == -2 {
+			// Piece longer than tmp: grow and retry.
+			tmp = make([]C.char, 2*len(tmp))
+			res = C.sp_id_to_piece(sp, C.int(i), &tmp[0], C.int(len(tmp)))
+		}
+		if res < 0 {
+			return nil, fmt.Errorf("sp_id_to_piece failed for id=%d, code=%d", i, int(res))
+		}
+		if id, ok := token2id[C.GoStringN(&tmp[0], res)]; ok {
+			ids[i] = id
+		} else {
+			ids[i] = unkID
+		}
+	}
+	return ids, nil
 }
 
-// Close releases any underlying native resources (SentencePiece, Marian, etc.).
+// Close releases the SentencePiece handles once the calls in progress have
+// returned. Later calls fail with marian.ErrClosed, and a second Close does
+// nothing.
 func (t *Tokenizer) Close() {
-	if t.spSource != nil {
-		C.sp_free(t.spSource)
-		t.spSource = nil
+	if !t.life.Close() {
+		return
 	}
-	if t.spTarget != nil {
+	if t.spTarget != t.spSource {
 		C.sp_free(t.spTarget)
-		t.spTarget = nil
 	}
+	C.sp_free(t.spSource)
+	t.spSource, t.spTarget = nil, nil
 }
 
 // Config returns the tokenizer configuration.
@@ -143,73 +178,84 @@ func (t *Tokenizer) Config() (*marian.Config, error) {
 	return &t.config, nil
 }
 
-// encodeInternal is the internal implementation: SP encode -> pieces -> vocab ids.
-func (t *Tokenizer) encodeInternal(text string, addEOS bool) ([]int64, error) {
-	cText := C.CString(text)
-	defer C.free(unsafe.Pointer(cText))
-
-	maxTokens := t.config.ModelMaxLength
-	if addEOS && maxTokens > 0 {
-		maxTokens -= 1
+// encodeInto is the internal implementation: SP encode -> SP ids -> vocab ids,
+// appended to dst. Like the Marian core, the result (including EOS) is
+// truncated to model_max_length. The text is passed to SentencePiece with its
+// length and is not copied, and the SP ids go through a pooled buffer.
+func (t *Tokenizer) encodeInto(dst []int64, text *C.char, textLen C.size_t, addEOS bool) ([]int64, error) {
+	if err := t.life.Acquire(); err != nil {
+		return dst, err
 	}
+	defer t.life.Release()
 
+	maxTokens := t.config.ModelMaxLength
 	if maxTokens <= 0 {
-		return nil, fmt.Errorf("model_max_length too small")
+		return dst, fmt.Errorf("model_max_length is not positive")
 	}
 
-	buf := make([]C.int, maxTokens)
+	buf, ok := t.scratch.Get().(*[]C.int)
+	if !ok || len(*buf) < maxTokens {
+		b := make([]C.int, maxTokens)
+		buf = &b
+	}
+	defer t.scratch.Put(buf)
 
-	n := C.sp_encode_as_ids(t.spSource, cText, &buf[0], C.int(maxTokens))
+	n := int(C.sp_encode_as_ids_n(t.spSource, text, textLen, &(*buf)[0], C.int(maxTokens)))
 	if n < 0 {
-		return nil, fmt.Errorf("sp_encode_as_ids failed: %d", int(n))
+		return dst, fmt.Errorf("sp_encode_as_ids_n failed: %d", n)
 	}
 
-	// SP id -> piece
-	pieces := make([]string, int(n))
-	tmp := make([]C.char, maxTokens) // can be increased
-
-	for i := 0; i < int(n); i++ {
-		id := int(buf[i])
-
-		res := C.sp_id_to_piece(t.spSource, C.int(id), &tmp[0], C.int(len(tmp)))
-		if res < 0 {
-			return nil, fmt.Errorf("sp_id_to_piece failed for id=%d, code=%d", id, int(res))
-		}
-		pieces[i] = C.GoString(&tmp[0])
+	// SP id -> Marian id
+	total := n
+	if addEOS {
+		total++
 	}
-
-	// piece -> Marian id via vocab.json
-	ids := make([]int64, 0, len(pieces)+1)
-	for _, p := range pieces {
-		if id, ok := t.token2id[p]; ok {
-			ids = append(ids, id)
+	total = min(total, maxTokens)
+	dst = slices.Grow(dst, total)
+	for _, id := range (*buf)[:n] {
+		if int(id) >= 0 && int(id) < len(t.spmToMarian) {
+			dst = append(dst, t.spmToMarian[id])
 		} else {
-			ids = append(ids, t.unkID)
+			dst = append(dst, t.unkID)
 		}
 	}
-
-	if addEOS {
-		ids = append(ids, t.config.EosTokenID)
+	if total > n {
+		dst = append(dst, t.config.EosTokenID)
 	}
-
-	return ids, nil
+	return dst, nil
 }
 
 // Encode encodes a single source sentence into token IDs.
 // If addEOS is true, EOS token is appended.
 func (t *Tokenizer) Encode(text string, addEOS bool) ([]int64, error) {
-	return t.encodeInternal(text, addEOS)
+	return t.EncodeInto(nil, text, addEOS)
+}
+
+// EncodeBytes is Encode for text held in a byte slice; text is not copied.
+func (t *Tokenizer) EncodeBytes(text []byte, addEOS bool) ([]int64, error) {
+	return t.encodeInto(nil, (*C.char)(unsafe.Pointer(unsafe.SliceData(text))), C.size_t(len(text)), addEOS)
+}
+
+// EncodeInto is Encode that appends the ids to dst. With room for the ids in
+// dst, it does not allocate.
+func (t *Tokenizer) EncodeInto(dst []int64, text string, addEOS bool) ([]int64, error) {
+	return t.encodeInto(dst, (*C.char)(unsafe.Pointer(unsafe.StringData(text))), C.size_t(len(text)), addEOS)
 }
 
 // EncodeBatch encodes a batch of sentences and returns:
 //  - inputIDs: shape (batch, maxLen)
 //  - attentionMask: shape (batch, maxLen) with 1 for tokens and 0 for padding.
 func (t *Tokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	if err := t.life.Acquire(); err != nil {
+		return nil, nil, err
+	}
+	defer t.life.Release()
+
 	all := make([][]int64, len(texts))
 	maxUsed := 0
 
 	for i, s := range texts {
-		ids, err := t.encodeInternal(s, true)
+		ids, err := t.Encode(s, !t.config.NoBatchEOS)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -243,12 +289,18 @@ func (t *Tokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
 }
 
 // Decode converts token IDs back to a target sentence.
-// If skipSpecial is true, EOS / PAD / UNK are removed before decoding.
+// If skipSpecial is true, Config().SkipTokenIDs (by default EOS / PAD / UNK)
+// are removed before decoding.
 func (t *Tokenizer) Decode(ids []int64, skipSpecial bool) (string, error) {
+	if err := t.life.Acquire(); err != nil {
+		return "", err
+	}
+	defer t.life.Release()
+
 	if skipSpecial {
 		filtered := make([]int64, 0, len(ids))
 		for _, id := range ids {
-			if id == t.config.EosTokenID || id == t.config.PadTokenID || id == t.unkID {
+			if slices.Contains(t.config.SkipTokenIDs, id) {
 				continue
 			}
 			filtered = append(filtered, id)
@@ -290,5 +342,5 @@ func (t *Tokenizer) Decode(ids []int64, skipSpecial bool) (string, error) {
 		return "", fmt.Errorf("sp_decode_pieces failed: %d", int(n))
 	}
 
-	return C.GoString(&buf[0]), nil
+	return C.GoStringN(&buf[0], n), nil
 }
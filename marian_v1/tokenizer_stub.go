@@ -15,7 +15,7 @@ var _ marian.Tokenizer = (*Tokenizer)(nil)
 
 var ErrUnsupported = fmt.Errorf("marian_v1: tokenizer v1 is only supported on linux/amd64 with cgo")
 
-func NewTokenizer(modelDir string) (*Tokenizer, error) {
+func NewTokenizer(modelDir string, opts ...marian.Option) (*Tokenizer, error) {
 	return &Tokenizer{}, ErrUnsupported
 }
 
@@ -29,6 +29,14 @@ func (t *Tokenizer) Encode(text string, addEOS bool) ([]int64, error) {
 	return nil, ErrUnsupported
 }
 
+func (t *Tokenizer) EncodeBytes(text []byte, addEOS bool) ([]int64, error) {
+	return nil, ErrUnsupported
+}
+
+func (t *Tokenizer) EncodeInto(dst []int64, text string, addEOS bool) ([]int64, error) {
+	return dst, ErrUnsupported
+}
+
 func (t *Tokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
 	return nil, nil, ErrUnsupported
 }
@@ -15,6 +15,9 @@ import (
 	"errors"
 	"encoding/json"
 	"fmt"
+	"runtime"
+	"slices"
+	"sync"
 	"unsafe"
 
 	"github.com/techwithsergiu/marian_tokenizer_go/marian"
@@ -25,10 +28,28 @@ import (
 type Tokenizer struct {
 	h            C.marian_tok_t
 	config       marian.Config
+
+	// scratch holds *[]int64 buffers of model_max_length ids for Encode.
+	scratch sync.Pool
+	// life lets Close wait for calls still using h.
+	life marian.Lifecycle
 }
 
 // Ensure Tokenizer satisfies the common interface.
-var _ marian.Tokenizer = (*Tokenizer)(nil)
+var (
+	_ marian.Tokenizer    = (*Tokenizer)(nil)
+	_ marian.BytesEncoder = (*Tokenizer)(nil)
+
+	_ marian.IntoEncoder      = (*Tokenizer)(nil)
+	_ marian.BatchIntoEncoder = (*Tokenizer)(nil)
+)
+
+// cText returns a pointer to the bytes of s and their count for the
+// length-prefixed marian_tok_*_n functions. s is not copied, so NUL bytes
+// are passed through; C only reads it during the call.
+func cText(s string) (*C.char, C.size_t) {
+	return (*C.char)(unsafe.Pointer(unsafe.StringData(s))), C.size_t(len(s))
+}
 
 // loadConfig retrieves the raw config.json contents from the native tokenizer,
 // unmarshals it into Config, and normalizes the result to apply default values.
@@ -51,15 +72,73 @@ func loadConfig(h C.marian_tok_t) (marian.Config, error) {
 	return cfg, nil
 }
 
+// Register as "v3" for marian.Open, preferred over the other backends.
+func init() {
+	marian.Register("v3", 30, NewTokenizer)
+}
+
 // NewTokenizer creates a new Marian-core tokenizer for the given model directory.
-// The directory must contain: config.json, vocab.json, source.spm, target.spm
-func NewTokenizer(modelDir string) (marian.Tokenizer, error) {
-	cDir := C.CString(modelDir)
-	defer C.free(unsafe.Pointer(cDir))
+// The directory must contain: config.json, vocab.json, source.spm, target.spm,
+// or a single SentencePiece model shared by both sides; see marian.Files for
+// other names. The options are passed to the core through marian_tok_new_ex.
+func NewTokenizer(modelDir string, opts ...marian.Option) (marian.Tokenizer, error) {
+	o := marian.ApplyOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
 
-	h := C.marian_tok_new(cDir)
+	// The file names are resolved here, and the core gets explicit paths.
+	// It reads the HuggingFace layout only, with one vocab.json.
+	l, err := marian.ResolveLayout(modelDir, o.Files)
+	if err != nil {
+		return nil, err
+	}
+	if l.TokenizerJSONOnly() {
+		return nil, fmt.Errorf("%s: %w: tokenizer.json without SentencePiece models needs the v4 backend", modelDir, marian.ErrUnsupportedLayout)
+	}
+	if l.Native {
+		return nil, fmt.Errorf("%s: %w: Marian-NMT model directories need the v1 backend", modelDir, marian.ErrUnsupportedLayout)
+	}
+	if l.SourceVocab != l.TargetVocab {
+		return nil, fmt.Errorf("%s: %w: separate source and target vocabularies need the v1 backend", modelDir, marian.ErrUnsupportedLayout)
+	}
+
+	var copts C.marian_tok_options
+	C.marian_tok_options_init(&copts)
+	for _, f := range []struct {
+		dst  **C.char
+		path string
+	}{
+		{&copts.config_path, l.Config},
+		{&copts.vocab_path, l.SourceVocab},
+		{&copts.source_spm_path, l.SourceSPM},
+		{&copts.target_spm_path, l.TargetSPM},
+	} {
+		cPath := C.CString(f.path)
+		defer C.free(unsafe.Pointer(cPath))
+		*f.dst = cPath
+	}
+	copts.model_max_length = C.int(o.ModelMaxLength)
+	if o.UnkToken != "" {
+		cUnk := C.CString(o.UnkToken)
+		defer C.free(unsafe.Pointer(cUnk))
+		copts.unk_token = cUnk
+	}
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	if o.SkipIDs != nil {
+		copts.num_skip_ids = C.int(len(o.SkipIDs))
+		if len(o.SkipIDs) > 0 {
+			// copts is Go memory holding this pointer, so pin the ids.
+			pinner.Pin(&o.SkipIDs[0])
+			copts.skip_ids = (*C.longlong)(unsafe.Pointer(&o.SkipIDs[0]))
+		}
+	}
+
+	var code C.int
+	h := C.marian_tok_new_ex(nil, &copts, &code)
 	if h == nil {
-		return nil, fmt.Errorf("marian_tok_new failed")
+		return nil, newError(code, l, o)
 	}
 
 	ok := false
@@ -74,18 +153,40 @@ func NewTokenizer(modelDir string) (marian.Tokenizer, error) {
 		return nil, fmt.Errorf("load config: %w", err)
 	}
 
+	cfg.NoBatchEOS = o.NoBatchEOS
+
 	tok := &Tokenizer{
 		h:           h,
 		config:      cfg,
 	}
+	marian.Track(tok, &tok.life, "v3", modelDir)
 
 	ok = true
 	return tok, nil
 }
 
-// Close releases the underlying native Marian tokenizer handle.
+// newError describes a marian_tok_new_ex error code.
+func newError(code C.int, l *marian.Layout, o marian.Options) error {
+	switch code {
+	case C.MARIAN_TOK_ERR_CONFIG:
+		return fmt.Errorf("%s is missing or invalid", l.Config)
+	case C.MARIAN_TOK_ERR_VOCAB:
+		return fmt.Errorf("%s is missing or invalid", l.SourceVocab)
+	case C.MARIAN_TOK_ERR_SPM:
+		return fmt.Errorf("failed to load %s or %s", l.SourceSPM, l.TargetSPM)
+	case C.MARIAN_TOK_ERR_UNK_TOKEN:
+		return fmt.Errorf("%s: unk token %q is not in the vocabulary", l.Dir, o.UnkTokenOrDefault())
+	case C.MARIAN_TOK_ERR_SKIP_ID:
+		return fmt.Errorf("%s: skip ids %v are not all in the target vocabulary", l.Dir, o.SkipIDs)
+	}
+	return fmt.Errorf("marian_tok_new_ex failed: %d", int(code))
+}
+
+// Close releases the underlying native Marian tokenizer handle once the
+// calls in progress have returned. Later calls fail with marian.ErrClosed,
+// and a second Close does nothing.
 func (t *Tokenizer) Close() {
-	if t.h != nil {
+	if t.life.Close() {
 		C.marian_tok_free(t.h)
 		t.h = nil
 	}
@@ -101,22 +202,21 @@ func (t *Tokenizer) Config() (*marian.Config, error) {
 	return &t.config, nil
 }
 
-// encodeInternal is a small helper that calls marian_tok_encode with configurable addEOS.
-func (t *Tokenizer) encodeInternal(text string, addEOS bool) ([]int64, error) {
-	if t.h == nil {
-		return nil, fmt.Errorf("tokenizer closed")
+// encodeInto calls marian_tok_encode_n and appends the ids to dst. The core
+// writes long long ids, which have the size of int64, straight into the
+// spare capacity of dst; when they don't fit (-3), dst is grown to hold
+// model_max_length more ids and the call is repeated.
+func (t *Tokenizer) encodeInto(dst []int64, text *C.char, textLen C.size_t, addEOS bool) ([]int64, error) {
+	if err := t.life.Acquire(); err != nil {
+		return dst, err
 	}
-
-	cText := C.CString(text)
-	defer C.free(unsafe.Pointer(cText))
+	defer t.life.Release()
 
 	maxTokens := t.config.ModelMaxLength
 	if maxTokens <= 0 {
-		return nil, fmt.Errorf("model_max_length is not positive")
+		return dst, fmt.Errorf("model_max_length is not positive")
 	}
 
-	buf := make([]C.longlong, maxTokens)
-
 	var add C.int
 	if addEOS {
 		add = 1
@@ -124,115 +224,173 @@ func (t *Tokenizer) encodeInternal(text string, addEOS bool) ([]int64, error) {
 		add = 0
 	}
 
-	n := C.marian_tok_encode(
-		t.h,
-		cText,
-		&buf[0],
-		C.int(maxTokens),
-		add,
-	)
-	if n < 0 {
-		return nil, fmt.Errorf("marian_tok_encode failed: %d", int(n))
+	for {
+		spare := min(cap(dst)-len(dst), maxTokens)
+		if spare > 0 {
+			out := dst[len(dst):cap(dst)]
+			n := C.marian_tok_encode_n(
+				t.h,
+				text,
+				textLen,
+				(*C.longlong)(unsafe.Pointer(&out[0])),
+				C.int(spare),
+				add,
+			)
+			if n >= 0 {
+				return dst[:len(dst)+int(n)], nil
+			}
+			if n != -3 || spare == maxTokens {
+				return dst, fmt.Errorf("marian_tok_encode_n failed: %d", int(n))
+			}
+		}
+		dst = slices.Grow(dst, maxTokens)
 	}
+}
 
-	out := make([]int64, int(n))
-	for i := 0; i < int(n); i++ {
-		out[i] = int64(buf[i])
+// encode encodes into a pooled scratch buffer and returns an exact-size copy.
+func (t *Tokenizer) encode(text *C.char, textLen C.size_t, addEOS bool) ([]int64, error) {
+	buf, ok := t.scratch.Get().(*[]int64)
+	if !ok {
+		b := make([]int64, 0, max(t.config.ModelMaxLength, 0))
+		buf = &b
 	}
-	return out, nil
+	defer t.scratch.Put(buf)
+
+	ids, err := t.encodeInto((*buf)[:0], text, textLen, addEOS)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(ids), nil
 }
 
 // Encode encodes a single source sentence into token IDs.
 // If addEOS is true, EOS token is appended.
 func (t *Tokenizer) Encode(text string, addEOS bool) ([]int64, error) {
-	return t.encodeInternal(text, addEOS)
+	p, n := cText(text)
+	return t.encode(p, n, addEOS)
+}
+
+// EncodeBytes is Encode for text held in a byte slice; text is not copied.
+func (t *Tokenizer) EncodeBytes(text []byte, addEOS bool) ([]int64, error) {
+	return t.encode((*C.char)(unsafe.Pointer(unsafe.SliceData(text))), C.size_t(len(text)), addEOS)
+}
+
+// EncodeInto is Encode that appends the ids to dst. With room for
+// model_max_length ids in dst, or for the actual ids, it does not allocate.
+func (t *Tokenizer) EncodeInto(dst []int64, text string, addEOS bool) ([]int64, error) {
+	p, n := cText(text)
+	return t.encodeInto(dst, p, n, addEOS)
 }
 
 // EncodeBatch encodes a batch of sentences and returns:
 //   - inputIDs: shape (batch, maxLen)
 //   - attentionMask: shape (batch, maxLen) with 1 for tokens and 0 for padding.
 func (t *Tokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
-	if t.h == nil {
-		return nil, nil, fmt.Errorf("tokenizer closed")
+	if err := t.life.Acquire(); err != nil {
+		return nil, nil, err
 	}
+	defer t.life.Release()
 
 	batch := len(texts)
 	if batch == 0 {
 		return [][]int64{}, [][]int64{}, nil
 	}
 
-	// 1) Convert Go strings to C strings.
-	cTexts := make([]*C.char, batch)
-	for i, s := range texts {
-		cTexts[i] = C.CString(s)
-	}
-	defer func() {
-		for _, p := range cTexts {
-			if p != nil {
-				C.free(unsafe.Pointer(p))
-			}
-		}
-	}()
-
 	maxLen := t.config.ModelMaxLength
 	if maxLen <= 0 {
 		return nil, nil, fmt.Errorf("model_max_length is not positive")
 	}
 
-	flatIDs := make([]C.longlong, batch*maxLen)
+	flatIDs := make([]int64, batch*maxLen)
+	flatMask := make([]int64, batch*maxLen)
+	usedLen, err := t.EncodeBatchInto(flatIDs, flatMask, texts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Reshape into [batch][usedLen], backed by exact-size arrays.
+	ids := slices.Clone(flatIDs[:batch*usedLen])
+	mask := slices.Clone(flatMask[:batch*usedLen])
+	inputIDs := make([][]int64, batch)
+	attn := make([][]int64, batch)
+	for b := 0; b < batch; b++ {
+		inputIDs[b] = ids[b*usedLen : (b+1)*usedLen : (b+1)*usedLen]
+		attn[b] = mask[b*usedLen : (b+1)*usedLen : (b+1)*usedLen]
+	}
+
+	return inputIDs, attn, nil
+}
+
+// EncodeBatchInto is EncodeBatch writing into caller-owned flat buffers;
+// see marian.EncodeBatchInto for the layout. The core writes the ids
+// straight into ids, and the mask is built in Go.
+func (t *Tokenizer) EncodeBatchInto(ids, mask []int64, texts []string) (int, error) {
+	if err := t.life.Acquire(); err != nil {
+		return 0, err
+	}
+	defer t.life.Release()
+
+	batch := len(texts)
+	maxLen := t.config.ModelMaxLength
+	if err := marian.CheckBatchBuffers(ids, mask, batch, maxLen); err != nil {
+		return 0, err
+	}
+	if batch == 0 {
+		return 0, nil
+	}
+
+	// 1) Point C at the bytes of the Go strings. The pointer array is Go
+	// memory, so the strings it refers to must be pinned for the call.
+	cTexts := make([]*C.char, batch)
+	cLens := make([]C.size_t, batch)
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	for i, s := range texts {
+		cTexts[i], cLens[i] = cText(s)
+		if cTexts[i] != nil {
+			pinner.Pin(cTexts[i])
+		}
+	}
+
 	seqLens := make([]C.int, batch)
-	flatMask := make([]C.int, batch*maxLen)
 
-	// 2) Batch encode in C++ (always add EOS for batch encode).
-	maxUsed := C.marian_tok_encode_batch(
+	var add C.int
+	if !t.config.NoBatchEOS {
+		add = 1
+	}
+
+	// 2) Batch encode in C++ (EOS as configured), rows at stride maxLen.
+	maxUsed := C.marian_tok_encode_batch_n(
 		t.h,
-		(**C.char)(unsafe.Pointer(&cTexts[0])),
+		&cTexts[0],
+		&cLens[0],
 		C.int(batch),
 		C.int(maxLen),
-		&flatIDs[0],
+		(*C.longlong)(unsafe.Pointer(&ids[0])),
 		&seqLens[0],
-		1, // add_eos = 1
+		add,
 	)
 	if maxUsed < 0 {
-		return nil, nil, fmt.Errorf("marian_tok_encode_batch failed: %d", int(maxUsed))
+		return 0, fmt.Errorf("marian_tok_encode_batch_n failed: %d", int(maxUsed))
 	}
-	usedLen := int(maxUsed)
 
-	// 3) Build attention mask up to maxLen, then we’ll slice to usedLen.
-	rc := C.marian_tok_build_attention_mask(
-		&seqLens[0],
-		C.int(batch),
-		C.int(maxLen),
-		&flatMask[0],
-	)
-	if rc < 0 {
-		return nil, nil, fmt.Errorf("marian_tok_build_attention_mask failed: %d", int(rc))
+	// 3) Pack the rows to the longest one and build the attention mask.
+	lens := make([]int, batch)
+	for i, n := range seqLens {
+		lens[i] = int(n)
 	}
-
-	// 4) Reshape into [batch][usedLen].
-	inputIDs := make([][]int64, batch)
-	attn := make([][]int64, batch)
-
-	for b := 0; b < batch; b++ {
-		inputIDs[b] = make([]int64, usedLen)
-		attn[b] = make([]int64, usedLen)
-
-		rowOffset := b * maxLen
-		for j := 0; j < usedLen; j++ {
-			inputIDs[b][j] = int64(flatIDs[rowOffset+j])
-			attn[b][j] = int64(flatMask[rowOffset+j])
-		}
-	}
-
-	return inputIDs, attn, nil
+	marian.PackBatch(ids, mask, lens, maxLen, int(maxUsed), t.config.PadTokenID)
+	return int(maxUsed), nil
 }
 
 // Decode converts token IDs back to a target sentence.
-// If skipSpecial is true, EOS / PAD / UNK are removed before decoding.
+// If skipSpecial is true, Config().SkipTokenIDs (by default EOS / PAD / UNK)
+// are removed before decoding.
 func (t *Tokenizer) Decode(ids []int64, skipSpecial bool) (string, error) {
-	if t.h == nil {
-		return "", fmt.Errorf("tokenizer closed")
+	if err := t.life.Acquire(); err != nil {
+		return "", err
 	}
+	defer t.life.Release()
 
 	if len(ids) == 0 {
 		return "", nil
@@ -265,5 +423,5 @@ func (t *Tokenizer) Decode(ids []int64, skipSpecial bool) (string, error) {
 		return "", fmt.Errorf("marian_tok_decode failed: %d", int(n))
 	}
 
-	return C.GoString(&buf[0]), nil
+	return C.GoStringN(&buf[0], n), nil
 }
@@ -15,7 +15,7 @@ var _ marian.Tokenizer = (*Tokenizer)(nil)
 
 var ErrUnsupported = fmt.Errorf("marian_v3: supported only on linux/amd64 and windows/amd64 with cgo")
 
-func NewTokenizer(modelDir string) (*Tokenizer, error) {
+func NewTokenizer(modelDir string, opts ...marian.Option) (*Tokenizer, error) {
 	return &Tokenizer{}, ErrUnsupported
 }
 
@@ -29,10 +29,22 @@ func (t *Tokenizer) Encode(text string, addEOS bool) ([]int64, error) {
 	return nil, ErrUnsupported
 }
 
+func (t *Tokenizer) EncodeBytes(text []byte, addEOS bool) ([]int64, error) {
+	return nil, ErrUnsupported
+}
+
+func (t *Tokenizer) EncodeInto(dst []int64, text string, addEOS bool) ([]int64, error) {
+	return dst, ErrUnsupported
+}
+
 func (t *Tokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
 	return nil, nil, ErrUnsupported
 }
 
+func (t *Tokenizer) EncodeBatchInto(ids, mask []int64, texts []string) (int, error) {
+	return 0, ErrUnsupported
+}
+
 func (t *Tokenizer) Decode(ids []int64, skipSpecial bool) (string, error) {
 	return "", ErrUnsupported
 }
@@ -0,0 +1,127 @@
+package marian_v4_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v4"
+)
+
+// testdata/bpe is a hand-written BPE tokenizer.json with merges in both
+// encodings, byte fallback for "é" only, fused unknown characters and a
+// truncation block with max_length 8.
+const (
+	bpeUnk   = 0
+	bpeEOS   = 1
+	bpeSpace = 5 // "▁"
+)
+
+func openBPE(t *testing.T, opts ...marian.Option) marian.Tokenizer {
+	t.Helper()
+	tok, err := marian_v4.NewTokenizer("testdata/bpe", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tok.Close)
+	return tok
+}
+
+func TestBPEEncode(t *testing.T) {
+	tok := openBPE(t)
+	tests := []struct {
+		text string
+		want []int64
+	}{
+		{"hello", []int64{17}},               // ▁ h, l l, ▁h e, ll o, ▁he llo
+		{"hell", []int64{15, 14}},            // no merge for "▁he ll"
+		{"world", []int64{18, 9, 11, 8, 12}}, // ▁w o r l d
+		{"hello world", []int64{17, 18, 9, 11, 8, 12}},
+		{"é", []int64{bpeSpace, 3, 4}},    // <0xC3> <0xA9>
+		{"ß", []int64{bpeSpace, bpeUnk}},  // <0x9F> is missing
+		{"ßß", []int64{bpeSpace, bpeUnk}}, // fuse_unk
+		{"hßé", []int64{13, bpeUnk, 3, 4}},
+		{"</s>", []int64{bpeEOS}},
+	}
+	for _, tt := range tests {
+		got, err := tok.Encode(tt.text, false)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", tt.text, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Encode(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+		got, err = tok.Encode(tt.text, true)
+		if err != nil {
+			t.Fatalf("Encode(%q, true): %v", tt.text, err)
+		}
+		if want := append(slices.Clone(tt.want), bpeEOS); !slices.Equal(got, want) {
+			t.Errorf("Encode(%q, true) = %v, want %v", tt.text, got, want)
+		}
+	}
+}
+
+func TestBPEDecode(t *testing.T) {
+	tok := openBPE(t)
+	tests := []struct {
+		ids  []int64
+		skip bool
+		want string
+	}{
+		{[]int64{17, 18, 9, 11, 8, 12, bpeEOS}, false, "hello world"},
+		{[]int64{15, 14}, true, "hell"},
+		{[]int64{bpeSpace, 3, 4}, true, "é"},
+		{[]int64{17, bpeUnk, 18}, false, "hello ⁇  w"},
+		{[]int64{17, bpeUnk, 18}, true, "hello w"},
+		{[]int64{17, 2, 18}, false, "hello<pad> w"},
+		{[]int64{17, 99, 18}, true, "hello ⁇  w"},
+		{[]int64{-1, 17}, true, " ⁇  hello"},
+	}
+	for _, tt := range tests {
+		got, err := tok.Decode(tt.ids, tt.skip)
+		if err != nil {
+			t.Fatalf("Decode(%v, %v): %v", tt.ids, tt.skip, err)
+		}
+		if got != tt.want {
+			t.Errorf("Decode(%v, %v) = %q, want %q", tt.ids, tt.skip, got, tt.want)
+		}
+	}
+}
+
+func TestBPETruncation(t *testing.T) {
+	text := strings.Repeat("hello ", 10)
+	for _, tt := range []struct {
+		name   string
+		opts   []marian.Option
+		addEOS bool
+		want   int // pieces before any </s>
+	}{
+		{"eos", nil, true, 7},
+		{"no eos", nil, false, 8},
+		{"option", []marian.Option{marian.WithModelMaxLength(4)}, true, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := openBPE(t, tt.opts...).Encode(text, tt.addEOS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := slices.Repeat([]int64{17}, tt.want)
+			if tt.addEOS {
+				want = append(want, bpeEOS)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("Encode = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBPEToken(t *testing.T) {
+	tok := openBPE(t).(*marian_v4.Tokenizer)
+	for id, want := range map[int64]string{bpeUnk: "<unk>", bpeEOS: "</s>", 3: "<0xC3>", 17: "▁hello", -1: "", 1000: ""} {
+		if got := tok.Token(id); got != want {
+			t.Errorf("Token(%d) = %q, want %q", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package marian_v4
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// charsmap is a SentencePiece precompiled normalization map: a darts-clone
+// double-array trie over UTF-8 input whose values are offsets of
+// NUL-terminated replacement strings.
+type charsmap struct {
+	units      []uint32
+	normalized string
+}
+
+func parseCharsmap(blob []byte) (*charsmap, error) {
+	if len(blob) < 4 {
+		return nil, errors.New("precompiled charsmap: too short")
+	}
+	trieSize := binary.LittleEndian.Uint32(blob)
+	blob = blob[4:]
+	if uint64(trieSize) > uint64(len(blob)) || trieSize%4 != 0 {
+		return nil, errors.New("precompiled charsmap: invalid trie size")
+	}
+	units := make([]uint32, trieSize/4)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint32(blob[4*i:])
+	}
+	return &charsmap{units: units, normalized: string(blob[trieSize:])}, nil
+}
+
+// Double-array unit accessors, as in darts-clone.
+func unitHasLeaf(u uint32) bool  { return (u>>8)&1 == 1 }
+func unitValue(u uint32) uint32  { return u & (1<<31 - 1) }
+func unitLabel(u uint32) uint32  { return u & (1<<31 | 0xff) }
+func unitOffset(u uint32) uint32 { return (u >> 10) << ((u & (1 << 9)) >> 6) }
+
+// longestPrefix returns the value and length of the longest key that is a
+// prefix of s, or length 0 when there is none.
+func (c *charsmap) longestPrefix(s string) (value uint32, length int) {
+	if len(c.units) == 0 {
+		return 0, 0
+	}
+	pos := unitOffset(c.units[0])
+	for i := 0; i < len(s); i++ {
+		pos ^= uint32(s[i])
+		if int(pos) >= len(c.units) {
+			break
+		}
+		u := c.units[pos]
+		if unitLabel(u) != uint32(s[i]) {
+			break
+		}
+		pos ^= unitOffset(u)
+		if unitHasLeaf(u) && int(pos) < len(c.units) {
+			value, length = unitValue(c.units[pos]), i+1
+		}
+	}
+	return value, length
+}
+
+// normalize rewrites s like SentencePiece's normalizer does before it
+// handles white space: at every position the longest matching key is
+// replaced, other characters are copied and invalid bytes become U+FFFD.
+func (c *charsmap) normalize(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for len(s) > 0 {
+		if value, n := c.longestPrefix(s); n > 0 {
+			rest := c.normalized[min(int(value), len(c.normalized)):]
+			if end := strings.IndexByte(rest, 0); end >= 0 {
+				rest = rest[:end]
+			}
+			b.WriteString(rest)
+			s = s[n:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			b.WriteRune(utf8.RuneError)
+		} else {
+			b.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	return b.String()
+}
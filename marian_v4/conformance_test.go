@@ -0,0 +1,42 @@
+package marian_v4_test
+
+import (
+	"testing"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/conformance"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/export"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian/testmodel"
+	"github.com/techwithsergiu/marian_tokenizer_go/marian_v4"
+)
+
+// modelDir generates a model and exports its tokenizer.json next to it.
+func modelDir(t *testing.T, opts testmodel.Options) string {
+	m := testmodel.TempDir(t, opts)
+	model, err := marian.LoadModel(m.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := export.HFTokenizerFile(model, m.Dir); err != nil {
+		t.Fatal(err)
+	}
+	return m.Dir
+}
+
+func open(dir string) conformance.Factory {
+	return func(t *testing.T) marian.Tokenizer {
+		tok, err := marian_v4.NewTokenizer(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+}
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, open(modelDir(t, testmodel.Options{MaxLength: 64})))
+}
+
+func TestConformanceTargetAlphabet(t *testing.T) {
+	conformance.Run(t, open(modelDir(t, testmodel.Options{MaxLength: 64, TargetAlphabet: testmodel.LatinAlphabet})))
+}
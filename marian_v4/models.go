@@ -0,0 +1,377 @@
+package marian_v4
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// model tokenizes one pre-tokenized word into vocabulary ids.
+type model interface {
+	tokenize(word string) ([]int64, error)
+	// tokens returns the vocabulary in id order.
+	tokens() []string
+}
+
+func parseModel(raw json.RawMessage) (model, error) {
+	typ, err := componentType(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "Unigram":
+		return parseUnigram(raw)
+	case "BPE":
+		return parseBPE(raw)
+	case "":
+		// Old files omit the type; a merges list identifies BPE.
+		var v struct {
+			Merges json.RawMessage `json:"merges"`
+		}
+		if err := json.Unmarshal(raw, &v); err == nil && !isNull(v.Merges) {
+			return parseBPE(raw)
+		}
+		return parseUnigram(raw)
+	}
+	return nil, fmt.Errorf("unsupported model %q", typ)
+}
+
+// unkPenalty is subtracted from the lowest piece score to score unknown
+// characters, as in SentencePiece.
+const unkPenalty = 10
+
+// unigram is a SentencePiece unigram model: the tokenization with the
+// highest total piece score wins.
+type unigram struct {
+	pieces       []string
+	scores       []float64
+	ids          map[string]int64
+	unkID        int64 // -1 when the model has no unknown token
+	unkScore     float64
+	byteFallback bool
+	maxPieceLen  int // in bytes
+}
+
+func parseUnigram(raw json.RawMessage) (*unigram, error) {
+	var v struct {
+		Vocab        [][2]json.RawMessage `json:"vocab"`
+		UnkID        *int64               `json:"unk_id"`
+		ByteFallback bool                 `json:"byte_fallback"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("Unigram: %w", err)
+	}
+
+	m := &unigram{
+		pieces:       make([]string, len(v.Vocab)),
+		scores:       make([]float64, len(v.Vocab)),
+		ids:          make(map[string]int64, len(v.Vocab)),
+		unkID:        -1,
+		byteFallback: v.ByteFallback,
+	}
+	minScore := math.Inf(1)
+	for i, entry := range v.Vocab {
+		if err := json.Unmarshal(entry[0], &m.pieces[i]); err != nil {
+			return nil, fmt.Errorf("Unigram: vocab entry %d: %w", i, err)
+		}
+		if err := json.Unmarshal(entry[1], &m.scores[i]); err != nil {
+			return nil, fmt.Errorf("Unigram: vocab entry %d: %w", i, err)
+		}
+		piece := m.pieces[i]
+		if _, dup := m.ids[piece]; !dup {
+			m.ids[piece] = int64(i)
+		}
+		minScore = min(minScore, m.scores[i])
+		m.maxPieceLen = max(m.maxPieceLen, len(piece))
+	}
+	if v.UnkID != nil {
+		if *v.UnkID < 0 || *v.UnkID >= int64(len(m.pieces)) {
+			return nil, fmt.Errorf("Unigram: unk_id %d outside the vocabulary", *v.UnkID)
+		}
+		m.unkID = *v.UnkID
+	}
+	m.unkScore = minScore - unkPenalty
+	return m, nil
+}
+
+func (m *unigram) tokens() []string { return m.pieces }
+
+// tokenize runs Viterbi over the byte positions of word. Characters no
+// piece covers become unknown tokens; adjacent unknowns are fused.
+func (m *unigram) tokenize(word string) ([]int64, error) {
+	if word == "" {
+		return nil, nil
+	}
+
+	type node struct {
+		score float64
+		start int   // start of the last piece
+		id    int64 // id of the last piece, -1 for unknown
+		ok    bool
+	}
+	best := make([]node, len(word)+1)
+	best[0].ok = true
+
+	for start := 0; start < len(word); {
+		_, size := utf8.DecodeRuneInString(word[start:])
+		if !best[start].ok {
+			start += size
+			continue
+		}
+		base := best[start].score
+		relax := func(end int, id int64, score float64) {
+			if s := base + score; !best[end].ok || s > best[end].score {
+				best[end] = node{score: s, start: start, id: id, ok: true}
+			}
+		}
+
+		single := false
+		for end := start + size; end <= len(word) && end-start <= m.maxPieceLen; {
+			if id, ok := m.ids[word[start:end]]; ok {
+				relax(end, id, m.scores[id])
+				if end == start+size {
+					single = true
+				}
+			}
+			_, n := utf8.DecodeRuneInString(word[end:])
+			if n == 0 {
+				break
+			}
+			end += n
+		}
+		if !single {
+			relax(start+size, -1, m.unkScore)
+		}
+		start += size
+	}
+
+	// Walk back from the end, then emit in order.
+	type span struct {
+		start, end int
+		id         int64
+	}
+	var spans []span
+	for end := len(word); end > 0; {
+		n := best[end]
+		spans = append(spans, span{n.start, end, n.id})
+		end = n.start
+	}
+
+	var ids []int64
+	for i := len(spans) - 1; i >= 0; i-- {
+		s := spans[i]
+		if s.id >= 0 {
+			ids = append(ids, s.id)
+			continue
+		}
+		// Fuse a run of unknown characters.
+		end := s.end
+		for i > 0 && spans[i-1].id < 0 {
+			i--
+			end = spans[i].end
+		}
+		unk, err := m.unknown(word[s.start:end])
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, unk...)
+	}
+	return ids, nil
+}
+
+// unknown returns the ids for text no piece covers: its bytes when byte
+// fallback is enabled and every byte has a token, the unknown id otherwise.
+func (m *unigram) unknown(text string) ([]int64, error) {
+	if m.byteFallback {
+		if ids, ok := byteIDs(m.ids, text); ok {
+			return ids, nil
+		}
+	}
+	if m.unkID < 0 {
+		return nil, fmt.Errorf("Unigram: %q is not in the vocabulary and there is no unk_id", text)
+	}
+	return []int64{m.unkID}, nil
+}
+
+// byteIDs maps the bytes of text to byte fallback tokens.
+func byteIDs(vocab map[string]int64, text string) ([]int64, bool) {
+	ids := make([]int64, len(text))
+	for i := 0; i < len(text); i++ {
+		id, ok := vocab[byteTokenName(text[i])]
+		if !ok {
+			return nil, false
+		}
+		ids[i] = id
+	}
+	return ids, true
+}
+
+// bpe is a byte-pair encoding model: a word starts as characters, and the
+// adjacent pair with the lowest merge rank is merged until none is left.
+type bpe struct {
+	ids          map[string]int64
+	vocab        []string
+	merges       map[[2]int64]bpeMerge
+	unkID        int64 // -1 when the model has no unknown token
+	prefix       string
+	suffix       string
+	fuseUnk      bool
+	byteFallback bool
+	ignoreMerges bool
+}
+
+type bpeMerge struct {
+	rank int
+	id   int64
+}
+
+func parseBPE(raw json.RawMessage) (*bpe, error) {
+	var v struct {
+		Vocab                   map[string]int64  `json:"vocab"`
+		Merges                  []json.RawMessage `json:"merges"`
+		UnkToken                *string           `json:"unk_token"`
+		ContinuingSubwordPrefix *string           `json:"continuing_subword_prefix"`
+		EndOfWordSuffix         *string           `json:"end_of_word_suffix"`
+		FuseUnk                 bool              `json:"fuse_unk"`
+		ByteFallback            bool              `json:"byte_fallback"`
+		IgnoreMerges            bool              `json:"ignore_merges"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("BPE: %w", err)
+	}
+
+	m := &bpe{
+		ids:          v.Vocab,
+		merges:       make(map[[2]int64]bpeMerge, len(v.Merges)),
+		unkID:        -1,
+		fuseUnk:      v.FuseUnk,
+		byteFallback: v.ByteFallback,
+		ignoreMerges: v.IgnoreMerges,
+	}
+	if v.ContinuingSubwordPrefix != nil {
+		m.prefix = *v.ContinuingSubwordPrefix
+	}
+	if v.EndOfWordSuffix != nil {
+		m.suffix = *v.EndOfWordSuffix
+	}
+
+	size := int64(0)
+	for _, id := range v.Vocab {
+		if id < 0 {
+			return nil, fmt.Errorf("BPE: negative id %d", id)
+		}
+		size = max(size, id+1)
+	}
+	m.vocab = make([]string, size)
+	for tok, id := range v.Vocab {
+		m.vocab[id] = tok
+	}
+
+	if v.UnkToken != nil {
+		id, ok := v.Vocab[*v.UnkToken]
+		if !ok {
+			return nil, fmt.Errorf("BPE: unk_token %q is not in the vocabulary", *v.UnkToken)
+		}
+		m.unkID = id
+	}
+
+	for rank, r := range v.Merges {
+		a, b, err := parseMerge(r)
+		if err != nil {
+			return nil, fmt.Errorf("BPE: merge %d: %w", rank, err)
+		}
+		aID, okA := v.Vocab[a]
+		bID, okB := v.Vocab[b]
+		merged, okM := v.Vocab[a+strings.TrimPrefix(b, m.prefix)]
+		if !okA || !okB || !okM {
+			return nil, fmt.Errorf("BPE: merge %d (%q %q) is not in the vocabulary", rank, a, b)
+		}
+		if _, dup := m.merges[[2]int64{aID, bID}]; !dup {
+			m.merges[[2]int64{aID, bID}] = bpeMerge{rank: rank, id: merged}
+		}
+	}
+	return m, nil
+}
+
+// parseMerge accepts both merge encodings: "a b" and ["a", "b"].
+func parseMerge(raw json.RawMessage) (string, string, error) {
+	var pair []string
+	if err := json.Unmarshal(raw, &pair); err == nil {
+		if len(pair) != 2 {
+			return "", "", fmt.Errorf("want 2 tokens, got %d", len(pair))
+		}
+		return pair[0], pair[1], nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", "", err
+	}
+	a, b, ok := strings.Cut(s, " ")
+	if !ok {
+		return "", "", fmt.Errorf("%q is not a pair", s)
+	}
+	return a, b, nil
+}
+
+func (m *bpe) tokens() []string { return m.vocab }
+
+func (m *bpe) tokenize(word string) ([]int64, error) {
+	if word == "" {
+		return nil, nil
+	}
+	if m.ignoreMerges {
+		if id, ok := m.ids[word]; ok {
+			return []int64{id}, nil
+		}
+	}
+
+	// Start from the characters of the word.
+	var symbols []int64
+	lastUnk := false
+	for i, r := range word {
+		s := string(r)
+		if i > 0 {
+			s = m.prefix + s
+		}
+		if i+utf8.RuneLen(r) == len(word) {
+			s += m.suffix
+		}
+		if id, ok := m.ids[s]; ok {
+			symbols = append(symbols, id)
+			lastUnk = false
+			continue
+		}
+		if m.byteFallback {
+			if ids, ok := byteIDs(m.ids, string(r)); ok {
+				symbols = append(symbols, ids...)
+				lastUnk = false
+				continue
+			}
+		}
+		if m.unkID < 0 {
+			// tokenizers drops characters it cannot represent.
+			continue
+		}
+		if !(m.fuseUnk && lastUnk) {
+			symbols = append(symbols, m.unkID)
+		}
+		lastUnk = true
+	}
+
+	// Merge the lowest ranked pair, leftmost first, until none is left.
+	for {
+		at, best := -1, bpeMerge{}
+		for i := 0; i+1 < len(symbols); i++ {
+			if mg, ok := m.merges[[2]int64{symbols[i], symbols[i+1]}]; ok && (at < 0 || mg.rank < best.rank) {
+				at, best = i, mg
+			}
+		}
+		if at < 0 {
+			return symbols, nil
+		}
+		symbols[at] = best.id
+		symbols = append(symbols[:at+1], symbols[at+2:]...)
+	}
+}
@@ -0,0 +1,547 @@
+package marian_v4
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The pipeline components of tokenizer.json. Each one is decoded from its
+// JSON object by "type"; types this package does not implement are
+// reported as errors rather than silently ignored.
+
+type component struct {
+	Type string `json:"type"`
+}
+
+func componentType(raw json.RawMessage) (string, error) {
+	var c component
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return "", err
+	}
+	return c.Type, nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	s := strings.TrimSpace(string(raw))
+	return s == "" || s == "null"
+}
+
+// pattern is the {"String": ...} or {"Regex": ...} object used by Replace.
+type pattern struct {
+	String *string `json:"String"`
+	Regex  *string `json:"Regex"`
+}
+
+// replacer returns a function that replaces every match of p by content.
+func (p pattern) replacer(content string) (func(string) string, error) {
+	switch {
+	case p.String != nil:
+		old := *p.String
+		if old == "" {
+			return func(s string) string { return s }, nil
+		}
+		return func(s string) string { return strings.ReplaceAll(s, old, content) }, nil
+	case p.Regex != nil:
+		re, err := regexp.Compile(*p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("Replace: %w", err)
+		}
+		return func(s string) string { return re.ReplaceAllLiteralString(s, content) }, nil
+	}
+	return nil, fmt.Errorf("Replace: pattern needs String or Regex")
+}
+
+// normalizer rewrites a text segment before pre-tokenization.
+type normalizer func(s string) string
+
+func parseNormalizer(raw json.RawMessage) (normalizer, error) {
+	if isNull(raw) {
+		return func(s string) string { return s }, nil
+	}
+	typ, err := componentType(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case "Sequence":
+		var v struct {
+			Normalizers []json.RawMessage `json:"normalizers"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		seq := make([]normalizer, len(v.Normalizers))
+		for i, r := range v.Normalizers {
+			if seq[i], err = parseNormalizer(r); err != nil {
+				return nil, err
+			}
+		}
+		return func(s string) string {
+			for _, n := range seq {
+				s = n(s)
+			}
+			return s
+		}, nil
+
+	case "Precompiled":
+		var v struct {
+			Charsmap []byte `json:"precompiled_charsmap"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		if len(v.Charsmap) == 0 {
+			return func(s string) string { return s }, nil
+		}
+		cm, err := parseCharsmap(v.Charsmap)
+		if err != nil {
+			return nil, err
+		}
+		return cm.normalize, nil
+
+	case "NFC":
+		return norm.NFC.String, nil
+	case "NFD":
+		return norm.NFD.String, nil
+	case "NFKC":
+		return norm.NFKC.String, nil
+	case "NFKD":
+		return norm.NFKD.String, nil
+	case "Lowercase":
+		return strings.ToLower, nil
+
+	case "Strip":
+		var v struct {
+			Left  bool `json:"strip_left"`
+			Right bool `json:"strip_right"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		return func(s string) string {
+			if v.Left {
+				s = strings.TrimLeftFunc(s, unicode.IsSpace)
+			}
+			if v.Right {
+				s = strings.TrimRightFunc(s, unicode.IsSpace)
+			}
+			return s
+		}, nil
+
+	case "Replace":
+		var v struct {
+			Pattern pattern `json:"pattern"`
+			Content string  `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		f, err := v.Pattern.replacer(v.Content)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+
+	case "Prepend":
+		var v struct {
+			Prepend string `json:"prepend"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		return func(s string) string {
+			if s == "" {
+				return s
+			}
+			return v.Prepend + s
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported normalizer %q", typ)
+}
+
+// preTokenizer splits a normalized segment into words. first reports
+// whether the segment starts the input text.
+type preTokenizer func(s string, first bool) []string
+
+func parsePreTokenizer(raw json.RawMessage) (preTokenizer, error) {
+	if isNull(raw) {
+		return func(s string, first bool) []string { return []string{s} }, nil
+	}
+	typ, err := componentType(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case "Sequence":
+		var v struct {
+			PreTokenizers []json.RawMessage `json:"pretokenizers"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		seq := make([]preTokenizer, len(v.PreTokenizers))
+		for i, r := range v.PreTokenizers {
+			if seq[i], err = parsePreTokenizer(r); err != nil {
+				return nil, err
+			}
+		}
+		return func(s string, first bool) []string {
+			words := []string{s}
+			for _, p := range seq {
+				var next []string
+				for i, w := range words {
+					next = append(next, p(w, first && i == 0)...)
+				}
+				words = next
+			}
+			return words
+		}, nil
+
+	case "Metaspace":
+		m, err := parseMetaspace(raw)
+		if err != nil {
+			return nil, err
+		}
+		return m.preTokenize, nil
+
+	case "WhitespaceSplit":
+		return func(s string, first bool) []string { return strings.Fields(s) }, nil
+
+	case "Whitespace":
+		return func(s string, first bool) []string { return wordsAndPunct.FindAllString(s, -1) }, nil
+	}
+	return nil, fmt.Errorf("unsupported pre-tokenizer %q", typ)
+}
+
+// wordsAndPunct is the Whitespace pre-tokenizer's \w+|[^\w\s]+ with
+// Unicode word characters.
+var wordsAndPunct = regexp.MustCompile(`[\p{L}\p{M}\p{N}_]+|[^\p{L}\p{M}\p{N}_\s]+`)
+
+type prependScheme int
+
+const (
+	prependAlways prependScheme = iota
+	prependNever
+	prependFirst
+)
+
+// metaspace is the Metaspace pre-tokenizer and decoder: spaces are
+// replaced by the replacement character (SentencePiece's "▁"), which is
+// also prepended to the text according to the prepend scheme.
+type metaspace struct {
+	replacement string
+	scheme      prependScheme
+	split       bool
+}
+
+func parseMetaspace(raw json.RawMessage) (*metaspace, error) {
+	var v struct {
+		Replacement    string `json:"replacement"`
+		PrependScheme  string `json:"prepend_scheme"`
+		AddPrefixSpace *bool  `json:"add_prefix_space"`
+		Split          *bool  `json:"split"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if utf8.RuneCountInString(v.Replacement) != 1 {
+		return nil, fmt.Errorf("Metaspace: replacement %q is not a single character", v.Replacement)
+	}
+
+	m := &metaspace{replacement: v.Replacement, split: v.Split == nil || *v.Split}
+	switch v.PrependScheme {
+	case "always":
+		m.scheme = prependAlways
+	case "never":
+		m.scheme = prependNever
+	case "first":
+		m.scheme = prependFirst
+	case "":
+		// Files written before prepend_scheme existed use add_prefix_space.
+		if v.AddPrefixSpace != nil && !*v.AddPrefixSpace {
+			m.scheme = prependNever
+		}
+	default:
+		return nil, fmt.Errorf("Metaspace: unknown prepend_scheme %q", v.PrependScheme)
+	}
+	return m, nil
+}
+
+func (m *metaspace) preTokenize(s string, first bool) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, " ", m.replacement)
+	if (m.scheme == prependAlways || (m.scheme == prependFirst && first)) && !strings.HasPrefix(s, m.replacement) {
+		s = m.replacement + s
+	}
+	if !m.split {
+		return []string{s}
+	}
+
+	// Split before every replacement character, which stays with the word
+	// it introduces.
+	var words []string
+	for len(s) > 0 {
+		i := strings.Index(s[len(m.replacement):], m.replacement)
+		if i < 0 {
+			words = append(words, s)
+			break
+		}
+		words = append(words, s[:len(m.replacement)+i])
+		s = s[len(m.replacement)+i:]
+	}
+	return words
+}
+
+// decode turns replacement characters back into spaces and removes the
+// prepended one. Like SentencePiece, and unlike tokenizers, it strips the
+// leading space of every token until one decodes to text, so a sentence
+// that starts with a lone "▁" piece does not start with a space. Tokens
+// that do not start the sentence (first is false) keep their spaces.
+func (m *metaspace) decode(tokens []string, first bool) []string {
+	out := make([]string, len(tokens))
+	leading := first && m.scheme != prependNever
+	for i, tok := range tokens {
+		tok = strings.ReplaceAll(tok, m.replacement, " ")
+		if leading {
+			tok = strings.TrimPrefix(tok, " ")
+			leading = tok == ""
+		}
+		out[i] = tok
+	}
+	return out
+}
+
+// decoder turns model tokens back into text pieces, which Decode
+// concatenates. first reports whether the tokens start the decoded text.
+type decoder func(tokens []string, first bool) []string
+
+func parseDecoder(raw json.RawMessage) (decoder, error) {
+	if isNull(raw) {
+		// Without a decoder, tokenizers joins the tokens with spaces.
+		return func(tokens []string, _ bool) []string { return []string{strings.Join(tokens, " ")} }, nil
+	}
+	typ, err := componentType(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case "Sequence":
+		var v struct {
+			Decoders []json.RawMessage `json:"decoders"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		seq := make([]decoder, len(v.Decoders))
+		for i, r := range v.Decoders {
+			if seq[i], err = parseDecoder(r); err != nil {
+				return nil, err
+			}
+		}
+		return func(tokens []string, first bool) []string {
+			for _, d := range seq {
+				tokens = d(tokens, first)
+			}
+			return tokens
+		}, nil
+
+	case "Metaspace":
+		m, err := parseMetaspace(raw)
+		if err != nil {
+			return nil, err
+		}
+		return m.decode, nil
+
+	case "ByteFallback":
+		return func(tokens []string, _ bool) []string { return decodeByteFallback(tokens) }, nil
+
+	case "Fuse":
+		return func(tokens []string, _ bool) []string { return []string{strings.Join(tokens, "")} }, nil
+
+	case "Replace":
+		var v struct {
+			Pattern pattern `json:"pattern"`
+			Content string  `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		f, err := v.Pattern.replacer(v.Content)
+		if err != nil {
+			return nil, err
+		}
+		return func(tokens []string, _ bool) []string {
+			out := make([]string, len(tokens))
+			for i, tok := range tokens {
+				out[i] = f(tok)
+			}
+			return out
+		}, nil
+
+	case "Strip":
+		var v struct {
+			Content string `json:"content"`
+			Start   int    `json:"start"`
+			Stop    int    `json:"stop"`
+		}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		return func(tokens []string, _ bool) []string {
+			out := make([]string, len(tokens))
+			for i, tok := range tokens {
+				for n := 0; n < v.Start && strings.HasPrefix(tok, v.Content); n++ {
+					tok = tok[len(v.Content):]
+				}
+				for n := 0; n < v.Stop && strings.HasSuffix(tok, v.Content); n++ {
+					tok = tok[:len(tok)-len(v.Content)]
+				}
+				out[i] = tok
+			}
+			return out
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported decoder %q", typ)
+}
+
+// decodeByteFallback turns runs of <0xXX> tokens back into the bytes they
+// stand for. A run that is not valid UTF-8 becomes one U+FFFD per byte.
+func decodeByteFallback(tokens []string) []string {
+	out := make([]string, 0, len(tokens))
+	var pending []byte
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if utf8.Valid(pending) {
+			out = append(out, string(pending))
+		} else {
+			out = append(out, strings.Repeat(string(utf8.RuneError), len(pending)))
+		}
+		pending = pending[:0]
+	}
+	for _, tok := range tokens {
+		if b, ok := byteToken(tok); ok {
+			pending = append(pending, b)
+			continue
+		}
+		flush()
+		out = append(out, tok)
+	}
+	flush()
+	return out
+}
+
+// byteToken parses a byte fallback token such as <0x0A>.
+func byteToken(tok string) (byte, bool) {
+	if len(tok) != 6 || !strings.HasPrefix(tok, "<0x") || tok[5] != '>' {
+		return 0, false
+	}
+	var b byte
+	for _, c := range tok[3:5] {
+		switch {
+		case c >= '0' && c <= '9':
+			b = b<<4 | byte(c-'0')
+		case c >= 'A' && c <= 'F':
+			b = b<<4 | byte(c-'A'+10)
+		default:
+			return 0, false
+		}
+	}
+	return b, true
+}
+
+// byteTokenName is the vocabulary entry of byte b in byte fallback models.
+func byteTokenName(b byte) string {
+	return fmt.Sprintf("<0x%02X>", b)
+}
+
+// template is a TemplateProcessing post-processor. Only the single
+// sequence template is used: Marian encodes one sentence at a time.
+type template struct {
+	single []templatePiece
+}
+
+type templatePiece struct {
+	sequence bool    // the $A sequence
+	ids      []int64 // the ids of a special token otherwise
+}
+
+// specials returns the number of special token ids the template adds.
+func (t *template) specials() int {
+	n := 0
+	for _, p := range t.single {
+		n += len(p.ids)
+	}
+	return n
+}
+
+// apply wraps the ids of a sentence in the template.
+func (t *template) apply(ids []int64) []int64 {
+	out := make([]int64, 0, len(ids)+len(t.single))
+	for _, p := range t.single {
+		if p.sequence {
+			out = append(out, ids...)
+		} else {
+			out = append(out, p.ids...)
+		}
+	}
+	return out
+}
+
+func parsePostProcessor(raw json.RawMessage) (*template, error) {
+	if isNull(raw) {
+		return nil, nil
+	}
+	typ, err := componentType(raw)
+	if err != nil {
+		return nil, err
+	}
+	if typ != "TemplateProcessing" {
+		return nil, fmt.Errorf("unsupported post-processor %q", typ)
+	}
+
+	var v struct {
+		Single []struct {
+			Sequence *struct {
+				ID string `json:"id"`
+			} `json:"Sequence"`
+			SpecialToken *struct {
+				ID string `json:"id"`
+			} `json:"SpecialToken"`
+		} `json:"single"`
+		SpecialTokens map[string]struct {
+			IDs []int64 `json:"ids"`
+		} `json:"special_tokens"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	t := &template{}
+	for _, p := range v.Single {
+		switch {
+		case p.Sequence != nil:
+			t.single = append(t.single, templatePiece{sequence: true})
+		case p.SpecialToken != nil:
+			special, ok := v.SpecialTokens[p.SpecialToken.ID]
+			if !ok {
+				return nil, fmt.Errorf("TemplateProcessing: special token %q has no ids", p.SpecialToken.ID)
+			}
+			t.single = append(t.single, templatePiece{ids: special.IDs})
+		default:
+			return nil, fmt.Errorf("TemplateProcessing: unknown template piece")
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,447 @@
+// Package marian_v4 is a pure-Go Marian tokenizer that runs the HuggingFace
+// tokenizer.json pipeline: added tokens, normalizer, pre-tokenizer, Unigram
+// or BPE model, post-processor and decoder.
+//
+// It serves models that ship tokenizer.json instead of the SentencePiece
+// files the other backends need, and needs neither cgo nor a native
+// library. Only the components Marian-style tokenizers use are implemented;
+// loading a file with any other component fails with an error naming it.
+package marian_v4
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/techwithsergiu/marian_tokenizer_go/marian"
+)
+
+// Tokenizer is a tokenizer.json-backed tokenizer implementation.
+type Tokenizer struct {
+	config marian.Config
+
+	added     []addedToken // longest content first
+	normalize normalizer
+	preTok    preTokenizer
+	model     model
+	post      *template
+	truncate  *truncation
+	decode    decoder
+	id2token  []string
+	unkID     int64
+	closed    atomic.Bool
+}
+
+// Ensure Tokenizer satisfies the common interface.
+var _ marian.Tokenizer = (*Tokenizer)(nil)
+
+type addedToken struct {
+	ID      int64  `json:"id"`
+	Content string `json:"content"`
+	LStrip  bool   `json:"lstrip"`
+	RStrip  bool   `json:"rstrip"`
+}
+
+// tokenizerFile is the part of tokenizer.json this package reads.
+type tokenizerFile struct {
+	AddedTokens   []addedToken    `json:"added_tokens"`
+	Normalizer    json.RawMessage `json:"normalizer"`
+	PreTokenizer  json.RawMessage `json:"pre_tokenizer"`
+	PostProcessor json.RawMessage `json:"post_processor"`
+	Decoder       json.RawMessage `json:"decoder"`
+	Model         json.RawMessage `json:"model"`
+	Truncation    *truncation     `json:"truncation"`
+}
+
+// truncation is the truncation block of tokenizer.json. Marian encodes one
+// sentence at a time, so the strategy and stride do not apply.
+type truncation struct {
+	MaxLength int    `json:"max_length"`
+	Direction string `json:"direction"`
+}
+
+// Register as "v4" for marian.Open, last in line: it needs tokenizer.json.
+func init() {
+	marian.Register("v4", 0, NewTokenizer)
+}
+
+// NewTokenizer creates a tokenizer from a model directory containing
+// tokenizer.json. The configuration is read from config.json when present;
+// otherwise it is derived from the vocabulary (</s> as EOS, <pad> as pad and
+// decoder start token) and tokenizer_config.json's model_max_length.
+//
+// WithUnkToken replaces the model's unknown token for both encoding and
+// Decode's skip list. WithModelMaxLength also replaces the max_length of
+// the file's truncation block. Of the names in WithFiles, only Config
+// applies.
+func NewTokenizer(modelDir string, opts ...marian.Option) (marian.Tokenizer, error) {
+	o := marian.ApplyOptions(opts...)
+	path := filepath.Join(modelDir, "tokenizer.json")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("marian_v4: %w", err)
+	}
+	t, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("marian_v4: %s: %w", path, err)
+	}
+	if t.config, err = loadConfig(modelDir, o.Files.Config, t.id2token); err != nil {
+		return nil, fmt.Errorf("marian_v4: %w", err)
+	}
+	if err := t.applyOptions(o); err != nil {
+		return nil, fmt.Errorf("marian_v4: %s: %w", modelDir, err)
+	}
+	return t, nil
+}
+
+// applyOptions applies the unk token override to the model and the other
+// options to the configuration.
+func (t *Tokenizer) applyOptions(o marian.Options) error {
+	if o.UnkToken != "" {
+		id := slices.Index(t.id2token, o.UnkToken)
+		if id < 0 {
+			return fmt.Errorf("unk token %q is not in the vocabulary", o.UnkToken)
+		}
+		t.unkID = int64(id)
+		switch m := t.model.(type) {
+		case *unigram:
+			m.unkID = t.unkID
+		case *bpe:
+			m.unkID = t.unkID
+		}
+	}
+	if t.truncate != nil && o.ModelMaxLength > 0 {
+		t.truncate.MaxLength = o.ModelMaxLength
+	}
+	t.config.UnkTokenID = t.unkID
+	return o.ApplyTo(&t.config)
+}
+
+func parse(b []byte) (*Tokenizer, error) {
+	var f tokenizerFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if isNull(f.Model) {
+		return nil, errors.New("no model")
+	}
+
+	t := &Tokenizer{}
+	var err error
+	if t.normalize, err = parseNormalizer(f.Normalizer); err != nil {
+		return nil, err
+	}
+	if t.preTok, err = parsePreTokenizer(f.PreTokenizer); err != nil {
+		return nil, err
+	}
+	if t.model, err = parseModel(f.Model); err != nil {
+		return nil, err
+	}
+	if t.post, err = parsePostProcessor(f.PostProcessor); err != nil {
+		return nil, err
+	}
+	if t.decode, err = parseDecoder(f.Decoder); err != nil {
+		return nil, err
+	}
+	if tr := f.Truncation; tr != nil {
+		if tr.MaxLength < 1 {
+			return nil, fmt.Errorf("truncation: max_length %d is not positive", tr.MaxLength)
+		}
+		if tr.Direction != "Right" && tr.Direction != "Left" {
+			return nil, fmt.Errorf("truncation: unknown direction %q", tr.Direction)
+		}
+		t.truncate = tr
+	}
+
+	// Added tokens may extend the model vocabulary.
+	t.id2token = append([]string(nil), t.model.tokens()...)
+	for _, a := range f.AddedTokens {
+		if a.ID < 0 {
+			return nil, fmt.Errorf("added token %q has negative id %d", a.Content, a.ID)
+		}
+		for int64(len(t.id2token)) <= a.ID {
+			t.id2token = append(t.id2token, "")
+		}
+		t.id2token[a.ID] = a.Content
+		if a.Content != "" {
+			t.added = append(t.added, a)
+		}
+	}
+	sort.SliceStable(t.added, func(i, j int) bool { return len(t.added[i].Content) > len(t.added[j].Content) })
+
+	t.unkID = -1
+	switch m := t.model.(type) {
+	case *unigram:
+		t.unkID = m.unkID
+	case *bpe:
+		t.unkID = m.unkID
+	}
+	return t, nil
+}
+
+// loadConfig reads config.json, or the explicit configPath, or derives the
+// configuration from the vocabulary when the directory has no config.json.
+func loadConfig(modelDir, configPath string, id2token []string) (marian.Config, error) {
+	var cfg marian.Config
+	explicit := configPath != ""
+	if !explicit {
+		configPath = "config.json"
+	}
+	if !filepath.IsAbs(configPath) {
+		configPath = filepath.Join(modelDir, configPath)
+	}
+	b, err := os.ReadFile(configPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return cfg, fmt.Errorf("load config: %w", err)
+		}
+		cfg.NormalizeConfig()
+		return cfg, nil
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		return cfg, fmt.Errorf("load config: %w", err)
+	}
+
+	idOf := func(token string, def int64) int64 {
+		for id, tok := range id2token {
+			if tok == token {
+				return int64(id)
+			}
+		}
+		return def
+	}
+	eos := idOf("</s>", 0)
+	pad := idOf("<pad>", eos)
+
+	maxLen := 512
+	if b, err := os.ReadFile(filepath.Join(modelDir, "tokenizer_config.json")); err == nil {
+		var tc struct {
+			ModelMaxLength float64 `json:"model_max_length"`
+		}
+		// transformers writes a huge sentinel when the length is unset.
+		if json.Unmarshal(b, &tc) == nil && tc.ModelMaxLength >= 1 && tc.ModelMaxLength <= 1<<20 {
+			maxLen = int(tc.ModelMaxLength)
+		}
+	}
+
+	cfg = marian.Config{
+		VocabSize:           len(id2token),
+		DecoderVocabSize:    len(id2token),
+		EosTokenID:          eos,
+		BosTokenID:          eos,
+		PadTokenID:          pad,
+		DecoderStartTokenID: pad,
+		MaxLength:           maxLen,
+		ModelMaxLength:      maxLen,
+		BadWordsIDs:         [][]int{{int(pad)}},
+	}
+	return cfg, nil
+}
+
+// Close marks the tokenizer closed; later calls fail with marian.ErrClosed.
+// There are no native resources to release, so calls in progress are not
+// waited for.
+func (t *Tokenizer) Close() {
+	t.closed.Store(true)
+}
+
+// Config returns the tokenizer configuration.
+//
+// The returned pointer refers to the tokenizer's internal copy and must not
+// be modified by the caller.
+func (t *Tokenizer) Config() (*marian.Config, error) {
+	return &t.config, nil
+}
+
+// Token returns the vocabulary piece or added token of id, or "" if the id
+// is out of range or unused.
+func (t *Tokenizer) Token(id int64) string {
+	if id < 0 || id >= int64(len(t.id2token)) {
+		return ""
+	}
+	return t.id2token[id]
+}
+
+// segment is a part of the input text: either an added token or text for
+// the model.
+type segment struct {
+	text  string
+	start int // byte offset in the input
+	id    int64
+	added bool
+}
+
+// split cuts text at added tokens, leftmost and longest match first.
+func (t *Tokenizer) split(text string) []segment {
+	var segs []segment
+	last := 0
+	for i := 0; i < len(text); {
+		var match *addedToken
+		for j := range t.added {
+			if strings.HasPrefix(text[i:], t.added[j].Content) {
+				match = &t.added[j]
+				break
+			}
+		}
+		if match == nil {
+			i++
+			continue
+		}
+
+		before := text[last:i]
+		if match.LStrip {
+			before = strings.TrimRightFunc(before, unicode.IsSpace)
+		}
+		if before != "" {
+			segs = append(segs, segment{text: before, start: last})
+		}
+		segs = append(segs, segment{id: match.ID, start: i, added: true})
+		i += len(match.Content)
+		if match.RStrip {
+			i = len(text) - len(strings.TrimLeftFunc(text[i:], unicode.IsSpace))
+		}
+		last = i
+	}
+	if last < len(text) {
+		segs = append(segs, segment{text: text[last:], start: last})
+	}
+	return segs
+}
+
+// Encode encodes a single source sentence into token IDs.
+// If addEOS is true, the post-processor template (normally "$A </s>") is
+// applied, or EOS is appended when the file has none.
+//
+// When tokenizer.json has a truncation block, the pieces are cut like
+// tokenizers does: to max_length less the special tokens addEOS adds, from
+// the side the block names, so a long sentence keeps its </s>. Otherwise,
+// like the Marian core, the result is cut to model_max_length after EOS is
+// appended.
+func (t *Tokenizer) Encode(text string, addEOS bool) ([]int64, error) {
+	if t.closed.Load() {
+		return nil, marian.ErrClosed
+	}
+	maxTokens := t.config.ModelMaxLength
+	if maxTokens <= 0 {
+		return nil, fmt.Errorf("model_max_length is not positive")
+	}
+
+	var ids []int64
+	for _, seg := range t.split(text) {
+		if seg.added {
+			ids = append(ids, seg.id)
+			continue
+		}
+		for _, word := range t.preTok(t.normalize(seg.text), seg.start == 0) {
+			wordIDs, err := t.model.tokenize(word)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, wordIDs...)
+		}
+	}
+
+	if t.truncate != nil {
+		n := t.truncate.MaxLength
+		if addEOS {
+			n -= t.specials()
+		}
+		n = max(n, 0)
+		if len(ids) > n {
+			if t.truncate.Direction == "Left" {
+				ids = ids[len(ids)-n:]
+			} else {
+				ids = ids[:n]
+			}
+		}
+	}
+
+	if addEOS {
+		if t.post != nil {
+			ids = t.post.apply(ids)
+		} else {
+			ids = append(ids, t.config.EosTokenID)
+		}
+	}
+
+	if t.truncate == nil && len(ids) > maxTokens {
+		ids = ids[:maxTokens]
+	}
+	return ids, nil
+}
+
+// specials returns the number of special tokens addEOS adds.
+func (t *Tokenizer) specials() int {
+	if t.post != nil {
+		return t.post.specials()
+	}
+	return 1
+}
+
+// EncodeBatch encodes a batch of sentences and returns:
+//   - inputIDs: shape (batch, maxLen)
+//   - attentionMask: shape (batch, maxLen) with 1 for tokens and 0 for padding.
+func (t *Tokenizer) EncodeBatch(texts []string) ([][]int64, [][]int64, error) {
+	if t.closed.Load() {
+		return nil, nil, marian.ErrClosed
+	}
+	rows := make([][]int64, len(texts))
+	for i, text := range texts {
+		ids, err := t.Encode(text, !t.config.NoBatchEOS)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows[i] = ids
+	}
+	inputIDs, attn := marian.PadBatch(rows, t.config.PadTokenID)
+	return inputIDs, attn, nil
+}
+
+// unkSurface is the text SentencePiece decodes the unknown token to.
+const unkSurface = " \u2047 "
+
+// Decode converts token IDs back to a target sentence.
+// If skipSpecial is true, Config().SkipTokenIDs (by default EOS / PAD / UNK)
+// are removed before decoding.
+//
+// Like the SentencePiece-based backends, EOS decodes to nothing and the
+// unknown token to " ⁇ ". IDs outside the vocabulary are not skipped but
+// decode as the unknown token, also when skipSpecial is true.
+func (t *Tokenizer) Decode(ids []int64, skipSpecial bool) (string, error) {
+	if t.closed.Load() {
+		return "", marian.ErrClosed
+	}
+
+	var sb strings.Builder
+	tokens := make([]string, 0, len(ids))
+	flush := func() {
+		if len(tokens) > 0 {
+			for _, s := range t.decode(tokens, sb.Len() == 0) {
+				sb.WriteString(s)
+			}
+			tokens = tokens[:0]
+		}
+	}
+	for _, id := range ids {
+		if skipSpecial && slices.Contains(t.config.SkipTokenIDs, id) {
+			continue
+		}
+		switch {
+		case id == t.config.EosTokenID:
+		case id == t.unkID || id < 0 || id >= int64(len(t.id2token)) || t.id2token[id] == "":
+			flush()
+			sb.WriteString(unkSurface)
+		default:
+			tokens = append(tokens, t.id2token[id])
+		}
+	}
+	flush()
+	return sb.String(), nil
+}
@@ -20,3 +20,50 @@ make -C third_party/marian-tokenizer-core  \
 cd ./third_party/marian-tokenizer-core
 cp -r ./deps/sentencepiece/** ../../deps/sentencepiece
 cp -r ./build/** ../../deps/marian_tokenizer_core
+cd ../..
+
+# ========================
+# Patch
+# The Go bindings extend the marian_tok_* ABI (see patches/marian-tokenizer-core).
+# Apply the patches to the copied sources and rebuild the libraries from them,
+# so libmarian_core matches marian_core.h and the v2 build.
+TARGET_OS="${TARGET_OS:-$(go env GOOS)}"
+TARGET_ARCH="${TARGET_ARCH:-$(go env GOARCH)}"
+TARGET="${TARGET_OS}_${TARGET_ARCH}"
+
+CORE=./deps/marian_tokenizer_core
+SP=./deps/sentencepiece
+LIB="${CORE}/${TARGET}/lib"
+
+for p in ./patches/marian-tokenizer-core/*.patch; do
+    patch -p1 -d "${CORE}" < "${p}"
+done
+
+case "${TARGET_OS}" in
+    windows)
+        CXX="${CXX:-x86_64-w64-mingw32-g++}"
+        SHARED="${LIB}/libmarian_core.dll"
+        SHARED_FLAGS="-static-libstdc++ -static-libgcc"
+        ;;
+    darwin)
+        CXX="${CXX:-clang++}"
+        SHARED="${LIB}/libmarian_core.dylib"
+        SHARED_FLAGS=""
+        ;;
+    *)
+        CXX="${CXX:-g++}"
+        SHARED="${LIB}/libmarian_core.so"
+        SHARED_FLAGS=""
+        ;;
+esac
+CXXFLAGS="-std=c++17 -O2 -fPIC -I${CORE}/include -I${SP}/include"
+
+mkdir -p "${LIB}/static"
+"${CXX}" ${CXXFLAGS} -c "${CORE}/src/marian_core.cc" -o "${LIB}/static/marian_core.o"
+rm -f "${LIB}/static/libmarian_core.a"
+ar rcs "${LIB}/static/libmarian_core.a" "${LIB}/static/marian_core.o"
+rm -f "${LIB}/static/marian_core.o"
+
+"${CXX}" ${CXXFLAGS} -shared "${CORE}/src/marian_core.cc" \
+    -L"${SP}/${TARGET}/lib/static" -lsentencepiece ${SHARED_FLAGS} \
+    -o "${SHARED}"